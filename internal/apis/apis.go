@@ -1,51 +1,161 @@
 package apis
 
 import (
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/admin"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/audit"
 	"github.com/Vinayakatk/marketplace-prototype/internal/services/billing"
 	"github.com/Vinayakatk/marketplace-prototype/internal/services/catalog"
 	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/notifications"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/orgs"
 	"github.com/Vinayakatk/marketplace-prototype/internal/services/projects"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/quotas"
 	"github.com/Vinayakatk/marketplace-prototype/internal/services/users"
 	"github.com/go-chi/chi/v5"
 )
 
 func RegisterRoutes(r *chi.Mux) {
-	// User routes
-	r.Route("/api/users", func(r chi.Router) {
-		r.Post("/new", users.CreateUser) // Create a new user
-		r.Get("/", users.ListUsers)      // List all users
+	// Every request gets an ID, recorded in the audit log
+	r.Use(middleware.RequestID)
 
-		r.Get("/{id}/projects", projects.ListProjects) // List projects of a user
-		r.Get("/{id}/deployments", deployments.ListUserDeployments)
-	})
+	// Sign up, returns the first API key of the user
+	r.With(audit.Log).Post("/api/users/new", users.CreateUser)
 
-	// Project routes
-	r.Route("/api/user/project", func(r chi.Router) {
-		r.Post("/new", projects.CreateProject)                        // Create a new project
-		r.Get("/{id}/deployments", projects.GetDeploymentsOfAProject) // Get deployments of a project
-		r.Delete("/{id}", projects.DeleteProject)                     // Delete project
-	})
+	// Every other route requires an API key or a bearer token; mutating calls are audited
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Authenticate)
+		r.Use(audit.Log)
 
-	// Application catalog routes
-	r.Route("/api/apps", func(r chi.Router) {
-		r.Post("/new", catalog.AddApplication)       // Add a new application
-		r.Get("/", catalog.ListApplications)         // List all applications
-		r.Get("/{id}", catalog.GetApplication)       // Get app details
-		r.Put("/{id}", catalog.UpdateApplication)    // Update app
-		r.Delete("/{id}", catalog.DeleteApplication) // Delete app
-	})
+		// Role checks, admins pass all of them
+		admins := middleware.RequireRole(middleware.RoleAdmin)
+		publishers := middleware.RequireRole(middleware.RolePublisher)
+		consumers := middleware.RequireRole(middleware.RoleConsumer)
 
-	// Deployment routes
-	r.Route("/api/deployments", func(r chi.Router) {
-		r.Post("/install", deployments.DeployApplication) // Deploy an application
+		// User routes
+		r.Route("/api/users", func(r chi.Router) {
+			r.With(admins).Get("/", users.ListUsers) // List all users
 
-		r.Get("/{id}", deployments.GetDeployment)       // Get deployment details
-		r.Delete("/{id}", deployments.DeleteDeployment) // Remove deployment
-	})
+			r.Post("/me/api-keys", users.CreateAPIKey)           // Create an API key
+			r.Get("/me/api-keys", users.ListAPIKeys)             // List API keys
+			r.Delete("/me/api-keys/{keyID}", users.RevokeAPIKey) // Revoke an API key
+			r.Get("/me/invites", orgs.ListMyInvites)             // List pending invites into organizations
+
+			r.Get("/me/notifications", notifications.ListNotifications)                           // List notifications, newest first
+			r.Post("/me/notifications/{notificationID}/read", notifications.MarkNotificationRead) // Mark a notification read
+
+			r.Get("/{id}/projects", projects.ListProjects) // List projects of a user
+			r.Get("/{id}/deployments", deployments.ListUserDeployments)
+			r.Get("/{id}/quota", quotas.GetUserUsage) // Quota usage of a user
+		})
+
+		// Authentication routes
+		r.Route("/api/auth", func(r chi.Router) {
+			r.Post("/token", users.IssueToken) // Exchange an API key for a bearer token
+		})
+
+		// Organization routes
+		r.Route("/api/orgs", func(r chi.Router) {
+			r.Post("/", orgs.CreateOrganization)       // Create an organization, the caller becomes its owner
+			r.Get("/", orgs.ListOrganizations)         // List organizations of the caller
+			r.Get("/{id}", orgs.GetOrganization)       // Get organization details and members
+			r.Delete("/{id}", orgs.DeleteOrganization) // Delete an organization without projects
+
+			r.Put("/{id}/members/{userID}", orgs.UpdateMember)    // Change the role of a member
+			r.Delete("/{id}/members/{userID}", orgs.RemoveMember) // Remove a member, or leave
+
+			r.Post("/{id}/invites", orgs.CreateInvite)                // Invite a user
+			r.Get("/{id}/invites", orgs.ListOrganizationInvites)      // List invites
+			r.Delete("/{id}/invites/{inviteID}", orgs.RevokeInvite)   // Revoke a pending invite
+			r.Post("/invites/{inviteID}/accept", orgs.AcceptInvite)   // Accept an invite
+			r.Post("/invites/{inviteID}/decline", orgs.DeclineInvite) // Decline an invite
+
+			r.Get("/{id}/projects", projects.ListOrganizationProjects)          // List projects of an organization
+			r.Get("/{id}/deployments", deployments.ListOrganizationDeployments) // List deployments in its projects
+			r.Get("/{id}/quota", quotas.GetOrganizationUsage)                   // Quota usage of an organization
+		})
+
+		// Project routes
+		r.Route("/api/user/project", func(r chi.Router) {
+			r.With(consumers, middleware.Idempotency).Post("/new", projects.CreateProject) // Create a new project
+			r.Get("/{id}/deployments", projects.GetDeploymentsOfAProject)                  // Get deployments of a project
+			r.Get("/{id}/quota", quotas.GetProjectUsage)                                   // Quota usage of a project
+			r.Delete("/{id}", projects.DeleteProject)                                      // Delete project
+		})
+
+		// Application catalog routes
+		r.Route("/api/apps", func(r chi.Router) {
+			r.With(publishers, middleware.Idempotency).Post("/new", catalog.AddApplication) // Add a new application
+			r.Get("/", catalog.ListApplications)                                            // Search applications
+			r.Get("/categories", catalog.ListCategories)                                    // List categories
+			r.Get("/{id}", catalog.GetApplication)                                          // Get app details
+			r.Put("/{id}", catalog.UpdateApplication)                                       // Update app
+			r.Delete("/{id}", catalog.DeleteApplication)                                    // Delete app
+
+			r.Post("/{id}/versions", catalog.AddApplicationVersion)                            // Release a new version
+			r.Get("/{id}/versions", catalog.ListApplicationVersions)                           // List versions, newest first
+			r.Get("/{id}/versions/{versionID}", catalog.GetApplicationVersion)                 // Get version details
+			r.Put("/{id}/versions/{versionID}/status", catalog.UpdateApplicationVersionStatus) // Submit, withdraw or deprecate a version
+			r.Get("/{id}/versions/{versionID}/reviews", catalog.ListVersionReviews)            // Review history of a version
+			r.Post("/{id}/versions/{versionID}/verify", catalog.VerifyApplicationVersion)      // Verify the chart of a version
+
+			r.Get("/{id}/versions/{versionID}/plans", catalog.ListPricingPlans)              // List pricing plans of a version
+			r.Post("/{id}/versions/{versionID}/plans", catalog.AddPricingPlan)               // Offer a pricing plan on a draft version
+			r.Delete("/{id}/versions/{versionID}/plans/{planID}", catalog.DeletePricingPlan) // Withdraw a pricing plan from a draft version
+		})
+
+		// Deployment routes
+		r.Route("/api/deployments", func(r chi.Router) {
+			r.With(consumers, middleware.Idempotency).Post("/install", deployments.DeployApplication) // Deploy an application
+
+			r.Get("/{id}", deployments.GetDeployment)                                    // Get deployment details
+			r.Get("/{id}/events", deployments.ListDeploymentEvents)                      // Get deployment status history
+			r.With(middleware.Idempotency).Delete("/{id}", deployments.DeleteDeployment) // Remove deployment
+
+			r.With(middleware.Idempotency).Post("/{id}/upgrade", deployments.UpgradeDeployment)   // Upgrade to another version and/or values
+			r.With(middleware.Idempotency).Post("/{id}/rollback", deployments.RollbackDeployment) // Roll back to a previous revision
+			r.Get("/{id}/revisions", deployments.ListDeploymentRevisions)                         // List revisions, newest first
+
+			r.Put("/{id}/trial", deployments.UpdateTrial) // Convert or uninstall when the free trial ends
+		})
+
+		// Billing apis
+		r.Route("/api/billing", func(r chi.Router) {
+			r.Get("/user/{consumerID}/deployment/{deploymentID}", billing.GetBillingByUserAndDeployment)
+			r.Get("/user/{id}", billing.GetUserBilling)
+			r.Get("/project/{id}", billing.GetProjectBilling)            // Billing of the deployments in a project
+			r.Get("/org/{id}", billing.GetOrganizationBilling)           // Billing of the deployments in an organization
+			r.Get("/deployments/{id}/usage", billing.GetDeploymentUsage) // Usage events of a deployment and their charges
+		})
+
+		// Audit log, exported as JSON lines with ?format=jsonl
+		r.With(admins).Get("/api/audit", audit.ListAuditLog)
+
+		// Admin apis
+		r.Route("/api/admin", func(r chi.Router) {
+			r.Use(admins)
+
+			r.Put("/users/{id}/role", admin.SetUserRole)      // Change the role of a user
+			r.Post("/users/{id}/api-keys", users.IssueAPIKey) // Issue an API key to a user
+
+			r.Get("/dlq/{queue}", admin.ListDeadLetters)                 // List dead-lettered jobs
+			r.Delete("/dlq/{queue}", admin.PurgeDeadLetters)             // Purge all dead-lettered jobs
+			r.Get("/dlq/{queue}/{id}", admin.GetDeadLetter)              // Inspect a dead-lettered job
+			r.Post("/dlq/{queue}/{id}/requeue", admin.RequeueDeadLetter) // Push a job back to its queue
+			r.Delete("/dlq/{queue}/{id}", admin.PurgeDeadLetter)         // Purge a dead-lettered job
+
+			r.Get("/workers", admin.ListWorkers) // Worker pools and in-flight jobs
+
+			r.Post("/secrets/rotate", admin.RotateSecrets) // Rewrap deployment secrets with the active master key
+
+			r.Put("/quotas/{scope}/{id}", admin.SetQuota)       // Override the quota of a user, project or organization
+			r.Delete("/quotas/{scope}/{id}", admin.DeleteQuota) // Remove an override, the defaults apply again
+
+			r.Post("/deployments/{id}/usage-events", admin.AppendUsageEvent) // Correct the billing of a deployment
 
-	// Billing apis
-	r.Route("/api/billing", func(r chi.Router) {
-		r.Get("/user/{consumerID}/deployment/{deploymentID}", billing.GetBillingByUserAndDeployment)
-		r.Get("/user/{id}", billing.GetUserBilling)
+			r.Get("/reviews", catalog.ListReviewQueue)                     // Versions waiting for review, oldest first
+			r.Post("/reviews/{versionID}/approve", catalog.ApproveVersion) // Publish a version
+			r.Post("/reviews/{versionID}/reject", catalog.RejectVersion)   // Reject a version with a comment
+		})
 	})
 }
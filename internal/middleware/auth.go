@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/apikey"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/jwt"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIKeyHeader is an alternative to "Authorization: Bearer <API key>"
+const APIKeyHeader = "X-API-Key"
+
+// Authentication methods
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// lastUsedResolution limits how often the last use of an API key is written
+const lastUsedResolution = time.Minute
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	UserID   uint
+	Role     string // Current role of the user, see Roles
+	Method   string // MethodAPIKey or MethodJWT
+	APIKeyID uint   // Only set for MethodAPIKey
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the identity of the caller
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// CurrentIdentity returns the identity the request was authenticated with
+func CurrentIdentity(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// UserID returns the ID of the authenticated user of a request, 0 if none
+func UserID(r *http.Request) uint {
+	identity, _ := CurrentIdentity(r.Context())
+	return identity.UserID
+}
+
+// Authenticate rejects requests without a valid API key or bearer token with 401
+// and puts the identity of the caller in the request context. API keys are sent
+// as "Authorization: Bearer mpk_..." or in X-API-Key; any other bearer token is
+// verified as a JWT signed with AUTH_JWT_SECRET.
+func Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		credential := r.Header.Get(APIKeyHeader)
+		if credential == "" {
+			scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+			if ok && strings.EqualFold(scheme, "Bearer") {
+				credential = strings.TrimSpace(token)
+			}
+		}
+		if credential == "" {
+			unauthorized(w, "Missing API key or bearer token")
+			return
+		}
+
+		var identity Identity
+		var err error
+		if apikey.IsAPIKey(credential) {
+			identity, err = authenticateAPIKey(credential)
+		} else {
+			identity, err = authenticateJWT(credential)
+		}
+		if err != nil {
+			unauthorized(w, "Unauthorized: "+err.Error())
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), identity)))
+	})
+}
+
+var errInvalidAPIKey = errors.New("invalid API key")
+
+// Lookups of the stored credentials, replaced in tests
+var (
+	findAPIKey = func(hash string) (models.APIKey, error) {
+		var stored models.APIKey
+		err := database.DB.Where("key_hash = ?", hash).First(&stored).Error
+		return stored, err
+	}
+	findUser = func(id uint) (models.User, error) {
+		var user models.User
+		err := database.DB.Select("id", "role").First(&user, id).Error
+		return user, err
+	}
+	touchAPIKey = func(stored models.APIKey, now time.Time) error {
+		return database.DB.Model(&stored).Update("last_used_at", now).Error
+	}
+)
+
+func authenticateAPIKey(key string) (Identity, error) {
+	stored, err := findAPIKey(apikey.Hash(key))
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Println("❌ Failed to look up API key:", err)
+		}
+		return Identity{}, errInvalidAPIKey
+	}
+
+	now := time.Now()
+	if stored.RevokedAt != nil {
+		return Identity{}, errors.New("API key was revoked")
+	}
+	if stored.ExpiresAt != nil && !now.Before(*stored.ExpiresAt) {
+		return Identity{}, errors.New("API key is expired")
+	}
+
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) >= lastUsedResolution {
+		if err := touchAPIKey(stored, now); err != nil {
+			log.Println("⚠️ Failed to record API key use:", err)
+		}
+	}
+
+	user, err := findUser(stored.UserID)
+	if err != nil {
+		return Identity{}, errInvalidAPIKey
+	}
+
+	return Identity{UserID: user.ID, Role: user.Role, Method: MethodAPIKey, APIKeyID: stored.ID}, nil
+}
+
+func authenticateJWT(token string) (Identity, error) {
+	secret := JWTSecret()
+	if secret == nil {
+		return Identity{}, errors.New("bearer tokens are not enabled, use an API key")
+	}
+
+	claims, err := jwt.Verify(token, secret, time.Now())
+	if err != nil {
+		return Identity{}, fmt.Errorf("invalid bearer token: %w", err)
+	}
+	userID, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil || userID == 0 {
+		return Identity{}, errors.New("invalid bearer token: sub must be a user ID")
+	}
+	// The role is looked up on every request, so role changes apply to issued tokens
+	user, err := findUser(uint(userID))
+	if err != nil {
+		return Identity{}, errors.New("invalid bearer token: unknown user")
+	}
+	return Identity{UserID: user.ID, Role: user.Role, Method: MethodJWT}, nil
+}
+
+// JWTSecret returns the key bearer tokens are signed with (AUTH_JWT_SECRET), nil
+// if bearer tokens are disabled
+func JWTSecret() []byte {
+	if secret := os.Getenv("AUTH_JWT_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	return nil
+}
+
+func unauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="marketplace"`)
+	http.Error(w, message, http.StatusUnauthorized)
+}
@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"github.com/Vinayakatk/marketplace-prototype/pkg/apikey"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/jwt"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeCredentials replaces the stored API keys and users for the duration of a
+// test and returns the hashes looked up
+func fakeCredentials(t *testing.T, keys []models.APIKey, users []models.User) *[]string {
+	t.Helper()
+	var lookups []string
+	oldKey, oldUser, oldTouch := findAPIKey, findUser, touchAPIKey
+	t.Cleanup(func() { findAPIKey, findUser, touchAPIKey = oldKey, oldUser, oldTouch })
+
+	findAPIKey = func(hash string) (models.APIKey, error) {
+		lookups = append(lookups, hash)
+		for _, key := range keys {
+			if key.KeyHash == hash {
+				return key, nil
+			}
+		}
+		return models.APIKey{}, gorm.ErrRecordNotFound
+	}
+	findUser = func(id uint) (models.User, error) {
+		for _, user := range users {
+			if user.ID == id {
+				return user, nil
+			}
+		}
+		return models.User{}, gorm.ErrRecordNotFound
+	}
+	touchAPIKey = func(models.APIKey, time.Time) error { return nil }
+	return &lookups
+}
+
+// authenticate runs a request with the given headers through Authenticate and
+// returns the status and the identity the handler saw
+func authenticate(headers map[string]string) (int, Identity) {
+	var identity Identity
+	handler := Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ = CurrentIdentity(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/api/users/me/api-keys", nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code, identity
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	valid, _, _ := apikey.Generate()
+	revoked, _, _ := apikey.Generate()
+	expired, _, _ := apikey.Generate()
+	unexpired, _, _ := apikey.Generate()
+	orphan, _, _ := apikey.Generate()
+	unknown, _, _ := apikey.Generate()
+
+	lookups := fakeCredentials(t, []models.APIKey{
+		{ID: 1, UserID: 7, KeyHash: apikey.Hash(valid)},
+		{ID: 2, UserID: 7, KeyHash: apikey.Hash(revoked), RevokedAt: &past},
+		{ID: 3, UserID: 7, KeyHash: apikey.Hash(expired), ExpiresAt: &past},
+		{ID: 4, UserID: 8, KeyHash: apikey.Hash(unexpired), ExpiresAt: &future},
+		{ID: 5, UserID: 99, KeyHash: apikey.Hash(orphan)},
+	}, []models.User{
+		{ID: 7, Role: RolePublisher},
+		{ID: 8, Role: RoleAdmin},
+	})
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+		want    Identity
+	}{
+		{"bearer API key", map[string]string{"Authorization": "Bearer " + valid}, http.StatusOK,
+			Identity{UserID: 7, Role: RolePublisher, Method: MethodAPIKey, APIKeyID: 1}},
+		{"X-API-Key header", map[string]string{APIKeyHeader: valid}, http.StatusOK,
+			Identity{UserID: 7, Role: RolePublisher, Method: MethodAPIKey, APIKeyID: 1}},
+		{"lowercase scheme", map[string]string{"Authorization": "bearer " + unexpired}, http.StatusOK,
+			Identity{UserID: 8, Role: RoleAdmin, Method: MethodAPIKey, APIKeyID: 4}},
+		{"missing credential", nil, http.StatusUnauthorized, Identity{}},
+		{"basic scheme", map[string]string{"Authorization": "Basic " + valid}, http.StatusUnauthorized, Identity{}},
+		{"unknown key", map[string]string{APIKeyHeader: unknown}, http.StatusUnauthorized, Identity{}},
+		{"revoked key", map[string]string{APIKeyHeader: revoked}, http.StatusUnauthorized, Identity{}},
+		{"expired key", map[string]string{APIKeyHeader: expired}, http.StatusUnauthorized, Identity{}},
+		{"key of a deleted user", map[string]string{APIKeyHeader: orphan}, http.StatusUnauthorized, Identity{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, identity := authenticate(tt.headers)
+			if status != tt.status || identity != tt.want {
+				t.Errorf("Authenticate = %d %+v, want %d %+v", status, identity, tt.status, tt.want)
+			}
+		})
+	}
+
+	// Keys are only ever looked up by their hash
+	for _, hash := range *lookups {
+		if len(hash) != 64 || apikey.IsAPIKey(hash) {
+			t.Errorf("API key looked up by %q, want its SHA-256", hash)
+		}
+	}
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	secret := []byte("test-secret")
+	t.Setenv("AUTH_JWT_SECRET", string(secret))
+	fakeCredentials(t, nil, []models.User{{ID: 7, Role: RoleConsumer}})
+
+	sign := func(subject string, expiresAt time.Time, key []byte) string {
+		token, err := jwt.Sign(jwt.Claims{Subject: subject, ExpiresAt: expiresAt.Unix()}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	hour := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		want   Identity
+	}{
+		{"valid token", sign("7", hour, secret), http.StatusOK, Identity{UserID: 7, Role: RoleConsumer, Method: MethodJWT}},
+		{"expired token", sign("7", time.Now().Add(-time.Minute), secret), http.StatusUnauthorized, Identity{}},
+		{"other secret", sign("7", hour, []byte("other-secret")), http.StatusUnauthorized, Identity{}},
+		{"subject that is no user ID", sign("alice", hour, secret), http.StatusUnauthorized, Identity{}},
+		{"unknown user", sign(strconv.Itoa(99), hour, secret), http.StatusUnauthorized, Identity{}},
+		{"garbage", "not-a-token", http.StatusUnauthorized, Identity{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, identity := authenticate(map[string]string{"Authorization": "Bearer " + tt.token})
+			if status != tt.status || identity != tt.want {
+				t.Errorf("Authenticate = %d %+v, want %d %+v", status, identity, tt.status, tt.want)
+			}
+		})
+	}
+
+	t.Setenv("AUTH_JWT_SECRET", "")
+	if status, _ := authenticate(map[string]string{"Authorization": "Bearer " + sign("7", hour, secret)}); status != http.StatusUnauthorized {
+		t.Errorf("Authenticate with bearer tokens disabled = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		role    string
+		allowed []string
+		status  int
+	}{
+		{RolePublisher, []string{RolePublisher}, http.StatusOK},
+		{RoleConsumer, []string{RolePublisher}, http.StatusForbidden},
+		{RoleAdmin, []string{RolePublisher}, http.StatusOK},
+		{RoleConsumer, []string{RoleAdmin}, http.StatusForbidden},
+		{"", []string{RoleConsumer}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		handler := RequireRole(tt.allowed...)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(WithIdentity(req.Context(), Identity{UserID: 1, Role: tt.role}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("RequireRole(%v) for a %q = %d, want %d", tt.allowed, tt.role, rec.Code, tt.status)
+		}
+	}
+}
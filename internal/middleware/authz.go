@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// Roles of users. Admins can do everything; publishers manage the applications
+// they published and consumers the projects and deployments they own.
+const (
+	RoleAdmin     = "admin"
+	RolePublisher = "publisher"
+	RoleConsumer  = "consumer"
+)
+
+// Roles lists the valid roles
+var Roles = []string{RoleAdmin, RolePublisher, RoleConsumer}
+
+// IsValidRole reports whether role is one of Roles
+func IsValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireRole rejects requests of users that have none of roles with 403. Admins are always allowed.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !HasRole(r, roles...) {
+				Forbidden(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HasRole reports whether the caller has one of roles or is an admin
+func HasRole(r *http.Request, roles ...string) bool {
+	identity, _ := CurrentIdentity(r.Context())
+	if identity.Role == RoleAdmin {
+		return true
+	}
+	for _, role := range roles {
+		if identity.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin reports whether the caller is a platform admin
+func IsAdmin(r *http.Request) bool {
+	identity, _ := CurrentIdentity(r.Context())
+	return identity.Role == RoleAdmin
+}
+
+// Owns reports whether the caller is ownerID or an admin
+func Owns(r *http.Request, ownerID uint) bool {
+	return IsAdmin(r) || (ownerID != 0 && UserID(r) == ownerID)
+}
+
+// Forbidden writes the response for callers that are authenticated but not allowed
+func Forbidden(w http.ResponseWriter) {
+	http.Error(w, "Forbidden", http.StatusForbidden)
+}
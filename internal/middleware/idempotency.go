@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm/clause"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// IdempotencyKeyHeader is the request header carrying the client's idempotency key
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxKeyLength bounds the keys accepted from clients
+const maxKeyLength = 255
+
+// Idempotency makes a mutation safe to retry. The first request of a user with a
+// given Idempotency-Key is processed and its response stored; retries with the
+// same key and request get the stored response, while reusing the key for another
+// request is rejected with 422. Server errors are not stored so they can be retried.
+// Keys are scoped to their user, so users never collide on the keys they pick.
+func Idempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxKeyLength {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		userID := UserID(r)
+		hash := requestHash(r, body)
+
+		// Claim the key; only one of concurrent requests with the same key succeeds.
+		// Expired keys not purged yet are taken over as if they were gone.
+		now := time.Now()
+		expiresAt := now.Add(idempotencyKeyTTL())
+		result := database.DB.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}, {Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"request_hash": hash,
+				"status_code":  0,
+				"content_type": "",
+				"body":         nil,
+				"created_at":   now,
+				"expires_at":   expiresAt,
+			}),
+			Where: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "idempotency_keys.expires_at < ?", Vars: []interface{}{now}}}},
+		}).Create(&models.IdempotencyKey{
+			UserID:      userID,
+			Key:         key,
+			RequestHash: hash,
+			CreatedAt:   now,
+			ExpiresAt:   expiresAt,
+		})
+		if result.Error != nil {
+			http.Error(w, "Failed to store idempotency key", http.StatusInternalServerError)
+			return
+		}
+		if result.RowsAffected == 0 {
+			replay(w, userID, key, hash)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		completed := false
+		defer func() {
+			// Release the key if the handler failed or panicked so the request can be retried
+			if !completed || recorder.status >= http.StatusInternalServerError {
+				if err := database.DB.Delete(&models.IdempotencyKey{}, "user_id = ? AND key = ?", userID, key).Error; err != nil {
+					log.Printf("❌ Failed to release idempotency key %q: %v", key, err)
+				}
+			}
+		}()
+
+		next.ServeHTTP(recorder, r)
+		completed = true
+		if recorder.status >= http.StatusInternalServerError {
+			return
+		}
+
+		if err := database.DB.Model(&models.IdempotencyKey{}).Where("user_id = ? AND key = ?", userID, key).Updates(map[string]interface{}{
+			"status_code":  recorder.status,
+			"content_type": recorder.Header().Get("Content-Type"),
+			"body":         recorder.body.Bytes(),
+		}).Error; err != nil {
+			log.Printf("❌ Failed to store response for idempotency key %q: %v", key, err)
+		}
+	})
+}
+
+// replay writes the stored response of the key of a user, or an error if the key
+// belongs to another request or the original request is still in progress
+func replay(w http.ResponseWriter, userID uint, key, hash string) {
+	var stored models.IdempotencyKey
+	if err := database.DB.First(&stored, "user_id = ? AND key = ?", userID, key).Error; err != nil {
+		// Released meanwhile because the original request failed
+		http.Error(w, "Request with this Idempotency-Key failed, please retry", http.StatusConflict)
+		return
+	}
+
+	switch {
+	case stored.RequestHash != hash:
+		http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+	case stored.StatusCode == 0:
+		http.Error(w, "A request with this Idempotency-Key is still in progress", http.StatusConflict)
+	default:
+		if stored.ContentType != "" {
+			w.Header().Set("Content-Type", stored.ContentType)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(stored.StatusCode)
+		w.Write(stored.Body)
+	}
+}
+
+// requestHash identifies a request by its caller, method, path and body, so a
+// key reused by another user never replays someone else's response
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(fmt.Sprintf("%d %s %s\n", UserID(r), r.Method, r.URL.Path)))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyPurgeInterval is how often expired keys are deleted
+const idempotencyPurgeInterval = time.Hour
+
+// StartIdempotencyPurge deletes the expired idempotency keys every hour until ctx is cancelled
+func StartIdempotencyPurge(ctx context.Context) {
+	ticker := time.NewTicker(idempotencyPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			purgeIdempotencyKeys(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// purgeIdempotencyKeys deletes the keys that expired, so their storage is reclaimed
+func purgeIdempotencyKeys(ctx context.Context) {
+	result := database.DB.WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&models.IdempotencyKey{})
+	if result.Error != nil {
+		log.Println("❌ Failed to purge expired idempotency keys:", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("🧹 Purged %d expired idempotency key(s)", result.RowsAffected)
+	}
+}
+
+// idempotencyKeyTTL is how long responses are kept for replay (IDEMPOTENCY_KEY_TTL)
+func idempotencyKeyTTL() time.Duration {
+	if value := os.Getenv("IDEMPOTENCY_KEY_TTL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("⚠️ Invalid IDEMPOTENCY_KEY_TTL=%q, using 24h", value)
+	}
+	return 24 * time.Hour
+}
+
+// responseRecorder passes the response through while keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
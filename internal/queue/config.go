@@ -0,0 +1,62 @@
+package queue
+
+import (
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/env"
+	"os"
+	"time"
+)
+
+// consumerName identifies this instance among the consumers of a queue
+var consumerName = defaultConsumerName()
+
+func defaultConsumerName() string {
+	if name := os.Getenv("QUEUE_CONSUMER_NAME"); name != "" {
+		return name
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "marketplace"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// consumeBlock is how long a single Consume call waits for new jobs
+const consumeBlock = 2 * time.Second
+
+// claimMinIdle is how long a job can stay unacknowledged before another
+// consumer is allowed to take it over (QUEUE_CLAIM_MIN_IDLE, e.g. "10m")
+func claimMinIdle() time.Duration {
+	return env.Duration("QUEUE_CLAIM_MIN_IDLE", 10*time.Minute)
+}
+
+// reclaimInterval is how often pending jobs are checked (QUEUE_RECLAIM_INTERVAL)
+func reclaimInterval() time.Duration {
+	return env.Duration("QUEUE_RECLAIM_INTERVAL", time.Minute)
+}
+
+// maxAttempts is how many times a job is processed before it is dead-lettered (QUEUE_MAX_ATTEMPTS)
+func maxAttempts() int {
+	return env.Int("QUEUE_MAX_ATTEMPTS", 5)
+}
+
+// retryDelay returns the exponential backoff to wait after the given failed attempt
+func retryDelay(attempt int) time.Duration {
+	base := env.Duration("QUEUE_RETRY_BASE_DELAY", 30*time.Second)
+	limit := env.Duration("QUEUE_RETRY_MAX_DELAY", 30*time.Minute)
+
+	delay := base
+	for i := 1; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+// releaseDelay is how long a job handed back by a pool over its deployment type
+// limit stays out of the queue (QUEUE_RELEASE_DELAY)
+func releaseDelay() time.Duration {
+	return env.Duration("QUEUE_RELEASE_DELAY", 5*time.Second)
+}
@@ -0,0 +1,58 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetryDelayBacksOffExponentially(t *testing.T) {
+	t.Setenv("QUEUE_RETRY_BASE_DELAY", "1s")
+	t.Setenv("QUEUE_RETRY_MAX_DELAY", "10s")
+
+	for attempt, want := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 8 * time.Second,
+		5: 10 * time.Second,
+		9: 10 * time.Second,
+	} {
+		if got := retryDelay(attempt); got != want {
+			t.Errorf("retryDelay(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
+
+func TestRetryDelayIgnoresInvalidSettings(t *testing.T) {
+	t.Setenv("QUEUE_RETRY_BASE_DELAY", "soon")
+	t.Setenv("QUEUE_RETRY_MAX_DELAY", "-1m")
+
+	if got, want := retryDelay(1), 30*time.Second; got != want {
+		t.Errorf("retryDelay(1) = %s, want the default %s", got, want)
+	}
+}
+
+func TestShouldDeadLetter(t *testing.T) {
+	t.Setenv("QUEUE_MAX_ATTEMPTS", "3")
+	failure := errors.New("kind create cluster failed")
+
+	tests := []struct {
+		name     string
+		attempts int
+		cause    error
+		want     bool
+	}{
+		{"first failure", 1, failure, false},
+		{"below the limit", 2, failure, false},
+		{"limit reached", 3, failure, true},
+		{"permanent error", 1, permanent(failure), true},
+		{"wrapped permanent error", 1, fmt.Errorf("install: %w", permanent(failure)), true},
+	}
+	for _, tt := range tests {
+		if got := shouldDeadLetter(tt.attempts, tt.cause); got != tt.want {
+			t.Errorf("%s: shouldDeadLetter(%d) = %v, want %v", tt.name, tt.attempts, got, tt.want)
+		}
+	}
+}
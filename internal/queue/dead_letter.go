@@ -0,0 +1,75 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrDeadLetterNotFound = errors.New("dead-lettered job not found")
+
+// DeadLetter is a job that exhausted its retries
+type DeadLetter struct {
+	ID        string            `json:"id"`
+	Queue     string            `json:"queue"`
+	SourceID  string            `json:"source_id,omitempty"`
+	Attempts  int               `json:"attempts"`
+	LastError string            `json:"last_error"`
+	FailedAt  time.Time         `json:"failed_at"`
+	Payload   map[string]string `json:"payload"`
+}
+
+// DeadLetterQueue returns the name under which exhausted jobs of queue are kept
+func DeadLetterQueue(queue string) string {
+	return queue + ":dlq"
+}
+
+// IsKnownQueue reports whether name is one of the job queues
+func IsKnownQueue(name string) bool {
+	return name == InstallerQueue || name == UninstallerQueue || name == UpgraderQueue
+}
+
+func deadLetters() (DeadLetterStore, error) {
+	store, ok := jobs.(DeadLetterStore)
+	if !ok {
+		return nil, fmt.Errorf("queue backend %T does not keep dead-lettered jobs", jobs)
+	}
+	return store, nil
+}
+
+// ListDeadLetters returns up to count dead-lettered jobs of queue, oldest first
+func ListDeadLetters(ctx context.Context, queue string, count int64) ([]DeadLetter, error) {
+	store, err := deadLetters()
+	if err != nil {
+		return nil, err
+	}
+	return store.ListDeadLetters(ctx, queue, count)
+}
+
+// GetDeadLetter returns a single dead-lettered job of queue
+func GetDeadLetter(ctx context.Context, queue, id string) (*DeadLetter, error) {
+	store, err := deadLetters()
+	if err != nil {
+		return nil, err
+	}
+	return store.GetDeadLetter(ctx, queue, id)
+}
+
+// RequeueDeadLetter pushes a dead-lettered job back to its queue with a fresh attempt counter
+func RequeueDeadLetter(ctx context.Context, queue, id string) error {
+	store, err := deadLetters()
+	if err != nil {
+		return err
+	}
+	return store.RequeueDeadLetter(ctx, queue, id)
+}
+
+// PurgeDeadLetters deletes the given dead-lettered jobs, or all of them when no ID is given
+func PurgeDeadLetters(ctx context.Context, queue string, ids ...string) (int64, error) {
+	store, err := deadLetters()
+	if err != nil {
+		return 0, err
+	}
+	return store.PurgeDeadLetters(ctx, queue, ids...)
+}
@@ -0,0 +1,98 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// deadLetterJobs enqueues one job per deployment on a MemoryQueue backend and
+// fails each of them with a permanent error
+func deadLetterJobs(t *testing.T, deploymentIDs ...string) *MemoryQueue {
+	t.Helper()
+	q := NewMemoryQueue()
+	SetBackend(q)
+	ctx := context.Background()
+
+	for _, id := range deploymentIDs {
+		if err := q.Enqueue(ctx, InstallerQueue, map[string]string{"deployment_id": id}); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+		batch, err := q.Consume(ctx, InstallerQueue, 1)
+		if err != nil || len(batch) != 1 {
+			t.Fatalf("Consume = %v, %v, want 1 job", batch, err)
+		}
+		if err := q.Nack(ctx, batch[0], permanent(errors.New("invalid payload"))); err != nil {
+			t.Fatalf("Nack: %v", err)
+		}
+	}
+	return q
+}
+
+func TestDeadLettersCanBeInspectedAndPurged(t *testing.T) {
+	deadLetterJobs(t, "1", "2", "3")
+	ctx := context.Background()
+
+	letters, err := ListDeadLetters(ctx, InstallerQueue, 10)
+	if err != nil {
+		t.Fatalf("ListDeadLetters: %v", err)
+	}
+	if len(letters) != 3 {
+		t.Fatalf("ListDeadLetters returned %d job(s), want 3", len(letters))
+	}
+	if letters[0].LastError != "invalid payload" || letters[0].Attempts != 1 {
+		t.Errorf("dead letter = %+v, want the last error after 1 attempt", letters[0])
+	}
+
+	letter, err := GetDeadLetter(ctx, InstallerQueue, letters[1].ID)
+	if err != nil {
+		t.Fatalf("GetDeadLetter: %v", err)
+	}
+	if letter.Payload["deployment_id"] != "2" {
+		t.Errorf("GetDeadLetter returned deployment %s, want 2", letter.Payload["deployment_id"])
+	}
+
+	if purged, err := PurgeDeadLetters(ctx, InstallerQueue, letters[0].ID); err != nil || purged != 1 {
+		t.Fatalf("PurgeDeadLetters(%s) = %d, %v, want 1", letters[0].ID, purged, err)
+	}
+	if _, err := GetDeadLetter(ctx, InstallerQueue, letters[0].ID); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Errorf("GetDeadLetter of a purged job = %v, want ErrDeadLetterNotFound", err)
+	}
+	if _, err := PurgeDeadLetters(ctx, InstallerQueue, "missing"); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Errorf("PurgeDeadLetters of an unknown job = %v, want ErrDeadLetterNotFound", err)
+	}
+
+	if purged, err := PurgeDeadLetters(ctx, InstallerQueue); err != nil || purged != 2 {
+		t.Fatalf("PurgeDeadLetters() = %d, %v, want 2", purged, err)
+	}
+	if letters, _ := ListDeadLetters(ctx, InstallerQueue, 10); len(letters) != 0 {
+		t.Errorf("ListDeadLetters after purging = %v, want nothing", letters)
+	}
+}
+
+func TestRequeuedDeadLetterStartsOver(t *testing.T) {
+	q := deadLetterJobs(t, "7")
+	ctx := context.Background()
+
+	letters, _ := ListDeadLetters(ctx, InstallerQueue, 10)
+	if len(letters) != 1 {
+		t.Fatalf("ListDeadLetters returned %d job(s), want 1", len(letters))
+	}
+	if err := RequeueDeadLetter(ctx, InstallerQueue, letters[0].ID); err != nil {
+		t.Fatalf("RequeueDeadLetter: %v", err)
+	}
+	if letters, _ := ListDeadLetters(ctx, InstallerQueue, 10); len(letters) != 0 {
+		t.Errorf("ListDeadLetters after requeueing = %v, want nothing", letters)
+	}
+
+	batch, err := q.Consume(ctx, InstallerQueue, 1)
+	if err != nil || len(batch) != 1 {
+		t.Fatalf("Consume = %v, %v, want the requeued job", batch, err)
+	}
+	if batch[0].Payload["deployment_id"] != "7" || batch[0].Attempts != 0 {
+		t.Errorf("requeued job = %+v, want deployment 7 with no failed attempt", batch[0])
+	}
+	if err := RequeueDeadLetter(ctx, InstallerQueue, letters[0].ID); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Errorf("RequeueDeadLetter of a requeued job = %v, want ErrDeadLetterNotFound", err)
+	}
+}
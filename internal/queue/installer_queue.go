@@ -3,46 +3,42 @@ package queue
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/lifecycle"
 	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/provisioner"
 	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
-	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
-	redis "github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
 	"log"
-	"time"
 )
 
-// Redis client
-var redisClient = redis.NewClient(&redis.Options{
-	Addr: "localhost:6370",
-})
-
 const InstallerQueue = "install_queue"
 
-// PushToInstallerQueue pushes a message to Redis
-func PushToInstallerQueue(req provisioner.InstallRequest) error {
-	ctx := context.Background()
+// InstallerActor is recorded on the deployment events caused by install jobs
+const InstallerActor = "installer"
 
-	// Convert Inputs to JSON
-	inputsJSON, err := json.Marshal(req.Inputs)
+// PushToInstallerQueue writes an install job to the outbox within tx, the
+// transaction that creates or changes the deployment
+func PushToInstallerQueue(tx *gorm.DB, req provisioner.InstallRequest) error {
+	// Convert Values to JSON
+	valuesJSON, err := json.Marshal(req.Values)
 	if err != nil {
-		log.Println("❌ Failed to marshal inputs:", err)
+		log.Println("❌ Failed to marshal values:", err)
 		return err
 	}
 
-	_, err = redisClient.XAdd(ctx, &redis.XAddArgs{
-		Stream: InstallerQueue,
-		Values: map[string]interface{}{
-			"deployment_id":  req.DeploymentID,
-			"consumer_id":    req.ConsumerID,
-			"application_id": req.ApplicationID,
-			"application":    req.Application,
-			"deploy_type":    req.DeployType,
-			"repo_url":       req.RepoURL,
-			"chart_name":     req.ChartName,
-			"inputs":         inputsJSON,
-		},
-	}).Result()
+	err = enqueueOutbox(tx, InstallerQueue, map[string]string{
+		"deployment_id":  req.DeploymentID,
+		"consumer_id":    req.ConsumerID,
+		"application_id": req.ApplicationID,
+		"application":    req.Application,
+		"version":        req.Version,
+		"deploy_type":    req.DeployType,
+		"repo_url":       req.RepoURL,
+		"chart_name":     req.ChartName,
+		"chart_version":  req.ChartVersion,
+		"values":         string(valuesJSON),
+	})
 
 	if err != nil {
 		log.Println("❌ Failed to push to queue:", err)
@@ -50,67 +46,54 @@ func PushToInstallerQueue(req provisioner.InstallRequest) error {
 	return err
 }
 
-// StartInstallerConsumer processes deployment messages
-func StartInstallerConsumer() {
-	ctx := context.Background()
-	log.Println("🚀 Redis Create Queue Consumer Started...")
+// StartInstallerConsumer processes deployment messages until ctx is cancelled and
+// the jobs it already started are finished
+func StartInstallerConsumer(ctx context.Context) {
+	log.Printf("🚀 Create Queue Consumer %s Started...", consumerName)
 
-	for {
-		// Read new messages from the queue
-		messages, err := redisClient.XRead(ctx, &redis.XReadArgs{
-			Streams: []string{InstallerQueue, "0"},
-			Count:   1,
-			Block:   0, // Blocks indefinitely
-		}).Result()
+	// KIND cluster creation is slow, so by default it may only use half of the
+	// workers and never starve VM provisioning
+	pool := newPool(InstallerQueue, "deploy_type", "INSTALL", 4, map[string]int{"k8s": 2})
+	pool.Run(ctx, handleInstallJob)
+}
 
-		if err != nil {
-			log.Println("❌ Error reading from queue:", err)
-			time.Sleep(2 * time.Second) // Retry after delay
-			continue
+func handleInstallJob(job Job) error {
+	var values map[string]interface{}
+	if raw := job.Payload["values"]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &values); err != nil {
+			log.Println("❌ Failed to unmarshal values:", err)
+			return permanent(err)
 		}
+	}
+	installReq := provisioner.InstallRequest{
+		DeploymentID:  job.Payload["deployment_id"],
+		ConsumerID:    job.Payload["consumer_id"],
+		ApplicationID: job.Payload["application_id"],
+		Application:   job.Payload["application"],
+		Version:       job.Payload["version"],
+		DeployType:    job.Payload["deploy_type"],
+		RepoURL:       job.Payload["repo_url"],
+		ChartName:     job.Payload["chart_name"],
+		ChartVersion:  job.Payload["chart_version"],
+		Values:        values,
+	}
 
-		for _, stream := range messages {
-			for _, message := range stream.Messages {
-				var inputs map[string]interface{}
-				if err := json.Unmarshal([]byte(message.Values["inputs"].(string)), &inputs); err != nil {
-					log.Println("❌ Failed to unmarshal inputs:", err)
-					continue
-				}
-				installReq := provisioner.InstallRequest{
-					DeploymentID:  message.Values["deployment_id"].(string),
-					ConsumerID:    message.Values["consumer_id"].(string),
-					ApplicationID: message.Values["application_id"].(string),
-					Application:   message.Values["application"].(string),
-					DeployType:    message.Values["deploy_type"].(string),
-					RepoURL:       message.Values["repo_url"].(string),
-					ChartName:     message.Values["chart_name"].(string),
-					Inputs:        inputs,
-				}
-
-				fmt.Printf("📦 Processing Deployment %s for User %s: %s\n", installReq.DeploymentID, installReq.ConsumerID, installReq.Application)
-
-				// Fetch Deployment Record
-				var deployment models.Deployment
-				if err := database.DB.First(&deployment, installReq.DeploymentID).Error; err != nil {
-					log.Println("❌ Deployment not found:", err)
-					continue
-				}
-
-				// Update status to "installing"
-				database.DB.Model(&deployment).Update("status", "installing")
-
-				if err := provisionApplication(installReq); err != nil {
-					continue
-				}
-
-				fmt.Printf("✅ Deployment %s completed for %s\n", installReq.DeploymentID, installReq.Application)
+	fmt.Printf("📦 Processing Deployment %s for User %s: %s %s\n", installReq.DeploymentID, installReq.ConsumerID, installReq.Application, installReq.Version)
 
-				// Remove Processed Message from Queue
-				_, err = redisClient.XDel(ctx, InstallerQueue, message.ID).Result()
-				if err != nil {
-					log.Println("❌ Failed to acknowledge message:", err)
-				}
-			}
+	// Update status to "installing"
+	if err := lifecycle.Transition(database.DB, installReq.DeploymentID, lifecycle.Installing, InstallerActor, ""); err != nil {
+		log.Println("❌ Cannot install deployment:", err)
+		// The deployment is gone or was e.g. uninstalled meanwhile, retrying won't help
+		if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, lifecycle.ErrInvalidTransition) {
+			return permanent(err)
 		}
+		return err
 	}
+
+	if err := provisionApplication(installReq); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Deployment %s completed for %s\n", installReq.DeploymentID, installReq.Application)
+	return nil
 }
@@ -0,0 +1,285 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memoryQueueSize is the number of jobs a single in-memory queue can buffer
+const memoryQueueSize = 1024
+
+// MemoryQueue is an in-process, channel-backed JobQueue. Jobs are lost when the
+// process exits, so it is meant for tests and local development only. Jobs left
+// unacknowledged longer than claimMinIdle are delivered again.
+type MemoryQueue struct {
+	mu        sync.Mutex
+	seq       int64
+	queues    map[string]chan Job
+	unacked   map[string]Job
+	delivered map[string]time.Time // When unacknowledged jobs were last handed out
+	dead      map[string][]DeadLetter
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{
+		queues:    make(map[string]chan Job),
+		unacked:   make(map[string]Job),
+		delivered: make(map[string]time.Time),
+		dead:      make(map[string][]DeadLetter),
+	}
+}
+
+func (q *MemoryQueue) channel(queue string) chan Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ch, ok := q.queues[queue]
+	if !ok {
+		ch = make(chan Job, memoryQueueSize)
+		q.queues[queue] = ch
+	}
+	return ch
+}
+
+func (q *MemoryQueue) nextID() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.seq++
+	return strconv.FormatInt(q.seq, 10)
+}
+
+// push records a job as unacknowledged before sending it, so a consumer that
+// acknowledges it right away does not leave it listed
+func (q *MemoryQueue) push(job Job) error {
+	ch := q.channel(job.Queue)
+
+	q.mu.Lock()
+	q.unacked[job.ID] = job
+	q.mu.Unlock()
+
+	select {
+	case ch <- job:
+		return nil
+	default:
+		q.mu.Lock()
+		delete(q.unacked, job.ID)
+		q.mu.Unlock()
+		return fmt.Errorf("queue %s is full", job.Queue)
+	}
+}
+
+func (q *MemoryQueue) Enqueue(ctx context.Context, queue string, payload map[string]string) error {
+	copied := make(map[string]string, len(payload))
+	for key, value := range payload {
+		copied[key] = value
+	}
+	return q.push(Job{ID: q.nextID(), Queue: queue, Payload: copied})
+}
+
+// Consume first hands out jobs left unacknowledged too long, then waits for new ones
+func (q *MemoryQueue) Consume(ctx context.Context, queue string, count int) ([]Job, error) {
+	if reclaimed := q.reclaim(queue, count); len(reclaimed) > 0 {
+		return reclaimed, nil
+	}
+
+	ch := q.channel(queue)
+	timer := time.NewTimer(consumeBlock)
+	defer timer.Stop()
+
+	var batch []Job
+	select {
+	case job := <-ch:
+		batch = append(batch, job)
+	case <-timer.C:
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+fill:
+	for len(batch) < count {
+		select {
+		case job := <-ch:
+			batch = append(batch, job)
+		default:
+			break fill
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	for _, job := range batch {
+		q.delivered[job.ID] = now
+	}
+	return batch, nil
+}
+
+// reclaim hands out again up to count jobs of queue that were delivered longer
+// than claimMinIdle ago and are still not acknowledged
+func (q *MemoryQueue) reclaim(queue string, count int) []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	idleSince := now.Add(-claimMinIdle())
+	var batch []Job
+	for id, at := range q.delivered {
+		job, ok := q.unacked[id]
+		if !ok || job.Queue != queue || at.After(idleSince) {
+			continue
+		}
+		log.Printf("♻️ Reclaimed job %s from %s", id, queue)
+		q.delivered[id] = now
+		batch = append(batch, job)
+		if len(batch) == count {
+			break
+		}
+	}
+	return batch
+}
+
+func (q *MemoryQueue) Ack(ctx context.Context, job Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.unacked, job.ID)
+	delete(q.delivered, job.ID)
+	return nil
+}
+
+// Extend resets the idle time of a delivered job
+func (q *MemoryQueue) Extend(ctx context.Context, job Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.delivered[job.ID]; ok {
+		q.delivered[job.ID] = time.Now()
+	}
+	return nil
+}
+
+func (q *MemoryQueue) Nack(ctx context.Context, job Job, cause error) error {
+	q.mu.Lock()
+	delete(q.delivered, job.ID)
+	q.mu.Unlock()
+
+	job.Attempts++
+	if shouldDeadLetter(job.Attempts, cause) {
+		q.mu.Lock()
+		delete(q.unacked, job.ID)
+		q.dead[job.Queue] = append(q.dead[job.Queue], DeadLetter{
+			ID:        job.ID,
+			Queue:     job.Queue,
+			Attempts:  job.Attempts,
+			LastError: cause.Error(),
+			FailedAt:  time.Now().UTC(),
+			Payload:   job.Payload,
+		})
+		q.mu.Unlock()
+		log.Printf("☠️ Job %s from %s dead-lettered after %d attempt(s): %v", job.ID, job.Queue, job.Attempts, cause)
+		return nil
+	}
+
+	delay := retryDelay(job.Attempts)
+	time.AfterFunc(delay, func() {
+		if err := q.push(job); err != nil {
+			log.Printf("❌ Failed to retry job %s: %v", job.ID, err)
+		}
+	})
+	log.Printf("🔁 Job %s from %s failed (attempt %d/%d), retrying in %s: %v", job.ID, job.Queue, job.Attempts, maxAttempts(), delay, cause)
+	return nil
+}
+
+// Release delivers a job that never started again once delay elapsed, its
+// attempts unchanged
+func (q *MemoryQueue) Release(ctx context.Context, job Job, delay time.Duration) error {
+	q.mu.Lock()
+	delete(q.delivered, job.ID)
+	q.mu.Unlock()
+
+	time.AfterFunc(delay, func() {
+		if err := q.push(job); err != nil {
+			log.Printf("❌ Failed to release job %s: %v", job.ID, err)
+		}
+	})
+	return nil
+}
+
+func (q *MemoryQueue) List(ctx context.Context, queue string) ([]Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var listed []Job
+	for _, job := range q.unacked {
+		if job.Queue == queue {
+			listed = append(listed, job)
+		}
+	}
+	return listed, nil
+}
+
+func (q *MemoryQueue) ListDeadLetters(ctx context.Context, queue string, count int64) ([]DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	letters := q.dead[queue]
+	if int64(len(letters)) > count {
+		letters = letters[:count]
+	}
+	return append([]DeadLetter(nil), letters...), nil
+}
+
+func (q *MemoryQueue) GetDeadLetter(ctx context.Context, queue, id string) (*DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, letter := range q.dead[queue] {
+		if letter.ID == id {
+			return &letter, nil
+		}
+	}
+	return nil, ErrDeadLetterNotFound
+}
+
+func (q *MemoryQueue) RequeueDeadLetter(ctx context.Context, queue, id string) error {
+	letter, err := q.GetDeadLetter(ctx, queue, id)
+	if err != nil {
+		return err
+	}
+	if _, err := q.PurgeDeadLetters(ctx, queue, id); err != nil {
+		return err
+	}
+	return q.push(Job{ID: letter.ID, Queue: queue, Payload: letter.Payload})
+}
+
+func (q *MemoryQueue) PurgeDeadLetters(ctx context.Context, queue string, ids ...string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(ids) == 0 {
+		purged := int64(len(q.dead[queue]))
+		delete(q.dead, queue)
+		return purged, nil
+	}
+
+	sort.Strings(ids)
+	var kept []DeadLetter
+	for _, letter := range q.dead[queue] {
+		i := sort.SearchStrings(ids, letter.ID)
+		if i < len(ids) && ids[i] == letter.ID {
+			continue
+		}
+		kept = append(kept, letter)
+	}
+
+	purged := int64(len(q.dead[queue]) - len(kept))
+	if purged == 0 {
+		return 0, ErrDeadLetterNotFound
+	}
+	q.dead[queue] = kept
+	return purged, nil
+}
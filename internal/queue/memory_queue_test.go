@@ -0,0 +1,228 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func consumeOne(t *testing.T, q JobQueue, queue string) Job {
+	t.Helper()
+	batch, err := q.Consume(context.Background(), queue, 1)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+	if len(batch) != 1 {
+		t.Fatalf("Consume returned %d job(s), want 1", len(batch))
+	}
+	return batch[0]
+}
+
+func listed(t *testing.T, q Lister, queue string) []Job {
+	t.Helper()
+	jobs, err := q.List(context.Background(), queue)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	return jobs
+}
+
+func TestMemoryQueueEnqueueConsumeAck(t *testing.T) {
+	q := NewMemoryQueue()
+	ctx := context.Background()
+
+	payload := map[string]string{"deployment_id": "42", "deploy_type": "k8s"}
+	if err := q.Enqueue(ctx, InstallerQueue, payload); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	payload["deployment_id"] = "changed"
+
+	if jobs := listed(t, q, InstallerQueue); len(jobs) != 1 {
+		t.Fatalf("List before consuming = %v, want the waiting job", jobs)
+	}
+
+	job := consumeOne(t, q, InstallerQueue)
+	if job.Payload["deployment_id"] != "42" || job.Attempts != 0 {
+		t.Fatalf("consumed %+v, want deployment 42 on its first attempt", job)
+	}
+	if jobs := listed(t, q, InstallerQueue); len(jobs) != 1 {
+		t.Fatalf("List while processing = %v, want the job", jobs)
+	}
+
+	if err := q.Ack(ctx, job); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	if jobs := listed(t, q, InstallerQueue); len(jobs) != 0 {
+		t.Fatalf("List after Ack = %v, want nothing", jobs)
+	}
+	if jobs := listed(t, q, UninstallerQueue); len(jobs) != 0 {
+		t.Fatalf("List of another queue = %v, want nothing", jobs)
+	}
+}
+
+func TestMemoryQueueAckRightAfterDeliveryIsNotListed(t *testing.T) {
+	q := NewMemoryQueue()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const count = 200
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for acked := 0; acked < count; {
+			batch, err := q.Consume(ctx, InstallerQueue, count)
+			if err != nil {
+				return
+			}
+			for _, job := range batch {
+				q.Ack(ctx, job)
+				acked++
+			}
+		}
+	}()
+
+	for i := 0; i < count; i++ {
+		if err := q.Enqueue(ctx, InstallerQueue, map[string]string{"deployment_id": "1"}); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+	wg.Wait()
+
+	if jobs := listed(t, q, InstallerQueue); len(jobs) != 0 {
+		t.Fatalf("List after acknowledging every job = %d stale job(s), want none", len(jobs))
+	}
+}
+
+func TestMemoryQueueFullQueueIsNotListed(t *testing.T) {
+	q := NewMemoryQueue()
+	ctx := context.Background()
+
+	for i := 0; i < memoryQueueSize; i++ {
+		if err := q.Enqueue(ctx, InstallerQueue, nil); err != nil {
+			t.Fatalf("Enqueue %d: %v", i, err)
+		}
+	}
+	if err := q.Enqueue(ctx, InstallerQueue, nil); err == nil {
+		t.Fatal("Enqueue on a full queue succeeded")
+	}
+	if jobs := listed(t, q, InstallerQueue); len(jobs) != memoryQueueSize {
+		t.Fatalf("List = %d job(s), want %d", len(jobs), memoryQueueSize)
+	}
+}
+
+func TestMemoryQueueNackRetriesWithBackoff(t *testing.T) {
+	t.Setenv("QUEUE_RETRY_BASE_DELAY", "100ms")
+	q := NewMemoryQueue()
+	ctx := context.Background()
+
+	if err := q.Enqueue(ctx, InstallerQueue, map[string]string{"deployment_id": "1"}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	job := consumeOne(t, q, InstallerQueue)
+
+	failedAt := time.Now()
+	if err := q.Nack(ctx, job, errors.New("cluster unreachable")); err != nil {
+		t.Fatalf("Nack: %v", err)
+	}
+	if jobs := listed(t, q, InstallerQueue); len(jobs) != 1 {
+		t.Fatalf("List while waiting for the retry = %v, want the job", jobs)
+	}
+
+	retried := consumeOne(t, q, InstallerQueue)
+	if waited := time.Since(failedAt); waited < 100*time.Millisecond {
+		t.Errorf("retried after %s, want the 100ms backoff", waited)
+	}
+	if retried.ID != job.ID || retried.Attempts != 1 {
+		t.Errorf("retried %+v, want job %s after 1 failed attempt", retried, job.ID)
+	}
+
+	// The second failure waits twice as long
+	failedAt = time.Now()
+	if err := q.Nack(ctx, retried, errors.New("cluster unreachable")); err != nil {
+		t.Fatalf("Nack: %v", err)
+	}
+	retried = consumeOne(t, q, InstallerQueue)
+	if waited := time.Since(failedAt); waited < 200*time.Millisecond {
+		t.Errorf("retried after %s, want the 200ms backoff", waited)
+	}
+	if retried.Attempts != 2 {
+		t.Errorf("retried after %d failed attempt(s), want 2", retried.Attempts)
+	}
+}
+
+func TestMemoryQueueDeadLettersAfterMaxAttempts(t *testing.T) {
+	t.Setenv("QUEUE_RETRY_BASE_DELAY", "1ms")
+	t.Setenv("QUEUE_MAX_ATTEMPTS", "3")
+	q := NewMemoryQueue()
+	ctx := context.Background()
+
+	if err := q.Enqueue(ctx, InstallerQueue, map[string]string{"deployment_id": "1"}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	for attempt := 1; attempt <= 3; attempt++ {
+		job := consumeOne(t, q, InstallerQueue)
+		if job.Attempts != attempt-1 {
+			t.Fatalf("attempt %d delivered with %d failed attempt(s)", attempt, job.Attempts)
+		}
+		if err := q.Nack(ctx, job, errors.New("helm install failed")); err != nil {
+			t.Fatalf("Nack: %v", err)
+		}
+	}
+
+	letters, err := q.ListDeadLetters(ctx, InstallerQueue, 10)
+	if err != nil {
+		t.Fatalf("ListDeadLetters: %v", err)
+	}
+	if len(letters) != 1 {
+		t.Fatalf("ListDeadLetters returned %d job(s), want 1", len(letters))
+	}
+	if letters[0].Attempts != 3 || letters[0].LastError != "helm install failed" || letters[0].Payload["deployment_id"] != "1" {
+		t.Errorf("dead letter = %+v, want deployment 1 after 3 attempts with the last error", letters[0])
+	}
+	if jobs := listed(t, q, InstallerQueue); len(jobs) != 0 {
+		t.Errorf("List after dead-lettering = %v, want nothing", jobs)
+	}
+	if batch, _ := q.Consume(ctx, InstallerQueue, 1); len(batch) != 0 {
+		t.Errorf("dead-lettered job delivered again: %v", batch)
+	}
+}
+
+func TestMemoryQueueReclaimsAbandonedJobs(t *testing.T) {
+	t.Setenv("QUEUE_CLAIM_MIN_IDLE", "200ms")
+	q := NewMemoryQueue()
+	ctx := context.Background()
+
+	for _, id := range []string{"1", "2"} {
+		if err := q.Enqueue(ctx, InstallerQueue, map[string]string{"deployment_id": id}); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+	abandoned := consumeOne(t, q, InstallerQueue)
+	extended := consumeOne(t, q, InstallerQueue)
+
+	// Only the job whose consumer keeps extending it is left alone
+	time.Sleep(120 * time.Millisecond)
+	if err := q.Extend(ctx, extended); err != nil {
+		t.Fatalf("Extend: %v", err)
+	}
+	time.Sleep(120 * time.Millisecond)
+
+	reclaimed := consumeOne(t, q, InstallerQueue)
+	if reclaimed.ID != abandoned.ID {
+		t.Fatalf("reclaimed job %s, want the abandoned job %s", reclaimed.ID, abandoned.ID)
+	}
+	if err := q.Ack(ctx, reclaimed); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	if err := q.Ack(ctx, extended); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if batch := q.reclaim(InstallerQueue, 1); len(batch) != 0 {
+		t.Errorf("acknowledged job reclaimed: %v", batch)
+	}
+}
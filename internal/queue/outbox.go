@@ -0,0 +1,132 @@
+package queue
+
+import (
+	"context"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/env"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"time"
+)
+
+// outboxBatchSize is how many messages the relay publishes per transaction
+const outboxBatchSize = 100
+
+// outboxWakeup lets producers wake the relay right after their transaction committed
+var outboxWakeup = make(chan struct{}, 1)
+
+// enqueueOutbox stores a job for queue in the outbox. tx should be the transaction
+// that changes the deployment, so the job is only published if the change commits.
+func enqueueOutbox(tx *gorm.DB, queue string, payload map[string]string) error {
+	if err := tx.Create(&models.OutboxMessage{Queue: queue, Payload: payload}).Error; err != nil {
+		return err
+	}
+
+	// The message isn't visible before tx commits; the relay picks it up on its
+	// next poll if it wakes up too early
+	select {
+	case outboxWakeup <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// StartOutboxRelay publishes the outbox messages to the queue backend in the order
+// they were written until ctx is cancelled. Several instances can run a relay as
+// messages are locked with SELECT ... FOR UPDATE SKIP LOCKED. A message may be
+// published twice if marking it sent fails, so job handlers must be idempotent.
+func StartOutboxRelay(ctx context.Context) {
+	interval := env.Duration("OUTBOX_POLL_INTERVAL", time.Second)
+	retention := env.Duration("OUTBOX_RETENTION", 24*time.Hour)
+	log.Printf("📮 Outbox relay started, polling every %s", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+
+	for {
+		for {
+			published, err := relayOutbox(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Println("❌ Failed to relay outbox:", err)
+				}
+				break
+			}
+			// A full batch means more messages may be waiting
+			if published < outboxBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-outboxWakeup:
+		case <-cleanup.C:
+			purgeOutbox(ctx, retention)
+		case <-ctx.Done():
+			log.Println("🛑 Outbox relay stopped")
+			return
+		}
+	}
+}
+
+// relayOutbox publishes a batch of unsent messages and returns how many were sent.
+// It stops at the first failure so jobs are published in order.
+func relayOutbox(ctx context.Context) (int, error) {
+	published := 0
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var messages []models.OutboxMessage
+		if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate, Options: clause.LockingOptionsSkipLocked}).
+			Where("sent_at IS NULL").
+			Order("id").
+			Limit(outboxBatchSize).
+			Find(&messages).Error; err != nil {
+			return err
+		}
+
+		for _, message := range messages {
+			if err := jobs.Enqueue(ctx, message.Queue, message.Payload); err != nil {
+				log.Printf("❌ Failed to publish outbox message %d to %s: %v", message.ID, message.Queue, err)
+				return tx.Model(&message).Updates(map[string]interface{}{
+					"attempts":   gorm.Expr("attempts + 1"),
+					"last_error": err.Error(),
+				}).Error
+			}
+
+			if err := tx.Model(&message).Update("sent_at", time.Now()).Error; err != nil {
+				return err
+			}
+			published++
+		}
+		return nil
+	})
+	return published, err
+}
+
+// purgeOutbox deletes the messages published more than retention ago
+func purgeOutbox(ctx context.Context, retention time.Duration) {
+	result := database.DB.WithContext(ctx).
+		Where("sent_at < ?", time.Now().Add(-retention)).
+		Delete(&models.OutboxMessage{})
+	if result.Error != nil {
+		log.Println("❌ Failed to purge outbox:", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("🧹 Purged %d published outbox message(s)", result.RowsAffected)
+	}
+}
+
+// outboxDeployments returns the IDs of the deployments that have a job for queue
+// waiting in the outbox
+func outboxDeployments(ctx context.Context, queue string) ([]string, error) {
+	var ids []string
+	err := database.DB.WithContext(ctx).Model(&models.OutboxMessage{}).
+		Where("queue = ? AND sent_at IS NULL", queue).
+		Pluck("payload->>'deployment_id'", &ids).Error
+	return ids, err
+}
@@ -0,0 +1,335 @@
+package queue
+
+import (
+	"context"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/env"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deploymentTypes are the deployment types that can get their own concurrency limit
+var deploymentTypes = []string{"k8s", "vm"}
+
+// Extender is implemented by backends that can keep a job from being reclaimed
+// by another consumer while it is still held by this one
+type Extender interface {
+	Extend(ctx context.Context, job Job) error
+}
+
+// Releaser is implemented by backends that can take back a job that never
+// started, delivering it again after delay without counting a failed attempt
+type Releaser interface {
+	Release(ctx context.Context, job Job, delay time.Duration) error
+}
+
+// InFlightJob describes a job held by a worker pool
+type InFlightJob struct {
+	JobID          string     `json:"job_id"`
+	DeploymentID   string     `json:"deployment_id"`
+	DeploymentType string     `json:"deployment_type"`
+	State          string     `json:"state"` // "waiting" for a worker or "running"
+	Attempt        int        `json:"attempt"`
+	FetchedAt      time.Time  `json:"fetched_at"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+}
+
+// PoolStatus is a snapshot of a worker pool
+type PoolStatus struct {
+	Queue    string         `json:"queue"`
+	Workers  int            `json:"workers"`
+	Prefetch int            `json:"prefetch"`
+	Limits   map[string]int `json:"limits"`
+	Running  int            `json:"running"`
+	Waiting  int            `json:"waiting"`
+	Jobs     []InFlightJob  `json:"jobs"`
+}
+
+// Pool runs the jobs of a queue on a bounded number of workers. Jobs of a
+// deployment type whose limit is reached don't count against prefetch: up to
+// limit of them wait in memory and the others go back to the queue for a while,
+// so jobs of other types queued behind them can still be picked up.
+type Pool struct {
+	queue    string
+	typeKey  string // Payload field holding the deployment type
+	workers  int
+	prefetch int
+	limits   map[string]int
+
+	slots     chan struct{}            // Running jobs
+	held      chan struct{}            // Jobs fetched but not finished yet, except those waiting for their type
+	typeSlots map[string]chan struct{} // Running jobs per deployment type
+	backlog   map[string]chan struct{} // Jobs waiting for their deployment type
+
+	mu       sync.Mutex
+	inFlight map[string]*InFlightJob
+	running  sync.WaitGroup
+}
+
+var (
+	poolsMu sync.Mutex
+	pools   = make(map[string]*Pool)
+)
+
+// newPool builds the pool of queue from <prefix>_WORKERS, <prefix>_PREFETCH and
+// <prefix>_CONCURRENCY_<TYPE> environment variables
+func newPool(queue, typeKey, prefix string, defaultWorkers int, defaultLimits map[string]int) *Pool {
+	workers := env.Int(prefix+"_WORKERS", defaultWorkers)
+	p := &Pool{
+		queue:     queue,
+		typeKey:   typeKey,
+		workers:   workers,
+		prefetch:  env.Int(prefix+"_PREFETCH", 2*workers),
+		limits:    make(map[string]int),
+		typeSlots: make(map[string]chan struct{}),
+		backlog:   make(map[string]chan struct{}),
+		inFlight:  make(map[string]*InFlightJob),
+	}
+	if p.prefetch < workers {
+		p.prefetch = workers
+	}
+
+	for _, deploymentType := range deploymentTypes {
+		key := prefix + "_CONCURRENCY_" + strings.ToUpper(deploymentType)
+		limit := env.Int(key, defaultLimits[deploymentType])
+		if limit > 0 && limit < workers {
+			p.limits[deploymentType] = limit
+			p.typeSlots[deploymentType] = make(chan struct{}, limit)
+			p.backlog[deploymentType] = make(chan struct{}, limit)
+		}
+	}
+
+	p.slots = make(chan struct{}, p.workers)
+	p.held = make(chan struct{}, p.prefetch)
+
+	poolsMu.Lock()
+	pools[queue] = p
+	poolsMu.Unlock()
+	return p
+}
+
+// Run fetches jobs as long as the pool has room for them and processes each one
+// on its own goroutine. Once ctx is cancelled it stops fetching, leaves jobs that
+// haven't started yet unacknowledged and returns when the running ones finished.
+func (p *Pool) Run(ctx context.Context, handle jobHandler) {
+	log.Printf("👷 %s pool started with %d worker(s), limits %v", p.queue, p.workers, p.limits)
+	go p.heartbeat(ctx)
+
+	for ctx.Err() == nil {
+		select {
+		case p.held <- struct{}{}:
+		case <-ctx.Done():
+			continue
+		}
+
+		batch, err := jobs.Consume(ctx, p.queue, 1)
+		if err != nil {
+			<-p.held
+			if ctx.Err() != nil {
+				continue
+			}
+			log.Printf("❌ Error reading from %s: %v", p.queue, err)
+			time.Sleep(2 * time.Second) // Retry after delay
+			continue
+		}
+		if len(batch) == 0 {
+			<-p.held
+			continue
+		}
+
+		job := batch[0]
+		p.track(job)
+		p.running.Add(1)
+		go p.run(ctx, job, handle)
+	}
+
+	log.Printf("⏳ %s pool stopped fetching, waiting for in-flight jobs", p.queue)
+	p.running.Wait()
+	log.Printf("🛑 %s pool stopped", p.queue)
+}
+
+func (p *Pool) run(ctx context.Context, job Job, handle jobHandler) {
+	held := true
+	defer func() {
+		p.untrack(job)
+		if held {
+			<-p.held
+		}
+		p.running.Done()
+	}()
+
+	deploymentType := job.Payload[p.typeKey]
+	if typeSlot, ok := p.typeSlots[deploymentType]; ok {
+		select {
+		case typeSlot <- struct{}{}:
+		default:
+			// Over its type limit: the job waits without holding a prefetch slot,
+			// unless enough jobs of its type already wait
+			select {
+			case p.backlog[deploymentType] <- struct{}{}:
+			default:
+				p.release(ctx, job)
+				return
+			}
+			<-p.held
+			held = false
+			acquired := acquire(ctx, typeSlot)
+			<-p.backlog[deploymentType]
+			if !acquired {
+				p.abandon(job)
+				return
+			}
+		}
+		defer func() { <-typeSlot }()
+	}
+	if !acquire(ctx, p.slots) {
+		p.abandon(job)
+		return
+	}
+	defer func() { <-p.slots }()
+
+	p.markRunning(job)
+	// Jobs that started are finished even when shutting down, so acknowledging
+	// them must not be cancelled together with ctx
+	processJob(context.WithoutCancel(ctx), job, handle)
+}
+
+// acquire takes a slot of sem unless ctx is cancelled first
+func acquire(ctx context.Context, sem chan struct{}) bool {
+	select {
+	case sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// abandon leaves a job that never started unacknowledged, so it is redelivered
+// to another consumer once its claim idle time elapsed
+func (p *Pool) abandon(job Job) {
+	log.Printf("⏸️ Leaving job %s of %s unacknowledged for another instance", job.ID, p.queue)
+}
+
+// release hands a job over its type limit back to the queue for releaseDelay, so
+// it does not keep this pool from fetching jobs of other types meanwhile
+func (p *Pool) release(ctx context.Context, job Job) {
+	releaser, ok := jobs.(Releaser)
+	if !ok {
+		p.abandon(job)
+		return
+	}
+
+	delay := releaseDelay()
+	if err := releaser.Release(ctx, job, delay); err != nil {
+		log.Printf("❌ Failed to release job %s of %s: %v", job.ID, p.queue, err)
+		return
+	}
+	log.Printf("⏭️ Job %s of %s is over its %s limit, back in the queue for %s", job.ID, p.queue, job.Payload[p.typeKey], delay)
+}
+
+// heartbeat keeps held jobs from being reclaimed by other consumers while they
+// wait for a worker or take longer than the claim idle time to process
+func (p *Pool) heartbeat(ctx context.Context) {
+	extender, ok := jobs.(Extender)
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(claimMinIdle() / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		for _, job := range p.heldJobs() {
+			if err := extender.Extend(ctx, job); err != nil {
+				log.Printf("❌ Failed to extend job %s: %v", job.ID, err)
+			}
+		}
+	}
+}
+
+func (p *Pool) track(job Job) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inFlight[job.ID] = &InFlightJob{
+		JobID:          job.ID,
+		DeploymentID:   job.Payload["deployment_id"],
+		DeploymentType: job.Payload[p.typeKey],
+		State:          "waiting",
+		Attempt:        job.Attempts + 1,
+		FetchedAt:      time.Now(),
+	}
+}
+
+func (p *Pool) markRunning(job Job) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if entry, ok := p.inFlight[job.ID]; ok {
+		now := time.Now()
+		entry.State = "running"
+		entry.StartedAt = &now
+	}
+}
+
+func (p *Pool) untrack(job Job) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.inFlight, job.ID)
+}
+
+func (p *Pool) heldJobs() []Job {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	held := make([]Job, 0, len(p.inFlight))
+	for id := range p.inFlight {
+		held = append(held, Job{ID: id, Queue: p.queue})
+	}
+	return held
+}
+
+// Status returns a snapshot of the pool and the jobs it holds
+func (p *Pool) Status() PoolStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := PoolStatus{
+		Queue:    p.queue,
+		Workers:  p.workers,
+		Prefetch: p.prefetch,
+		Limits:   p.limits,
+		Jobs:     make([]InFlightJob, 0, len(p.inFlight)),
+	}
+	for _, entry := range p.inFlight {
+		if entry.State == "running" {
+			status.Running++
+		} else {
+			status.Waiting++
+		}
+		status.Jobs = append(status.Jobs, *entry)
+	}
+	sort.Slice(status.Jobs, func(i, j int) bool {
+		return status.Jobs[i].FetchedAt.Before(status.Jobs[j].FetchedAt)
+	})
+	return status
+}
+
+// PoolStatuses returns a snapshot of every worker pool started by this instance
+func PoolStatuses() []PoolStatus {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+
+	statuses := make([]PoolStatus, 0, len(pools))
+	for _, p := range pools {
+		statuses = append(statuses, p.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Queue < statuses[j].Queue
+	})
+	return statuses
+}
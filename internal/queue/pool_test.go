@@ -0,0 +1,105 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runPool runs a pool over a MemoryQueue holding jobs until the test ends
+func runPool(t *testing.T, p *Pool, handle jobHandler) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		p.Run(ctx, handle)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+}
+
+func TestPoolRunsOtherTypesWhileOneIsAtItsLimit(t *testing.T) {
+	t.Setenv("QUEUE_RELEASE_DELAY", "50ms")
+	q := NewMemoryQueue()
+	SetBackend(q)
+	recordAttempt = func(Job, error) {}
+	t.Cleanup(func() { recordAttempt = auditJob })
+
+	const queue, k8sJobs = "test_install_queue", 10
+	ctx := context.Background()
+	for i := 0; i < k8sJobs; i++ {
+		if err := q.Enqueue(ctx, queue, map[string]string{"deploy_type": "k8s"}); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+	if err := q.Enqueue(ctx, queue, map[string]string{"deploy_type": "vm"}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	var (
+		mu         sync.Mutex
+		running    int
+		maxRunning int
+		finished   int
+	)
+	gate := make(chan struct{})
+	var openGate sync.Once
+	vmRan := make(chan struct{})
+	p := newPool(queue, "deploy_type", "TEST_INSTALL", 4, map[string]int{"k8s": 2})
+	runPool(t, p, func(job Job) error {
+		if job.Payload["deploy_type"] == "vm" {
+			close(vmRan)
+			return nil
+		}
+
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		<-gate
+
+		mu.Lock()
+		running--
+		finished++
+		mu.Unlock()
+		return nil
+	})
+	// Lets blocked jobs finish so the pool can stop when the test fails
+	t.Cleanup(func() { openGate.Do(func() { close(gate) }) })
+
+	// Every k8s job that could run blocks until the VM job ran
+	select {
+	case <-vmRan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("VM job starved behind k8s jobs over their limit")
+	}
+	openGate.Do(func() { close(gate) })
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		mu.Lock()
+		done := finished
+		mu.Unlock()
+		if done == k8sJobs {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d k8s job(s) finished", done, k8sJobs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if maxRunning > 2 {
+		t.Errorf("%d k8s jobs ran at once, want at most 2", maxRunning)
+	}
+	if jobs := listed(t, q, queue); len(jobs) != 0 {
+		t.Errorf("List after every job finished = %v, want nothing", jobs)
+	}
+}
@@ -0,0 +1,232 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"strconv"
+	"time"
+)
+
+// postgresPollInterval is how often an idle Consume call looks for new jobs
+const postgresPollInterval = 500 * time.Millisecond
+
+// PostgresQueue is a JobQueue stored in the queue_jobs table. Consumers lock
+// jobs with SELECT ... FOR UPDATE SKIP LOCKED so several instances can share it.
+type PostgresQueue struct {
+	db *gorm.DB
+}
+
+func NewPostgresQueue() *PostgresQueue {
+	return &PostgresQueue{db: database.DB}
+}
+
+func (q *PostgresQueue) Enqueue(ctx context.Context, queue string, payload map[string]string) error {
+	return q.db.WithContext(ctx).Create(&models.QueueJob{
+		Queue:       queue,
+		Status:      "ready",
+		Payload:     payload,
+		AvailableAt: time.Now(),
+	}).Error
+}
+
+// Consume polls for ready jobs until some are found or consumeBlock elapsed.
+// Running jobs whose lock expired are handed out again.
+func (q *PostgresQueue) Consume(ctx context.Context, queue string, count int) ([]Job, error) {
+	deadline := time.Now().Add(consumeBlock)
+	for {
+		batch, err := q.claim(ctx, queue, count)
+		if err != nil || len(batch) > 0 || time.Now().After(deadline) {
+			return batch, err
+		}
+
+		select {
+		case <-time.After(postgresPollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (q *PostgresQueue) claim(ctx context.Context, queue string, count int) ([]Job, error) {
+	var claimed []models.QueueJob
+	err := q.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate, Options: clause.LockingOptionsSkipLocked}).
+			Where("queue = ?", queue).
+			Where("((status = 'ready' AND available_at <= ?) OR (status = 'running' AND locked_until < ?))", now, now).
+			Order("id").
+			Limit(count).
+			Find(&claimed).Error; err != nil {
+			return err
+		}
+		if len(claimed) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, 0, len(claimed))
+		for _, job := range claimed {
+			if job.Status == "running" {
+				log.Printf("♻️ Reclaimed job %d from %s locked by %s", job.ID, queue, job.LockedBy)
+			}
+			ids = append(ids, job.ID)
+		}
+		return tx.Model(&models.QueueJob{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":       "running",
+			"locked_by":    consumerName,
+			"locked_until": now.Add(claimMinIdle()),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	batch := make([]Job, 0, len(claimed))
+	for _, job := range claimed {
+		batch = append(batch, Job{
+			ID:       strconv.FormatUint(uint64(job.ID), 10),
+			Queue:    job.Queue,
+			Payload:  job.Payload,
+			Attempts: job.Attempts,
+		})
+	}
+	return batch, nil
+}
+
+func (q *PostgresQueue) Ack(ctx context.Context, job Job) error {
+	return q.db.WithContext(ctx).Delete(&models.QueueJob{}, job.ID).Error
+}
+
+func (q *PostgresQueue) Nack(ctx context.Context, job Job, cause error) error {
+	attempts := job.Attempts + 1
+	updates := map[string]interface{}{
+		"attempts":     attempts,
+		"last_error":   cause.Error(),
+		"locked_by":    nil,
+		"locked_until": nil,
+	}
+
+	if shouldDeadLetter(attempts, cause) {
+		updates["status"] = "dead"
+		log.Printf("☠️ Job %s from %s dead-lettered after %d attempt(s): %v", job.ID, job.Queue, attempts, cause)
+	} else {
+		delay := retryDelay(attempts)
+		updates["status"] = "ready"
+		updates["available_at"] = time.Now().Add(delay)
+		log.Printf("🔁 Job %s from %s failed (attempt %d/%d), retrying in %s: %v", job.ID, job.Queue, attempts, maxAttempts(), delay, cause)
+	}
+
+	return q.db.WithContext(ctx).Model(&models.QueueJob{}).Where("id = ?", job.ID).Updates(updates).Error
+}
+
+// Release makes a running job ready again once delay elapsed, its attempts unchanged
+func (q *PostgresQueue) Release(ctx context.Context, job Job, delay time.Duration) error {
+	return q.db.WithContext(ctx).Model(&models.QueueJob{}).
+		Where("id = ? AND status = 'running' AND locked_by = ?", job.ID, consumerName).
+		Updates(map[string]interface{}{
+			"status":       "ready",
+			"available_at": time.Now().Add(delay),
+			"locked_by":    nil,
+			"locked_until": nil,
+		}).Error
+}
+
+// Extend pushes back the lock expiry of a running job
+func (q *PostgresQueue) Extend(ctx context.Context, job Job) error {
+	return q.db.WithContext(ctx).Model(&models.QueueJob{}).
+		Where("id = ? AND status = 'running' AND locked_by = ?", job.ID, consumerName).
+		Update("locked_until", time.Now().Add(claimMinIdle())).Error
+}
+
+func (q *PostgresQueue) List(ctx context.Context, queue string) ([]Job, error) {
+	var queued []models.QueueJob
+	if err := q.db.WithContext(ctx).Where("queue = ? AND status IN ?", queue, []string{"ready", "running"}).Order("id").Find(&queued).Error; err != nil {
+		return nil, err
+	}
+
+	listed := make([]Job, 0, len(queued))
+	for _, job := range queued {
+		listed = append(listed, Job{
+			ID:       strconv.FormatUint(uint64(job.ID), 10),
+			Queue:    job.Queue,
+			Payload:  job.Payload,
+			Attempts: job.Attempts,
+		})
+	}
+	return listed, nil
+}
+
+func toPostgresDeadLetter(job models.QueueJob) DeadLetter {
+	return DeadLetter{
+		ID:        strconv.FormatUint(uint64(job.ID), 10),
+		Queue:     job.Queue,
+		Attempts:  job.Attempts,
+		LastError: job.LastError,
+		FailedAt:  job.UpdatedAt,
+		Payload:   job.Payload,
+	}
+}
+
+func (q *PostgresQueue) ListDeadLetters(ctx context.Context, queue string, count int64) ([]DeadLetter, error) {
+	var dead []models.QueueJob
+	if err := q.db.WithContext(ctx).Where("queue = ? AND status = 'dead'", queue).Order("id").Limit(int(count)).Find(&dead).Error; err != nil {
+		return nil, err
+	}
+
+	letters := make([]DeadLetter, 0, len(dead))
+	for _, job := range dead {
+		letters = append(letters, toPostgresDeadLetter(job))
+	}
+	return letters, nil
+}
+
+func (q *PostgresQueue) GetDeadLetter(ctx context.Context, queue, id string) (*DeadLetter, error) {
+	var job models.QueueJob
+	err := q.db.WithContext(ctx).Where("id = ? AND queue = ? AND status = 'dead'", id, queue).First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	letter := toPostgresDeadLetter(job)
+	return &letter, nil
+}
+
+func (q *PostgresQueue) RequeueDeadLetter(ctx context.Context, queue, id string) error {
+	result := q.db.WithContext(ctx).Model(&models.QueueJob{}).
+		Where("id = ? AND queue = ? AND status = 'dead'", id, queue).
+		Updates(map[string]interface{}{
+			"status":       "ready",
+			"attempts":     0,
+			"available_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+func (q *PostgresQueue) PurgeDeadLetters(ctx context.Context, queue string, ids ...string) (int64, error) {
+	query := q.db.WithContext(ctx).Where("queue = ? AND status = 'dead'", queue)
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	}
+
+	result := query.Delete(&models.QueueJob{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if len(ids) > 0 && result.RowsAffected == 0 {
+		return 0, ErrDeadLetterNotFound
+	}
+	return result.RowsAffected, nil
+}
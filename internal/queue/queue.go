@@ -0,0 +1,161 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/audit"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/deprovisioner"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/upgrader"
+	"log"
+	"os"
+)
+
+// Job is a unit of work read from a queue
+type Job struct {
+	ID       string            `json:"id"`
+	Queue    string            `json:"queue"`
+	Payload  map[string]string `json:"payload"`
+	Attempts int               `json:"attempts"` // Failed attempts so far
+}
+
+// JobQueue is implemented by every queue backend
+type JobQueue interface {
+	// Enqueue publishes a job with the given payload on queue
+	Enqueue(ctx context.Context, queue string, payload map[string]string) error
+	// Consume waits a short while for jobs on queue and returns at most count of them.
+	// It returns no jobs and no error when nothing arrived in time.
+	Consume(ctx context.Context, queue string, count int) ([]Job, error)
+	// Ack marks the job as done and removes it from the queue
+	Ack(ctx context.Context, job Job) error
+	// Nack reports a failed attempt; the job is retried with backoff or dead-lettered
+	// once it failed maxAttempts times or the error is permanent
+	Nack(ctx context.Context, job Job, cause error) error
+}
+
+// DeadLetterStore is implemented by backends that keep jobs which exhausted their retries
+type DeadLetterStore interface {
+	ListDeadLetters(ctx context.Context, queue string, count int64) ([]DeadLetter, error)
+	GetDeadLetter(ctx context.Context, queue, id string) (*DeadLetter, error)
+	RequeueDeadLetter(ctx context.Context, queue, id string) error
+	PurgeDeadLetters(ctx context.Context, queue string, ids ...string) (int64, error)
+}
+
+// Lister is implemented by backends that can list the jobs of a queue that are
+// not acknowledged yet: waiting, being processed or waiting for a retry
+type Lister interface {
+	List(ctx context.Context, queue string) ([]Job, error)
+}
+
+// jobHandler processes a single job. Returning an error Nacks the job.
+type jobHandler func(job Job) error
+
+// jobs is the backend used by the producers and consumers of this package
+var jobs JobQueue
+
+// Connect sets up the queue backend selected by QUEUE_BACKEND ("redis", "postgres" or "memory")
+func Connect() {
+	backend := os.Getenv("QUEUE_BACKEND")
+	switch backend {
+	case "", "redis":
+		jobs = NewRedisQueue(redisAddr())
+	case "postgres":
+		jobs = NewPostgresQueue()
+	case "memory":
+		jobs = NewMemoryQueue()
+	default:
+		log.Fatalf("❌ Unsupported QUEUE_BACKEND: %s", backend)
+	}
+	log.Printf("✅ Using %T as queue backend", jobs)
+}
+
+// SetBackend replaces the queue backend, e.g. with a MemoryQueue in tests
+func SetBackend(q JobQueue) {
+	jobs = q
+}
+
+// QueuedDeployments returns the IDs of the deployments that have a job on queue
+// which is not acknowledged yet, including jobs still waiting in the outbox
+func QueuedDeployments(ctx context.Context, queue string) (map[string]bool, error) {
+	lister, ok := jobs.(Lister)
+	if !ok {
+		return nil, fmt.Errorf("queue backend %T cannot list jobs", jobs)
+	}
+
+	queued, err := lister.List(ctx, queue)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := outboxDeployments(ctx, queue)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool, len(queued)+len(pending))
+	for _, job := range queued {
+		ids[job.Payload["deployment_id"]] = true
+	}
+	for _, id := range pending {
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+func redisAddr() string {
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		return addr
+	}
+	return "localhost:6370"
+}
+
+// recordAttempt records the outcome of an attempt, replaced by tests that run without a database
+var recordAttempt = auditJob
+
+func processJob(ctx context.Context, job Job, handle jobHandler) {
+	err := handle(job)
+	recordAttempt(job, err)
+	if err != nil {
+		if nackErr := jobs.Nack(ctx, job, err); nackErr != nil {
+			log.Printf("❌ Failed to report failure of job %s: %v", job.ID, nackErr)
+		}
+		return
+	}
+
+	if err := jobs.Ack(ctx, job); err != nil {
+		log.Printf("❌ Failed to acknowledge job %s: %v", job.ID, err)
+	}
+}
+
+// auditJob records the outcome of an attempt of a deployment job in the audit log
+func auditJob(job Job, err error) {
+	actor, action := job.Queue, "process"
+	switch job.Queue {
+	case InstallerQueue:
+		actor, action = InstallerActor, "install"
+	case UninstallerQueue:
+		actor, action = deprovisioner.UninstallerActor, "uninstall"
+	case UpgraderQueue:
+		actor, action = upgrader.UpgraderActor, job.Payload["action"]
+	}
+	audit.Background(actor, action, "deployment", job.Payload["deployment_id"], err)
+}
+
+// permanentError marks a failure that retrying cannot fix (e.g. a malformed message)
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// permanent wraps err so the job is dead-lettered without further retries
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// shouldDeadLetter reports whether a job that failed for the attempts-th time is given up on
+func shouldDeadLetter(attempts int, cause error) bool {
+	var perr *permanentError
+	return errors.As(cause, &perr) || attempts >= maxAttempts()
+}
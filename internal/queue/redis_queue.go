@@ -0,0 +1,398 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	redis "github.com/redis/go-redis/v9"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConsumerGroup is the Redis consumer group shared by every marketplace instance.
+// Each message of a stream is delivered to exactly one consumer of the group.
+const ConsumerGroup = "marketplace"
+
+// RedisQueue is a JobQueue backed by Redis streams and consumer groups. Failed
+// messages wait in a sorted set for their backoff and exhausted ones are moved
+// to a "<stream>:dlq" stream.
+type RedisQueue struct {
+	client *redis.Client
+
+	mu          sync.Mutex
+	groups      map[string]bool
+	lastReclaim map[string]time.Time
+	lastPromote map[string]time.Time
+}
+
+func NewRedisQueue(addr string) *RedisQueue {
+	return &RedisQueue{
+		client:      redis.NewClient(&redis.Options{Addr: addr}),
+		groups:      make(map[string]bool),
+		lastReclaim: make(map[string]time.Time),
+		lastPromote: make(map[string]time.Time),
+	}
+}
+
+// retryQueue holds failed messages waiting for their backoff to elapse, scored by due time
+func retryQueue(stream string) string {
+	return stream + ":retry"
+}
+
+// bookkeepingFields are stored next to the payload of retried and dead-lettered messages
+var bookkeepingFields = []string{"attempts", "last_error", "retry_of", "failed_at", "source_id"}
+
+func (q *RedisQueue) Enqueue(ctx context.Context, stream string, payload map[string]string) error {
+	return q.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		Values: payload,
+	}).Err()
+}
+
+// Consume first hands out messages left pending too long by other consumers,
+// then reads new messages through the consumer group
+func (q *RedisQueue) Consume(ctx context.Context, stream string, count int) ([]Job, error) {
+	if err := q.ensureConsumerGroup(ctx, stream); err != nil {
+		return nil, err
+	}
+
+	q.promoteRetries(ctx, stream)
+
+	if reclaimed := q.reclaimPending(ctx, stream, count); len(reclaimed) > 0 {
+		return reclaimed, nil
+	}
+
+	streams, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    ConsumerGroup,
+		Consumer: consumerName,
+		Streams:  []string{stream, ">"},
+		Count:    int64(count),
+		Block:    consumeBlock,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "NOGROUP") {
+			q.mu.Lock()
+			delete(q.groups, stream)
+			q.mu.Unlock()
+		}
+		return nil, err
+	}
+
+	var batch []Job
+	for _, s := range streams {
+		for _, message := range s.Messages {
+			batch = append(batch, toJob(stream, message))
+		}
+	}
+	return batch, nil
+}
+
+func (q *RedisQueue) Ack(ctx context.Context, job Job) error {
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, job.Queue, ConsumerGroup, job.ID)
+		pipe.XDel(ctx, job.Queue, job.ID)
+		return nil
+	})
+	return err
+}
+
+// Nack schedules the message for another attempt or moves it to the dead-letter
+// stream. The original entry is acknowledged in the same transaction; if anything
+// fails it simply stays pending and is reclaimed later.
+func (q *RedisQueue) Nack(ctx context.Context, job Job, cause error) error {
+	attempts := job.Attempts + 1
+
+	fields := make(map[string]string, len(job.Payload)+4)
+	for key, value := range job.Payload {
+		fields[key] = value
+	}
+	fields["attempts"] = strconv.Itoa(attempts)
+	fields["last_error"] = cause.Error()
+
+	if shouldDeadLetter(attempts, cause) {
+		fields["failed_at"] = time.Now().UTC().Format(time.RFC3339)
+		fields["source_id"] = job.ID
+
+		_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.XAdd(ctx, &redis.XAddArgs{Stream: DeadLetterQueue(job.Queue), Values: fields})
+			pipe.XAck(ctx, job.Queue, ConsumerGroup, job.ID)
+			pipe.XDel(ctx, job.Queue, job.ID)
+			return nil
+		})
+		if err == nil {
+			log.Printf("☠️ Message %s from %s moved to %s after %d attempt(s): %v", job.ID, job.Queue, DeadLetterQueue(job.Queue), attempts, cause)
+		}
+		return err
+	}
+
+	delay := retryDelay(attempts)
+	err := q.retryLater(ctx, job, fields, delay)
+	if err == nil {
+		log.Printf("🔁 Message %s from %s failed (attempt %d/%d), retrying in %s: %v", job.ID, job.Queue, attempts, maxAttempts(), delay, cause)
+	}
+	return err
+}
+
+// Release moves a message that never started to the retry set, so it is
+// re-published once delay elapsed with its attempts unchanged
+func (q *RedisQueue) Release(ctx context.Context, job Job, delay time.Duration) error {
+	fields := make(map[string]string, len(job.Payload)+2)
+	for key, value := range job.Payload {
+		fields[key] = value
+	}
+	fields["attempts"] = strconv.Itoa(job.Attempts)
+	return q.retryLater(ctx, job, fields, delay)
+}
+
+// retryLater acknowledges a message and adds fields to the retry set, due after delay
+func (q *RedisQueue) retryLater(ctx context.Context, job Job, fields map[string]string, delay time.Duration) error {
+	fields["retry_of"] = job.ID
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	due := time.Now().Add(delay)
+	_, err = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, retryQueue(job.Queue), redis.Z{Score: float64(due.UnixMilli()), Member: payload})
+		pipe.XAck(ctx, job.Queue, ConsumerGroup, job.ID)
+		pipe.XDel(ctx, job.Queue, job.ID)
+		return nil
+	})
+	return err
+}
+
+// Extend claims the message again for this consumer, resetting its idle time
+func (q *RedisQueue) Extend(ctx context.Context, job Job) error {
+	return q.client.XClaimJustID(ctx, &redis.XClaimArgs{
+		Stream:   job.Queue,
+		Group:    ConsumerGroup,
+		Consumer: consumerName,
+		Messages: []string{job.ID},
+	}).Err()
+}
+
+// List returns the messages still on the stream, delivered or not, and the ones
+// waiting in the retry set
+func (q *RedisQueue) List(ctx context.Context, stream string) ([]Job, error) {
+	messages, err := q.client.XRange(ctx, stream, "-", "+").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	listed := make([]Job, 0, len(messages))
+	for _, message := range messages {
+		listed = append(listed, toJob(stream, message))
+	}
+
+	retries, err := q.client.ZRange(ctx, retryQueue(stream), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, payload := range retries {
+		var fields map[string]string
+		if err := json.Unmarshal([]byte(payload), &fields); err != nil {
+			continue
+		}
+		attempts, _ := strconv.Atoi(fields["attempts"])
+		id := fields["retry_of"]
+		for _, key := range bookkeepingFields {
+			delete(fields, key)
+		}
+		listed = append(listed, Job{ID: id, Queue: stream, Payload: fields, Attempts: attempts})
+	}
+	return listed, nil
+}
+
+// ensureConsumerGroup creates the stream and its consumer group if they don't exist yet
+func (q *RedisQueue) ensureConsumerGroup(ctx context.Context, stream string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.groups[stream] {
+		return nil
+	}
+
+	err := q.client.XGroupCreateMkStream(ctx, stream, ConsumerGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group for %s: %w", stream, err)
+	}
+	q.groups[stream] = true
+	return nil
+}
+
+// due reports whether a periodic task last run at last[stream] should run again
+func (q *RedisQueue) due(last map[string]time.Time, stream string, every time.Duration) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if time.Since(last[stream]) < every {
+		return false
+	}
+	last[stream] = time.Now()
+	return true
+}
+
+// reclaimPending claims messages that stayed pending longer than claimMinIdle,
+// whichever consumer they were delivered to
+func (q *RedisQueue) reclaimPending(ctx context.Context, stream string, count int) []Job {
+	if !q.due(q.lastReclaim, stream, reclaimInterval()) {
+		return nil
+	}
+
+	messages, _, err := q.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   stream,
+		Group:    ConsumerGroup,
+		Consumer: consumerName,
+		MinIdle:  claimMinIdle(),
+		Start:    "0-0",
+		Count:    int64(count),
+	}).Result()
+	if err != nil {
+		log.Printf("❌ Failed to reclaim pending messages of %s: %v", stream, err)
+		return nil
+	}
+
+	var batch []Job
+	for _, message := range messages {
+		log.Printf("♻️ Reclaimed pending message %s from %s", message.ID, stream)
+		batch = append(batch, toJob(stream, message))
+	}
+	if len(batch) == count {
+		// There may be more idle messages; check again on the next call
+		q.mu.Lock()
+		delete(q.lastReclaim, stream)
+		q.mu.Unlock()
+	}
+	return batch
+}
+
+// promoteScript atomically moves due entries from the retry set back onto the stream
+var promoteScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, payload in ipairs(due) do
+	redis.call('ZREM', KEYS[1], payload)
+	local args = {}
+	for key, value in pairs(cjson.decode(payload)) do
+		table.insert(args, key)
+		table.insert(args, value)
+	end
+	redis.call('XADD', KEYS[2], '*', unpack(args))
+end
+return #due
+`)
+
+// promoteRetries re-publishes retried messages to the stream once their backoff elapsed
+func (q *RedisQueue) promoteRetries(ctx context.Context, stream string) {
+	if !q.due(q.lastPromote, stream, time.Second) {
+		return
+	}
+
+	moved, err := promoteScript.Run(ctx, q.client, []string{retryQueue(stream), stream}, time.Now().UnixMilli(), 100).Int()
+	if err != nil {
+		log.Printf("❌ Failed to promote retries of %s: %v", stream, err)
+		return
+	}
+	if moved > 0 {
+		log.Printf("🔁 Re-queued %d message(s) on %s", moved, stream)
+	}
+}
+
+func messageFields(message redis.XMessage) map[string]string {
+	fields := make(map[string]string, len(message.Values))
+	for key, value := range message.Values {
+		fields[key] = fmt.Sprint(value)
+	}
+	return fields
+}
+
+func toJob(stream string, message redis.XMessage) Job {
+	fields := messageFields(message)
+	attempts, _ := strconv.Atoi(fields["attempts"])
+	for _, key := range bookkeepingFields {
+		delete(fields, key)
+	}
+	return Job{ID: message.ID, Queue: stream, Payload: fields, Attempts: attempts}
+}
+
+func toDeadLetter(stream string, message redis.XMessage) DeadLetter {
+	fields := messageFields(message)
+	letter := DeadLetter{
+		ID:        message.ID,
+		Queue:     stream,
+		SourceID:  fields["source_id"],
+		LastError: fields["last_error"],
+	}
+	letter.Attempts, _ = strconv.Atoi(fields["attempts"])
+	letter.FailedAt, _ = time.Parse(time.RFC3339, fields["failed_at"])
+
+	for _, key := range bookkeepingFields {
+		delete(fields, key)
+	}
+	letter.Payload = fields
+	return letter
+}
+
+func (q *RedisQueue) ListDeadLetters(ctx context.Context, stream string, count int64) ([]DeadLetter, error) {
+	messages, err := q.client.XRangeN(ctx, DeadLetterQueue(stream), "-", "+", count).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]DeadLetter, 0, len(messages))
+	for _, message := range messages {
+		letters = append(letters, toDeadLetter(stream, message))
+	}
+	return letters, nil
+}
+
+func (q *RedisQueue) GetDeadLetter(ctx context.Context, stream, id string) (*DeadLetter, error) {
+	messages, err := q.client.XRangeN(ctx, DeadLetterQueue(stream), id, id, 1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, ErrDeadLetterNotFound
+	}
+
+	letter := toDeadLetter(stream, messages[0])
+	return &letter, nil
+}
+
+func (q *RedisQueue) RequeueDeadLetter(ctx context.Context, stream, id string) error {
+	letter, err := q.GetDeadLetter(ctx, stream, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: letter.Payload})
+		pipe.XDel(ctx, DeadLetterQueue(stream), id)
+		return nil
+	})
+	return err
+}
+
+func (q *RedisQueue) PurgeDeadLetters(ctx context.Context, stream string, ids ...string) (int64, error) {
+	if len(ids) == 0 {
+		count, err := q.client.XLen(ctx, DeadLetterQueue(stream)).Result()
+		if err != nil {
+			return 0, err
+		}
+		return count, q.client.Del(ctx, DeadLetterQueue(stream)).Err()
+	}
+
+	deleted, err := q.client.XDel(ctx, DeadLetterQueue(stream), ids...).Result()
+	if err != nil {
+		return 0, err
+	}
+	if deleted == 0 {
+		return 0, ErrDeadLetterNotFound
+	}
+	return deleted, nil
+}
@@ -0,0 +1,122 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestRedisQueue connects to the Redis at REDIS_ADDR and returns a stream
+// name unique to the test; the test is skipped when REDIS_ADDR is not set
+func newTestRedisQueue(t *testing.T) (*RedisQueue, string) {
+	t.Helper()
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR not set")
+	}
+
+	q := NewRedisQueue(addr)
+	ctx := context.Background()
+	if err := q.client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Redis at %s not reachable: %v", addr, err)
+	}
+
+	stream := fmt.Sprintf("test_queue_%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		q.client.Del(ctx, stream, retryQueue(stream), DeadLetterQueue(stream))
+		q.client.Close()
+	})
+	return q, stream
+}
+
+// withConsumerName runs f as the consumer called name
+func withConsumerName(name string, f func()) {
+	previous := consumerName
+	consumerName = name
+	defer func() { consumerName = previous }()
+	f()
+}
+
+func TestRedisQueueDeliversEachMessageToOneConsumer(t *testing.T) {
+	q, stream := newTestRedisQueue(t)
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		if err := q.Enqueue(ctx, stream, map[string]string{"deployment_id": fmt.Sprint(i)}); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+
+	seen := make(map[string]string)
+	for _, consumer := range []string{"a", "b"} {
+		withConsumerName(consumer, func() {
+			batch, err := q.Consume(ctx, stream, 2)
+			if err != nil {
+				t.Fatalf("Consume as %s: %v", consumer, err)
+			}
+			if len(batch) != 2 {
+				t.Fatalf("Consume as %s returned %d job(s), want 2", consumer, len(batch))
+			}
+			for _, job := range batch {
+				if other, ok := seen[job.ID]; ok {
+					t.Fatalf("message %s delivered to both %s and %s", job.ID, other, consumer)
+				}
+				seen[job.ID] = consumer
+			}
+		})
+	}
+
+	withConsumerName("c", func() {
+		batch, err := q.Consume(ctx, stream, 1)
+		if err != nil {
+			t.Fatalf("Consume: %v", err)
+		}
+		if len(batch) != 0 {
+			t.Fatalf("delivered message %s again before it was idle", batch[0].ID)
+		}
+	})
+}
+
+func TestRedisQueueReclaimsIdleMessages(t *testing.T) {
+	t.Setenv("QUEUE_CLAIM_MIN_IDLE", "100ms")
+	t.Setenv("QUEUE_RECLAIM_INTERVAL", "1ms")
+	q, stream := newTestRedisQueue(t)
+	ctx := context.Background()
+
+	if err := q.Enqueue(ctx, stream, map[string]string{"deployment_id": "1"}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	var crashed Job
+	withConsumerName("crashed", func() {
+		batch, err := q.Consume(ctx, stream, 1)
+		if err != nil || len(batch) != 1 {
+			t.Fatalf("Consume = %v, %v, want 1 job", batch, err)
+		}
+		crashed = batch[0]
+	})
+
+	time.Sleep(200 * time.Millisecond)
+	withConsumerName("survivor", func() {
+		batch, err := q.Consume(ctx, stream, 1)
+		if err != nil || len(batch) != 1 {
+			t.Fatalf("Consume = %v, %v, want the idle job", batch, err)
+		}
+		if batch[0].ID != crashed.ID {
+			t.Fatalf("reclaimed %s, want %s", batch[0].ID, crashed.ID)
+		}
+		if err := q.Ack(ctx, batch[0]); err != nil {
+			t.Fatalf("Ack: %v", err)
+		}
+	})
+
+	listed, err := q.List(ctx, stream)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(listed) != 0 {
+		t.Fatalf("List = %v after Ack, want nothing", listed)
+	}
+}
@@ -4,27 +4,22 @@ import (
 	"context"
 	"fmt"
 	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/deprovisioner"
-	redis "github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
 	"log"
-	"time"
 )
 
-// Stream Name
+// Queue Name
 const UninstallerQueue = "delete_queue"
 
-// PushToUninstallerQueue pushes a delete message to Redis
-func PushToUninstallerQueue(req deprovisioner.UninstallRequest) error {
-	ctx := context.Background()
-
-	_, err := redisClient.XAdd(ctx, &redis.XAddArgs{
-		Stream: UninstallerQueue,
-		Values: map[string]interface{}{
-			"deployment_id":   req.DeploymentID,
-			"deployment_type": req.DeploymentType,
-			"cluster_name":    req.ClusterName,
-			"vm_name":         req.VMName,
-		},
-	}).Result()
+// PushToUninstallerQueue writes a delete job to the outbox within tx, the
+// transaction that moves the deployment to "uninstalling"
+func PushToUninstallerQueue(tx *gorm.DB, req deprovisioner.UninstallRequest) error {
+	err := enqueueOutbox(tx, UninstallerQueue, map[string]string{
+		"deployment_id":   req.DeploymentID,
+		"deployment_type": req.DeploymentType,
+		"cluster_name":    req.ClusterName,
+		"vm_name":         req.VMName,
+	})
 
 	if err != nil {
 		log.Println("❌ Failed to push delete request to queue:", err)
@@ -32,45 +27,25 @@ func PushToUninstallerQueue(req deprovisioner.UninstallRequest) error {
 	return err
 }
 
-// StartUninstallerConsumer processes delete messages
-func StartUninstallerConsumer() {
-	ctx := context.Background()
-	log.Println("🚀 Redis Delete Queue Consumer Started...")
-
-	for {
-		// Read messages from the delete queue
-		messages, err := redisClient.XRead(ctx, &redis.XReadArgs{
-			Streams: []string{UninstallerQueue, "0"},
-			Count:   1,
-			Block:   0, // Blocks indefinitely
-		}).Result()
-
-		if err != nil {
-			log.Println("❌ Error reading from delete queue:", err)
-			time.Sleep(2 * time.Second) // Retry delay
-			continue
-		}
+// StartUninstallerConsumer processes delete messages until ctx is cancelled and the
+// jobs it already started are finished
+func StartUninstallerConsumer(ctx context.Context) {
+	log.Printf("🚀 Delete Queue Consumer %s Started...", consumerName)
 
-		for _, stream := range messages {
-			for _, message := range stream.Messages {
-				deleteReq := deprovisioner.UninstallRequest{
-					DeploymentID:   message.Values["deployment_id"].(string),
-					DeploymentType: message.Values["deployment_type"].(string),
-					ClusterName:    message.Values["cluster_name"].(string),
-					VMName:         message.Values["vm_name"].(string),
-				}
+	pool := newPool(UninstallerQueue, "deployment_type", "UNINSTALL", 2, nil)
+	pool.Run(ctx, handleUninstallJob)
+}
 
-				fmt.Printf("🗑️  Processing Delete Request for Deployment %s\n", deleteReq.DeploymentID)
+func handleUninstallJob(job Job) error {
+	deleteReq := deprovisioner.UninstallRequest{
+		DeploymentID:   job.Payload["deployment_id"],
+		DeploymentType: job.Payload["deployment_type"],
+		ClusterName:    job.Payload["cluster_name"],
+		VMName:         job.Payload["vm_name"],
+	}
 
-				// Perform deletion
-				deprovisioner.CleanResource(deleteReq)
+	fmt.Printf("🗑️  Processing Delete Request for Deployment %s\n", deleteReq.DeploymentID)
 
-				// Acknowledge message deletion
-				_, err := redisClient.XDel(ctx, UninstallerQueue, message.ID).Result()
-				if err != nil {
-					log.Println("❌ Failed to acknowledge delete message:", err)
-				}
-			}
-		}
-	}
+	// Perform deletion
+	return deprovisioner.CleanResource(deleteReq)
 }
@@ -0,0 +1,59 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/lifecycle"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/upgrader"
+	"gorm.io/gorm"
+	"log"
+)
+
+// Queue Name
+const UpgraderQueue = "upgrade_queue"
+
+// PushToUpgraderQueue writes an upgrade or rollback job to the outbox within tx,
+// the transaction that records the pending revision
+func PushToUpgraderQueue(tx *gorm.DB, req upgrader.UpgradeRequest) error {
+	err := enqueueOutbox(tx, UpgraderQueue, map[string]string{
+		"deployment_id":   req.DeploymentID,
+		"deployment_type": req.DeploymentType,
+		"revision_id":     req.RevisionID,
+		"action":          req.Action,
+	})
+
+	if err != nil {
+		log.Println("❌ Failed to push upgrade request to queue:", err)
+	}
+	return err
+}
+
+// StartUpgraderConsumer processes upgrade and rollback messages until ctx is
+// cancelled and the jobs it already started are finished
+func StartUpgraderConsumer(ctx context.Context) {
+	log.Printf("🚀 Upgrade Queue Consumer %s Started...", consumerName)
+
+	pool := newPool(UpgraderQueue, "deployment_type", "UPGRADE", 2, nil)
+	pool.Run(ctx, handleUpgradeJob)
+}
+
+func handleUpgradeJob(job Job) error {
+	upgradeReq := upgrader.UpgradeRequest{
+		DeploymentID:   job.Payload["deployment_id"],
+		DeploymentType: job.Payload["deployment_type"],
+		RevisionID:     job.Payload["revision_id"],
+		Action:         job.Payload["action"],
+	}
+
+	fmt.Printf("⬆️ Processing %s of Deployment %s (revision %s)\n", upgradeReq.Action, upgradeReq.DeploymentID, upgradeReq.RevisionID)
+
+	if err := upgrader.Apply(upgradeReq); err != nil {
+		// The deployment or revision is gone, was superseded or e.g. uninstalled meanwhile, retrying won't help
+		if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, upgrader.ErrStaleRevision) || errors.Is(err, lifecycle.ErrInvalidTransition) {
+			return permanent(err)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/queue"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+)
+
+// queueParam returns the queue named in the URL, writing a 404 if it is unknown
+func queueParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := chi.URLParam(r, "queue")
+	if !queue.IsKnownQueue(name) {
+		http.Error(w, fmt.Sprintf("Unknown queue: %s", name), http.StatusNotFound)
+		return "", false
+	}
+	return name, true
+}
+
+// ListDeadLetters API to list dead-lettered jobs of a queue
+func ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	name, ok := queueParam(w, r)
+	if !ok {
+		return
+	}
+
+	count, _ := strconv.ParseInt(r.URL.Query().Get("count"), 10, 64)
+	if count < 1 {
+		count = 100
+	}
+
+	letters, err := queue.ListDeadLetters(r.Context(), name, count)
+	if err != nil {
+		http.Error(w, "Failed to fetch dead-lettered jobs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(letters)
+}
+
+// GetDeadLetter API to inspect a single dead-lettered job
+func GetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	name, ok := queueParam(w, r)
+	if !ok {
+		return
+	}
+
+	letter, err := queue.GetDeadLetter(r.Context(), name, chi.URLParam(r, "id"))
+	if errors.Is(err, queue.ErrDeadLetterNotFound) {
+		http.Error(w, "Dead-lettered job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch dead-lettered job", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(letter)
+}
+
+// RequeueDeadLetter API to push a dead-lettered job back to its queue
+func RequeueDeadLetter(w http.ResponseWriter, r *http.Request) {
+	name, ok := queueParam(w, r)
+	if !ok {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	err := queue.RequeueDeadLetter(r.Context(), name, id)
+	if errors.Is(err, queue.ErrDeadLetterNotFound) {
+		http.Error(w, "Dead-lettered job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to requeue job", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": fmt.Sprintf("Job %s requeued on %s", id, name),
+	})
+}
+
+// PurgeDeadLetter API to delete a single dead-lettered job
+func PurgeDeadLetter(w http.ResponseWriter, r *http.Request) {
+	name, ok := queueParam(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := queue.PurgeDeadLetters(r.Context(), name, chi.URLParam(r, "id"))
+	if errors.Is(err, queue.ErrDeadLetterNotFound) {
+		http.Error(w, "Dead-lettered job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to purge job", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PurgeDeadLetters API to delete every dead-lettered job of a queue
+func PurgeDeadLetters(w http.ResponseWriter, r *http.Request) {
+	name, ok := queueParam(w, r)
+	if !ok {
+		return
+	}
+
+	purged, err := queue.PurgeDeadLetters(r.Context(), name)
+	if err != nil {
+		http.Error(w, "Failed to purge jobs", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": fmt.Sprintf("%d job(s) purged from %s", purged, queue.DeadLetterQueue(name)),
+	})
+}
@@ -0,0 +1,116 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/audit"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/quotas"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm/clause"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SetQuota API to override the limits of a user, project or organization.
+// Omitted limits use the defaults, negative ones are unlimited.
+func SetQuota(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MaxActiveDeployments *int     `json:"max_active_deployments"`
+		MaxK8sDeployments    *int     `json:"max_k8s_deployments"`
+		MaxCPU               *float64 `json:"max_cpu"`
+		MaxMemoryGB          *float64 `json:"max_memory_gb"`
+		MaxMonthlySpend      *float64 `json:"max_monthly_spend"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	scope, scopeID, ok := quotaScope(w, r)
+	if !ok {
+		return
+	}
+
+	quota := models.Quota{
+		Scope:                scope,
+		ScopeID:              scopeID,
+		MaxActiveDeployments: req.MaxActiveDeployments,
+		MaxK8sDeployments:    req.MaxK8sDeployments,
+		MaxCPU:               req.MaxCPU,
+		MaxMemoryGB:          req.MaxMemoryGB,
+		MaxMonthlySpend:      req.MaxMonthlySpend,
+	}
+	if err := database.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "scope"}, {Name: "scope_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"max_active_deployments", "max_k8s_deployments", "max_cpu", "max_memory_gb", "max_monthly_spend", "updated_at",
+		}),
+	}).Create(&quota).Error; err != nil {
+		http.Error(w, "Failed to save quota", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("📏 Quota of %s %d overridden", scope, scopeID)
+
+	usage, err := quotas.GetUsage(database.DB, scope, scopeID)
+	if err != nil {
+		http.Error(w, "Failed to compute quota usage", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
+
+// DeleteQuota API to remove the override of a scope, so the defaults apply again
+func DeleteQuota(w http.ResponseWriter, r *http.Request) {
+	scope, scopeID, ok := quotaScope(w, r)
+	if !ok {
+		return
+	}
+
+	result := database.DB.Where("scope = ? AND scope_id = ?", scope, scopeID).Delete(&models.Quota{})
+	if result.Error != nil {
+		http.Error(w, "Failed to delete quota", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Quota not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// quotaScope validates the scope and ID of the request and checks the user,
+// project or organization exists
+func quotaScope(w http.ResponseWriter, r *http.Request) (string, uint, bool) {
+	scope := chi.URLParam(r, "scope")
+	if !quotas.IsValidScope(scope) {
+		http.Error(w, fmt.Sprintf("Invalid scope. Valid scopes are: %s", strings.Join(quotas.Scopes, ", ")), http.StatusBadRequest)
+		return "", 0, false
+	}
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return "", 0, false
+	}
+
+	var row interface{}
+	switch scope {
+	case quotas.ScopeUser:
+		row = &models.User{}
+	case quotas.ScopeProject:
+		row = &models.Project{}
+	case quotas.ScopeOrganization:
+		row = &models.Organization{}
+	}
+	if err := database.DB.Select("id").First(row, id).Error; err != nil {
+		http.Error(w, fmt.Sprintf("%s%s not found", strings.ToUpper(scope[:1]), scope[1:]), http.StatusNotFound)
+		return "", 0, false
+	}
+	audit.Resource(r, "quota", fmt.Sprintf("%s/%d", scope, id))
+	return scope, uint(id), true
+}
@@ -0,0 +1,29 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/secrets"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/envelope"
+	"net/http"
+)
+
+// RotateSecrets API to rewrap the data keys of all deployment secrets with the
+// active master key, after a new key was prepended to SECRETS_MASTER_KEYS
+func RotateSecrets(w http.ResponseWriter, r *http.Request) {
+	rotated, err := secrets.Rotate(database.DB)
+	if err != nil {
+		if errors.Is(err, envelope.ErrNotConfigured) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, "Failed to rotate secrets: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rotated": rotated,
+	})
+}
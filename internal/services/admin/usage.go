@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/audit"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/billing"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// AdminActor is recorded on the usage events appended by admins
+const AdminActor = "admin"
+
+// AppendUsageEvent API to correct the billing of a deployment by appending a
+// usage event, possibly dated in the past: "paused" and "resumed" to leave out
+// e.g. an outage, "price_changed" to bill it at another hourly rate or on
+// another plan of its application from then on
+func AppendUsageEvent(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Kind          string     `json:"kind"`
+		OccurredAt    *time.Time `json:"occurred_at"`     // Now if omitted
+		HourlyRate    *float64   `json:"hourly_rate"`     // For "price_changed" without a plan
+		PricingPlanID uint       `json:"pricing_plan_id"` // For "price_changed" on a plan
+		Message       string     `json:"message"`         // Why the billing is corrected
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	var deployment models.Deployment
+	if err := database.DB.Unscoped().First(&deployment, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+		return
+	}
+
+	event := models.UsageEvent{
+		DeploymentID: deployment.ID,
+		Kind:         req.Kind,
+		OccurredAt:   time.Now(),
+		Actor:        AdminActor,
+		Message:      req.Message,
+	}
+	if req.OccurredAt != nil {
+		if req.OccurredAt.After(event.OccurredAt) {
+			http.Error(w, "occurred_at cannot be in the future", http.StatusBadRequest)
+			return
+		}
+		event.OccurredAt = *req.OccurredAt
+	}
+	if req.Message == "" {
+		http.Error(w, "A message explaining the correction is required", http.StatusBadRequest)
+		return
+	}
+
+	switch req.Kind {
+	case billing.EventPaused, billing.EventResumed:
+	case billing.EventPriceChanged:
+		switch {
+		case req.PricingPlanID != 0:
+			var plan models.PricingPlan
+			if err := database.DB.
+				Where("application_version_id IN (SELECT id FROM application_versions WHERE application_id = ?)", deployment.ApplicationID).
+				First(&plan, req.PricingPlanID).Error; err != nil {
+				http.Error(w, "Pricing plan not found", http.StatusNotFound)
+				return
+			}
+			event.Plan = &plan
+			event.HourlyRate = plan.HourlyRate
+		case req.HourlyRate != nil && *req.HourlyRate >= 0:
+			event.HourlyRate = *req.HourlyRate
+		default:
+			http.Error(w, "price_changed events need a pricing_plan_id or a non-negative hourly_rate", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Only paused, resumed and price_changed events can be appended, the others are recorded by the deployment lifecycle", http.StatusBadRequest)
+		return
+	}
+
+	event, err := billing.Append(database.DB, event)
+	if err != nil {
+		if errors.Is(err, billing.ErrInvalidEvent) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Deployment not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to append usage event", http.StatusInternalServerError)
+		return
+	}
+	audit.Resource(r, "usage_event", event.ID)
+	audit.Changes(r, nil, event)
+
+	var record models.BillingRecord
+	if err := database.DB.Where("deployment_id = ?", strconv.FormatUint(uint64(event.DeploymentID), 10)).First(&record).Error; err != nil {
+		http.Error(w, "Failed to fetch billing record", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"event":          event,
+		"billing_record": record,
+	})
+}
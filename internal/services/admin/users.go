@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// SetUserRole API to change the role of a user, e.g. to promote a publisher or another admin
+func SetUserRole(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if !middleware.IsValidRole(req.Role) {
+		http.Error(w, fmt.Sprintf("Invalid role. Valid roles are: %s", strings.Join(middleware.Roles, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	// Admins cannot demote themselves, so there is always one left
+	if user.ID == middleware.UserID(r) && req.Role != middleware.RoleAdmin {
+		http.Error(w, "Admins cannot change their own role", http.StatusConflict)
+		return
+	}
+
+	if err := database.DB.Model(&user).Update("role", req.Role).Error; err != nil {
+		http.Error(w, "Failed to update role", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("👤 User %d is now %s", user.ID, req.Role)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
@@ -0,0 +1,13 @@
+package admin
+
+import (
+	"encoding/json"
+	"github.com/Vinayakatk/marketplace-prototype/internal/queue"
+	"net/http"
+)
+
+// ListWorkers API to show the worker pools of this instance and their in-flight jobs
+func ListWorkers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queue.PoolStatuses())
+}
@@ -0,0 +1,251 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// UserActor is the actor of the entries of API calls
+const UserActor = "user"
+
+// Outcomes of audited actions
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// resourceTypes maps the path segments of the routes to the resource types
+// recorded for them, see resourceOf
+var resourceTypes = map[string]string{
+	"apps":          "application",
+	"versions":      "application_version",
+	"reviews":       "application_version",
+	"plans":         "pricing_plan",
+	"deployments":   "deployment",
+	"project":       "project",
+	"orgs":          "organization",
+	"members":       "membership",
+	"invites":       "invite",
+	"users":         "user",
+	"api-keys":      "api_key",
+	"notifications": "notification",
+	"auth":          "token",
+	"dlq":           "dead_letter",
+	"quotas":        "quota",
+	"secrets":       "deployment_secret",
+}
+
+// call collects what the handler of an API call reports about it
+type call struct {
+	resourceType string
+	resourceID   string
+	changes      map[string]interface{}
+}
+
+type callKey struct{}
+
+// Log records every mutating API call (anything but GET, HEAD and OPTIONS) with
+// its caller, route, resource and response status once the handler returned.
+// It has to run after Authenticate to know the caller.
+func Log(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		c := &call{}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), callKey{}, c)))
+
+		action := r.Method + " " + r.URL.Path
+		resourceType, resourceID := "", ""
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			action = r.Method + " " + rctx.RoutePattern()
+			resourceType, resourceID = resourceOf(rctx)
+		}
+		if c.resourceType != "" {
+			resourceType, resourceID = c.resourceType, c.resourceID
+		}
+
+		entry := models.AuditEntry{
+			Actor:        UserActor,
+			Action:       action,
+			ResourceType: resourceType,
+			ResourceID:   resourceID,
+			RequestID:    middleware.GetRequestID(r.Context()),
+			StatusCode:   recorder.status,
+			Outcome:      OutcomeSuccess,
+			Changes:      c.changes,
+		}
+		if userID := middleware.UserID(r); userID != 0 {
+			entry.UserID = &userID
+		}
+		if recorder.status >= http.StatusBadRequest {
+			entry.Outcome = OutcomeFailure
+			entry.Changes = nil
+		}
+		write(entry)
+	})
+}
+
+// Resource sets the resource an API call acted on, e.g. the ID of a resource it
+// created, instead of the one taken from the route
+func Resource(r *http.Request, resourceType string, resourceID interface{}) {
+	if c, ok := r.Context().Value(callKey{}).(*call); ok {
+		c.resourceType = resourceType
+		c.resourceID = toString(resourceID)
+	}
+}
+
+// Changes records the fields of a resource an API call changed. before is nil
+// for created resources and after for deleted ones; secrets have to be masked
+// by the caller.
+func Changes(r *http.Request, before, after interface{}) {
+	if c, ok := r.Context().Value(callKey{}).(*call); ok {
+		c.changes = Diff(before, after)
+	}
+}
+
+// Background records an action of a background worker, failed if err is set
+func Background(actor, action, resourceType string, resourceID interface{}, err error) {
+	entry := models.AuditEntry{
+		Actor:        actor,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   toString(resourceID),
+		Outcome:      OutcomeSuccess,
+	}
+	if err != nil {
+		entry.Outcome = OutcomeFailure
+		entry.Error = err.Error()
+	}
+	write(entry)
+}
+
+// Diff returns the top-level fields that differ between two values as they are
+// encoded to JSON, mapped to their "before" and "after" values
+func Diff(before, after interface{}) map[string]interface{} {
+	previous, current := fields(before), fields(after)
+	changes := make(map[string]interface{})
+	for name, value := range current {
+		if old := previous[name]; !reflect.DeepEqual(old, value) && !(isZero(old) && isZero(value)) {
+			changes[name] = map[string]interface{}{"before": old, "after": value}
+		}
+	}
+	for name, value := range previous {
+		if _, ok := current[name]; !ok && !isZero(value) {
+			changes[name] = map[string]interface{}{"before": value, "after": nil}
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+func fields(value interface{}) map[string]interface{} {
+	if value == nil || reflect.ValueOf(value).Kind() == reflect.Ptr && reflect.ValueOf(value).IsNil() {
+		return nil
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		log.Println("⚠️ Failed to encode audited resource:", err)
+		return nil
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil
+	}
+	return decoded
+}
+
+// isZero reports whether a decoded JSON value is empty, e.g. an association that
+// wasn't loaded, so it is left out of the changes of created and deleted resources
+func isZero(value interface{}) bool {
+	switch value := value.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case float64:
+		return value == 0
+	case bool:
+		return !value
+	case []interface{}:
+		return len(value) == 0
+	case map[string]interface{}:
+		for _, v := range value {
+			if !isZero(v) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// resourceOf derives the resource of an API call from its route: the last path
+// parameter and the segment before it naming the resource type, e.g.
+// ("deployment", "42") for /api/deployments/{id}/upgrade. Routes without
+// parameters only get the type, e.g. "application" for /api/apps/new.
+func resourceOf(rctx *chi.Context) (string, string) {
+	segments := strings.Split(strings.Trim(rctx.RoutePattern(), "/"), "/")
+	id := ""
+	end := len(segments)
+	for i := len(segments) - 1; i >= 0; i-- {
+		if strings.HasPrefix(segments[i], "{") {
+			id = rctx.URLParam(strings.Trim(segments[i], "{}"))
+			end = i
+			break
+		}
+	}
+	for i := end - 1; i >= 0; i-- {
+		if resourceType, ok := resourceTypes[segments[i]]; ok {
+			return resourceType, id
+		}
+	}
+	return "", id
+}
+
+func write(entry models.AuditEntry) {
+	if err := database.DB.Create(&entry).Error; err != nil {
+		log.Printf("❌ Failed to write audit entry for %s %s: %v", entry.Actor, entry.Action, err)
+	}
+}
+
+func toString(id interface{}) string {
+	if id == nil {
+		return ""
+	}
+	return fmt.Sprint(id)
+}
+
+// statusRecorder keeps the status of the response passing through
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,95 @@
+package audit
+
+import (
+	"encoding/json"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxLimit bounds the entries returned by one page of ListAuditLog
+const maxLimit = 1000
+
+// ListAuditLog API to search the audit log (only for admins), newest first.
+// Filters: actor, user_id, action, resource_type, resource_id, request_id,
+// outcome, since and until (RFC 3339). Pages are requested with limit and
+// before_id; ?format=jsonl exports every matching entry as JSON lines, oldest first.
+func ListAuditLog(w http.ResponseWriter, r *http.Request) {
+	query := database.DB.Model(&models.AuditEntry{})
+	params := r.URL.Query()
+	for _, column := range []string{"actor", "user_id", "action", "resource_type", "resource_id", "request_id", "outcome"} {
+		if value := params.Get(column); value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	for param, condition := range map[string]string{"since": "created_at >= ?", "until": "created_at < ?"} {
+		if value := params.Get(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, "Invalid "+param+", expected an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			query = query.Where(condition, t)
+		}
+	}
+
+	if params.Get("format") == "jsonl" {
+		export(w, query)
+		return
+	}
+
+	limit := 100
+	if value := params.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit > maxLimit {
+			limit = maxLimit
+		}
+	}
+	if value := params.Get("before_id"); value != "" {
+		query = query.Where("id < ?", value)
+	}
+
+	var entries []models.AuditEntry
+	if err := query.Order("id DESC").Limit(limit).Find(&entries).Error; err != nil {
+		http.Error(w, "Failed to fetch audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// export streams the entries matching query as JSON lines without loading all of them
+func export(w http.ResponseWriter, query *gorm.DB) {
+	rows, err := query.Order("id").Rows()
+	if err != nil {
+		http.Error(w, "Failed to fetch audit log", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	encoder := json.NewEncoder(w)
+	for rows.Next() {
+		var entry models.AuditEntry
+		if err := database.DB.ScanRows(rows, &entry); err != nil {
+			log.Println("❌ Failed to read audit entry:", err)
+			return
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return // Client went away
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("❌ Failed to export audit log:", err)
+	}
+}
@@ -2,15 +2,27 @@ package billing
 
 import (
 	"encoding/json"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/orgs"
 	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
 	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
 	"github.com/go-chi/chi/v5"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // Get billing history for a specific user
 func GetUserBilling(w http.ResponseWriter, r *http.Request) {
-	userID := chi.URLParam(r, "id")
+	userID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if !middleware.Owns(r, uint(userID)) {
+		middleware.Forbidden(w)
+		return
+	}
 
 	var records []models.BillingRecord
 	if err := database.DB.Where("consumer_id = ?", userID).Find(&records).Error; err != nil {
@@ -23,7 +35,15 @@ func GetUserBilling(w http.ResponseWriter, r *http.Request) {
 
 // Get Billing data by User (Consumer) and Deployment ID
 func GetBillingByUserAndDeployment(w http.ResponseWriter, r *http.Request) {
-	consumerID := chi.URLParam(r, "consumerID")
+	consumerID, err := strconv.ParseUint(chi.URLParam(r, "consumerID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if !middleware.Owns(r, uint(consumerID)) {
+		middleware.Forbidden(w)
+		return
+	}
 	deploymentID := chi.URLParam(r, "deploymentID")
 
 	var records []models.BillingRecord
@@ -40,3 +60,91 @@ func GetBillingByUserAndDeployment(w http.ResponseWriter, r *http.Request) {
 	// Return the records
 	json.NewEncoder(w).Encode(records)
 }
+
+// Get billing history of the deployments in a project
+func GetProjectBilling(w http.ResponseWriter, r *http.Request) {
+	var project models.Project
+	if err := database.DB.First(&project, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if !orgs.CanViewProject(r, project) {
+		middleware.Forbidden(w)
+		return
+	}
+
+	// Uninstalled deployments are soft-deleted, their records are still listed
+	var records []models.BillingRecord
+	if err := database.DB.Joins("JOIN deployments ON CAST(deployments.id AS TEXT) = billing_records.deployment_id").
+		Where("deployments.project_id = ?", project.ID).
+		Find(&records).Error; err != nil {
+		http.Error(w, "Failed to fetch billing records", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(records)
+}
+
+// Get billing history of the deployments in the projects of an organization
+func GetOrganizationBilling(w http.ResponseWriter, r *http.Request) {
+	organization, ok := orgs.MemberOrganization(w, r, orgs.RoleViewer)
+	if !ok {
+		return
+	}
+
+	var records []models.BillingRecord
+	if err := database.DB.Joins("JOIN deployments ON CAST(deployments.id AS TEXT) = billing_records.deployment_id").
+		Joins("JOIN projects ON projects.id = deployments.project_id").
+		Where("projects.organization_id = ?", organization.ID).
+		Find(&records).Error; err != nil {
+		http.Error(w, "Failed to fetch billing records", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(records)
+}
+
+// GetDeploymentUsage API to list the usage events of a deployment with the
+// charges rated from them between since and until (RFC 3339), from its start
+// until now by default
+func GetDeploymentUsage(w http.ResponseWriter, r *http.Request) {
+	var deployment models.Deployment
+	if err := database.DB.Unscoped().First(&deployment, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+		return
+	}
+	if !orgs.CanViewDeployment(r, deployment) {
+		middleware.Forbidden(w)
+		return
+	}
+
+	events, err := Events(database.DB, deployment.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch usage events", http.StatusInternalServerError)
+		return
+	}
+
+	since, until := time.Time{}, time.Now()
+	if len(events) > 0 {
+		since = events[0].OccurredAt
+	}
+	for param, t := range map[string]*time.Time{"since": &since, "until": &until} {
+		if value := r.URL.Query().Get(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, "Invalid "+param+", expected an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deployment_id": deployment.ID,
+		"since":         since,
+		"until":         until,
+		"amount":        Rate(events, since, until),
+		"events":        events,
+	})
+}
@@ -1,49 +1,71 @@
 package billing
 
 import (
+	"context"
 	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/audit"
 	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
 	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
 	"log"
 	"time"
 )
 
-func StartBillingUpdater() {
+// BillingActor is recorded in the audit log for the updates of the billing job
+const BillingActor = "billing"
+
+// StartBillingUpdater rates the usage of running deployments into their billing records every five minutes until ctx is cancelled
+func StartBillingUpdater(ctx context.Context) {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
 
 	for {
-		<-ticker.C
-		updateBillingRecords()
+		select {
+		case <-ticker.C:
+			updateBillingRecords()
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
+// FlushBillingRecords brings the amount of running billing records up to date, e.g. before shutting down
+func FlushBillingRecords() {
+	updateBillingRecords()
+}
+
 func updateBillingRecords() {
 	log.Println("🔄 Updating billing records...")
 
-	// Fetch active billing records (where EndTime is NULL)
+	// Fetch active billing records (where EndTime is NULL) that have usage to rate
 	var records []models.BillingRecord
-	if err := database.DB.Where("end_time IS NULL").Find(&records).Error; err != nil {
+	if err := database.DB.Where("end_time IS NULL").
+		Where("EXISTS (SELECT 1 FROM usage_events e WHERE CAST(e.deployment_id AS TEXT) = billing_records.deployment_id)").
+		Find(&records).Error; err != nil {
 		log.Println("❌ Failed to fetch billing records:", err)
+		audit.Background(BillingActor, "update", "billing_record", nil, err)
 		return
 	}
 
-	for _, record := range records {
-		elapsedDuration := time.Since(record.StartTime)
-		elapsedHours := elapsedDuration.Hours()
-		elapsedMinutes := elapsedDuration.Minutes()
-
-		newAmount := (elapsedMinutes / 60) * record.HourlyRate // Calculate cost based on total minutes
+	failed := 0
+	defer func() {
+		var err error
+		if failed > 0 {
+			err = fmt.Errorf("failed to update %d of %d billing records", failed, len(records))
+		}
+		audit.Background(BillingActor, "update", "billing_record", nil, err)
+	}()
 
-		// Update Billing Record
-		if err := database.DB.Model(&record).Updates(models.BillingRecord{
-			Amount:    newAmount,
-			UpdatedAt: time.Now(),
-		}).Error; err != nil {
+	for _, record := range records {
+		// Rate the usage events of the deployment until now
+		updated, err := Refresh(database.DB, record.DeploymentID)
+		if err != nil {
 			log.Println("❌ Failed to update billing:", err)
-		} else {
-			fmt.Printf("💰 Billing updated: %s → $%.2f (%.0f hours, %.0f mins)\n",
-				record.DeploymentID, newAmount, elapsedHours, elapsedMinutes)
+			failed++
+			continue
 		}
+
+		elapsedDuration := time.Since(updated.StartTime)
+		fmt.Printf("💰 Billing updated: %s → $%.2f (%.0f hours, %.0f mins)\n",
+			updated.DeploymentID, updated.Amount, elapsedDuration.Hours(), elapsedDuration.Minutes())
 	}
 }
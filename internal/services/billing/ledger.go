@@ -0,0 +1,316 @@
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Usage event kinds
+const (
+	EventStarted      = "started"       // Billing starts on the terms of the event
+	EventUpgraded     = "upgraded"      // The deployment runs another version, on the same terms
+	EventPriceChanged = "price_changed" // Billing continues on the terms of the event
+	EventPaused       = "paused"        // Billing is suspended, e.g. during an outage
+	EventResumed      = "resumed"       // Billing continues after a pause
+	EventStopped      = "stopped"       // Billing ends for good, e.g. on uninstall
+)
+
+// EventKinds lists the usage event kinds
+var EventKinds = []string{EventStarted, EventUpgraded, EventPriceChanged, EventPaused, EventResumed, EventStopped}
+
+var ErrInvalidEvent = errors.New("invalid usage event")
+
+// Events returns the usage events of a deployment in the order they took effect
+func Events(db *gorm.DB, deploymentID uint) ([]models.UsageEvent, error) {
+	var events []models.UsageEvent
+	err := db.Where("deployment_id = ?", deploymentID).Order("occurred_at, id").Find(&events).Error
+	return events, err
+}
+
+// Start records that a deployment started running, billed on its pricing plan or
+// the hourly rate of its application once its free trial ends. Deployments that
+// already started are left alone, e.g. when their install job is delivered
+// again. db may be a transaction.
+func Start(db *gorm.DB, deploymentID, actor string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		deployment, events, err := lockEvents(tx, deploymentID)
+		if err != nil || len(events) > 0 {
+			return err
+		}
+
+		event := models.UsageEvent{
+			DeploymentID:         deployment.ID,
+			Kind:                 EventStarted,
+			OccurredAt:           time.Now(),
+			TrialEndsAt:          deployment.TrialEndsAt,
+			ApplicationVersionID: deployment.ApplicationVersionID,
+			Actor:                actor,
+		}
+		if err := setTerms(tx, deployment, &event); err != nil {
+			return err
+		}
+		return appendEvent(tx, deployment, events, &event)
+	})
+}
+
+// Upgrade records that a deployment runs another version from now on, billed on
+// planID, one of the plans of the version (nil for the hourly rate of the
+// application). Billing moves to the plan if it is not the plan of the
+// deployment yet. A deployment whose install failed starts being billed once an
+// upgrade installed it. db may be a transaction.
+func Upgrade(db *gorm.DB, deploymentID string, versionID uint, planID *uint, actor, message string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		deployment, events, err := lockEvents(tx, deploymentID)
+		if err != nil {
+			return err
+		}
+
+		changed := !samePlan(deployment.PricingPlanID, planID)
+		if changed {
+			if err := tx.Model(&deployment).Update("pricing_plan_id", planID).Error; err != nil {
+				return err
+			}
+			deployment.PricingPlanID = planID
+		}
+		if len(events) == 0 {
+			return Start(tx, deploymentID, actor)
+		}
+
+		now := time.Now()
+		upgraded := models.UsageEvent{
+			DeploymentID:         deployment.ID,
+			Kind:                 EventUpgraded,
+			OccurredAt:           now,
+			ApplicationVersionID: &versionID,
+			Actor:                actor,
+			Message:              message,
+		}
+		if err := appendEvent(tx, deployment, events, &upgraded); err != nil || !changed {
+			return err
+		}
+
+		priceChanged := models.UsageEvent{
+			DeploymentID: deployment.ID,
+			Kind:         EventPriceChanged,
+			OccurredAt:   now,
+			Actor:        actor,
+			Message:      message,
+		}
+		if err := setTerms(tx, deployment, &priceChanged); err != nil {
+			return err
+		}
+		return appendEvent(tx, deployment, append(events, upgraded), &priceChanged)
+	})
+}
+
+// Stop records that a deployment stopped running at at, or at its latest usage
+// event if later, closing its billing record with the final amount. Deployments
+// that never started have nothing to stop. db may be a transaction.
+func Stop(db *gorm.DB, deploymentID string, at time.Time, actor, message string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		deployment, events, err := lockEvents(tx, deploymentID)
+		if err != nil || len(events) == 0 {
+			return err
+		}
+		if latest := events[len(events)-1].OccurredAt; at.Before(latest) {
+			at = latest
+		}
+		return appendEvent(tx, deployment, events, &models.UsageEvent{
+			DeploymentID: deployment.ID,
+			Kind:         EventStopped,
+			OccurredAt:   at,
+			Actor:        actor,
+			Message:      message,
+		})
+	})
+}
+
+// Append adds an event to the usage of a deployment, e.g. a correction dated in
+// the past, and refreshes its billing record. db may be a transaction.
+func Append(db *gorm.DB, event models.UsageEvent) (models.UsageEvent, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		deployment, events, err := lockEvents(tx, strconv.FormatUint(uint64(event.DeploymentID), 10))
+		if err != nil {
+			return err
+		}
+		return appendEvent(tx, deployment, events, &event)
+	})
+	return event, err
+}
+
+// Refresh derives the billing record of a deployment from its usage events,
+// rated until now while it runs. db may be a transaction.
+func Refresh(db *gorm.DB, deploymentID string) (models.BillingRecord, error) {
+	var record models.BillingRecord
+	err := db.Transaction(func(tx *gorm.DB) error {
+		deployment, events, err := lockEvents(tx, deploymentID)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		record, err = saveRecord(tx, deployment, events)
+		return err
+	})
+	return record, err
+}
+
+// lockEvents locks a deployment, so its events are appended and rated one at a
+// time, and loads its usage events. Uninstalled deployments are included.
+func lockEvents(tx *gorm.DB, deploymentID string) (models.Deployment, []models.UsageEvent, error) {
+	var deployment models.Deployment
+	if err := tx.Unscoped().Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+		Select("id", "consumer_id", "application_id", "application_version_id", "pricing_plan_id", "trial_ends_at").
+		First(&deployment, deploymentID).Error; err != nil {
+		return deployment, nil, err
+	}
+	events, err := Events(tx, deployment.ID)
+	return deployment, events, err
+}
+
+// appendEvent checks an event fits the history of a deployment, inserts it and
+// refreshes the billing record of the deployment
+func appendEvent(tx *gorm.DB, deployment models.Deployment, events []models.UsageEvent, event *models.UsageEvent) error {
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+
+	// Events dated at the same time as existing ones take effect after them
+	history := append(append([]models.UsageEvent{}, events...), *event)
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].OccurredAt.Before(history[j].OccurredAt)
+	})
+	if err := validate(history); err != nil {
+		return err
+	}
+
+	if err := tx.Create(event).Error; err != nil {
+		return err
+	}
+	log.Printf("🧾 Deployment %d: usage %s at %s (%s)", deployment.ID, event.Kind, event.OccurredAt.Format(time.RFC3339), event.Actor)
+
+	for i := range history {
+		if history[i].ID == 0 {
+			history[i] = *event
+		}
+	}
+	_, err := saveRecord(tx, deployment, history)
+	return err
+}
+
+// validate checks a history of usage events is consistent: it begins with the
+// only "started" event, pauses and resumes alternate and nothing follows "stopped"
+func validate(events []models.UsageEvent) error {
+	paused := false
+	for i, event := range events {
+		var problem string
+		switch {
+		case i == 0 && event.Kind != EventStarted:
+			problem = "usage has to start first"
+		case i > 0 && event.Kind == EventStarted:
+			problem = "usage already started"
+		case i > 0 && events[i-1].Kind == EventStopped:
+			problem = "usage already stopped"
+		case event.Kind == EventPaused && paused:
+			problem = "usage is already paused"
+		case event.Kind == EventResumed && !paused:
+			problem = "usage is not paused"
+		case !isEventKind(event.Kind):
+			problem = fmt.Sprintf("unknown kind %q", event.Kind)
+		}
+		if problem != "" {
+			return fmt.Errorf("%w: %s at %s: %s", ErrInvalidEvent, event.Kind, event.OccurredAt.Format(time.RFC3339), problem)
+		}
+
+		switch event.Kind {
+		case EventPaused:
+			paused = true
+		case EventResumed:
+			paused = false
+		}
+	}
+	return nil
+}
+
+// saveRecord derives the billing record of a deployment from its usage events
+// and stores it
+func saveRecord(tx *gorm.DB, deployment models.Deployment, events []models.UsageEvent) (models.BillingRecord, error) {
+	id := strconv.FormatUint(uint64(deployment.ID), 10)
+	record := models.BillingRecord{
+		ID:            id + "-bill",
+		ConsumerID:    strconv.FormatUint(uint64(deployment.ConsumerID), 10),
+		DeploymentID:  id,
+		ApplicationID: deployment.ApplicationID,
+		UpdatedAt:     time.Now(),
+	}
+	for _, event := range events {
+		switch event.Kind {
+		case EventStarted:
+			record.StartTime, record.CreatedAt = event.OccurredAt, event.CreatedAt
+			record.TrialEndsAt = event.TrialEndsAt
+			fallthrough
+		case EventPriceChanged:
+			record.HourlyRate, record.PricingPlanID = event.HourlyRate, nil
+			if event.Plan != nil {
+				record.PricingPlanID = &event.Plan.ID
+			}
+		case EventStopped:
+			end := event.OccurredAt
+			record.EndTime = &end
+		}
+	}
+
+	end := time.Now()
+	if record.EndTime != nil {
+		end = *record.EndTime
+	}
+	record.Amount = Rate(events, record.StartTime, end)
+
+	return record, tx.Omit(clause.Associations).Save(&record).Error
+}
+
+// setTerms sets the pricing terms of the deployment on event: its pricing plan,
+// or the hourly rate of its application if it has none
+func setTerms(tx *gorm.DB, deployment models.Deployment, event *models.UsageEvent) error {
+	if deployment.PricingPlanID != nil {
+		var plan models.PricingPlan
+		if err := tx.First(&plan, *deployment.PricingPlanID).Error; err != nil {
+			return fmt.Errorf("failed to find pricing plan: %w", err)
+		}
+		event.Plan = &plan
+		event.HourlyRate = plan.HourlyRate
+		return nil
+	}
+
+	var app models.Application
+	if err := tx.Unscoped().Select("id", "hourly_rate").First(&app, deployment.ApplicationID).Error; err != nil {
+		return err
+	}
+	event.HourlyRate = app.HourlyRate
+	return nil
+}
+
+// samePlan reports whether two optional plan IDs are the same
+func samePlan(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func isEventKind(kind string) bool {
+	for _, k := range EventKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package billing
+
+import (
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"math"
+	"strings"
+	"time"
+)
+
+// Pricing plan types
+const (
+	PlanFree    = "free"    // Nothing is charged
+	PlanMonthly = "monthly" // Flat subscription, charged when each month of use starts
+	PlanHourly  = "hourly"  // Per hour, with an optional minimum charge
+	PlanTiered  = "tiered"  // Hourly rates by the hours used, e.g. cheaper past the first 100 hours
+)
+
+// PlanTypes lists the valid pricing plan types
+var PlanTypes = []string{PlanFree, PlanMonthly, PlanHourly, PlanTiered}
+
+// Amount returns what a deployment billed on plan owes for running from start
+// to end, setup fee included. Deployments without a plan are billed hourlyRate
+// per hour, as before plans existed.
+func Amount(plan *models.PricingPlan, hourlyRate float64, start, end time.Time) float64 {
+	if !end.After(start) {
+		return 0
+	}
+	hours := end.Sub(start).Hours()
+	if plan == nil {
+		return hours * hourlyRate
+	}
+
+	switch plan.Type {
+	case PlanMonthly:
+		return plan.SetupFee + float64(startedMonths(start, end))*plan.MonthlyPrice
+	case PlanHourly:
+		return plan.SetupFee + math.Max(hours*plan.HourlyRate, plan.MinimumCharge)
+	case PlanTiered:
+		return plan.SetupFee + tieredAmount(plan.Tiers, hours)
+	}
+	return 0
+}
+
+// ValidatePlan checks the prices of a plan fit its type
+func ValidatePlan(plan models.PricingPlan) error {
+	for name, price := range map[string]float64{
+		"setup_fee": plan.SetupFee, "monthly_price": plan.MonthlyPrice, "hourly_rate": plan.HourlyRate, "minimum_charge": plan.MinimumCharge,
+	} {
+		if price < 0 || math.IsNaN(price) || math.IsInf(price, 0) {
+			return fmt.Errorf("%s must be a non-negative amount", name)
+		}
+	}
+
+	switch plan.Type {
+	case PlanFree:
+		if plan.SetupFee != 0 || plan.MonthlyPrice != 0 || plan.HourlyRate != 0 || plan.MinimumCharge != 0 || len(plan.Tiers) > 0 {
+			return fmt.Errorf("free plans have no prices")
+		}
+	case PlanMonthly:
+		if plan.MonthlyPrice == 0 {
+			return fmt.Errorf("monthly plans need a monthly_price")
+		}
+		if plan.HourlyRate != 0 || plan.MinimumCharge != 0 || len(plan.Tiers) > 0 {
+			return fmt.Errorf("monthly plans only have a monthly_price and a setup_fee")
+		}
+	case PlanHourly:
+		if plan.MonthlyPrice != 0 || len(plan.Tiers) > 0 {
+			return fmt.Errorf("hourly plans only have an hourly_rate, a minimum_charge and a setup_fee")
+		}
+	case PlanTiered:
+		if plan.MonthlyPrice != 0 || plan.HourlyRate != 0 || plan.MinimumCharge != 0 {
+			return fmt.Errorf("tiered plans only have tiers and a setup_fee")
+		}
+		return validateTiers(plan.Tiers)
+	default:
+		return fmt.Errorf("invalid plan type, valid types are: %s", strings.Join(PlanTypes, ", "))
+	}
+	return nil
+}
+
+// validateTiers checks tiers have increasing bounds and the last one is unbounded
+func validateTiers(tiers []models.PriceTier) error {
+	if len(tiers) == 0 {
+		return fmt.Errorf("tiered plans need tiers")
+	}
+	previous := 0.0
+	for i, tier := range tiers {
+		if tier.HourlyRate < 0 {
+			return fmt.Errorf("tier %d: hourly_rate must be a non-negative amount", i+1)
+		}
+		last := i == len(tiers)-1
+		switch {
+		case last && tier.UpToHours != nil:
+			return fmt.Errorf("the last tier must have no up_to_hours, it covers all further hours")
+		case !last && tier.UpToHours == nil:
+			return fmt.Errorf("tier %d: only the last tier can have no up_to_hours", i+1)
+		case !last && *tier.UpToHours <= previous:
+			return fmt.Errorf("tier %d: up_to_hours must be greater than the one of the previous tier", i+1)
+		}
+		if !last {
+			previous = *tier.UpToHours
+		}
+	}
+	return nil
+}
+
+// tieredAmount charges each hour at the rate of the tier it falls in
+func tieredAmount(tiers []models.PriceTier, hours float64) float64 {
+	amount, from := 0.0, 0.0
+	for _, tier := range tiers {
+		to := math.Inf(1)
+		if tier.UpToHours != nil {
+			to = *tier.UpToHours
+		}
+		if hours <= from {
+			break
+		}
+		amount += (math.Min(hours, to) - from) * tier.HourlyRate
+		from = to
+	}
+	return amount
+}
+
+// startedMonths counts the calendar months of use started from start to end,
+// at least the first one
+func startedMonths(start, end time.Time) int {
+	months := 1
+	for start.AddDate(0, months, 0).Before(end) {
+		months++
+	}
+	return months
+}
@@ -0,0 +1,111 @@
+package billing
+
+import (
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"math"
+	"testing"
+	"time"
+)
+
+// hours returns a pointer to a tier bound
+func hours(h float64) *float64 {
+	return &h
+}
+
+// approx reports whether two amounts are equal up to float rounding
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestTieredAmount(t *testing.T) {
+	tiers := []models.PriceTier{
+		{UpToHours: hours(100), HourlyRate: 1},
+		{UpToHours: hours(200), HourlyRate: 0.5},
+		{HourlyRate: 0.25},
+	}
+
+	for used, want := range map[float64]float64{
+		0:     0,
+		10:    10,
+		99.5:  99.5,
+		100:   100,    // Last hour of the first tier
+		100.5: 100.25, // First half hour of the second tier
+		200:   150,
+		240:   160, // 100 + 50 + 40 * 0.25
+	} {
+		if got := tieredAmount(tiers, used); !approx(got, want) {
+			t.Errorf("tieredAmount(%v hours) = %v, want %v", used, got, want)
+		}
+	}
+
+	if got := tieredAmount([]models.PriceTier{{HourlyRate: 2}}, 30); got != 60 {
+		t.Errorf("tieredAmount with a single tier = %v, want 60", got)
+	}
+}
+
+func TestStartedMonths(t *testing.T) {
+	jan15 := time.Date(2025, time.January, 15, 12, 0, 0, 0, time.UTC)
+	jan31 := time.Date(2025, time.January, 31, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       int
+	}{
+		{"first minute", jan15, jan15.Add(time.Minute), 1},
+		{"until the same day of the next month", jan15, jan15.AddDate(0, 1, 0), 1},
+		{"into the second month", jan15, jan15.AddDate(0, 1, 0).Add(time.Second), 2},
+		{"across the new year", time.Date(2024, time.December, 20, 0, 0, 0, 0, time.UTC), time.Date(2025, time.January, 25, 0, 0, 0, 0, time.UTC), 2},
+		{"a year", jan15, jan15.AddDate(1, 0, 0), 12},
+		// February has no 31st, so the month started on January 31 ends on March 3
+		{"end of month until the end of February", jan31, time.Date(2025, time.February, 28, 23, 0, 0, 0, time.UTC), 1},
+		{"end of month rolled over", jan31, time.Date(2025, time.March, 3, 0, 0, 0, 0, time.UTC), 1},
+		{"end of month into the second month", jan31, time.Date(2025, time.March, 3, 0, 0, 1, 0, time.UTC), 2},
+	}
+	for _, tt := range tests {
+		if got := startedMonths(tt.start, tt.end); got != tt.want {
+			t.Errorf("%s: startedMonths(%s, %s) = %d, want %d", tt.name, tt.start.Format(time.RFC3339), tt.end.Format(time.RFC3339), got, tt.want)
+		}
+	}
+}
+
+func TestAmount(t *testing.T) {
+	start := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	free := &models.PricingPlan{Type: PlanFree}
+	hourly := &models.PricingPlan{Type: PlanHourly, HourlyRate: 2, MinimumCharge: 10, SetupFee: 5}
+	monthly := &models.PricingPlan{Type: PlanMonthly, MonthlyPrice: 100, SetupFee: 20}
+	tiered := &models.PricingPlan{Type: PlanTiered, SetupFee: 1, Tiers: []models.PriceTier{
+		{UpToHours: hours(10), HourlyRate: 3},
+		{HourlyRate: 1},
+	}}
+
+	tests := []struct {
+		name       string
+		plan       *models.PricingPlan
+		hourlyRate float64
+		duration   time.Duration
+		want       float64
+	}{
+		{"no plan, hourly rate of the application", nil, 1.5, 4 * time.Hour, 6},
+		{"no plan, part of an hour", nil, 2, 90 * time.Minute, 3},
+		{"nothing used", hourly, 0, 0, 0},
+		{"free plan", free, 9, 100 * time.Hour, 0},
+		{"hourly plan below its minimum charge", hourly, 0, 2 * time.Hour, 5 + 10},
+		{"hourly plan past its minimum charge", hourly, 0, 8 * time.Hour, 5 + 16},
+		{"hourly plan ignores the rate of the application", hourly, 100, 8 * time.Hour, 5 + 16},
+		{"monthly plan, first month", monthly, 0, time.Hour, 20 + 100},
+		{"monthly plan, second month started", monthly, 0, 31*24*time.Hour + time.Hour, 20 + 200},
+		{"tiered plan within the first tier", tiered, 0, 4 * time.Hour, 1 + 12},
+		{"tiered plan on a tier boundary", tiered, 0, 10 * time.Hour, 1 + 30},
+		{"tiered plan past the first tier", tiered, 0, 15 * time.Hour, 1 + 30 + 5},
+	}
+	for _, tt := range tests {
+		if got := Amount(tt.plan, tt.hourlyRate, start, start.Add(tt.duration)); !approx(got, tt.want) {
+			t.Errorf("%s: Amount = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if got := Amount(hourly, 0, start, start.Add(-time.Hour)); got != 0 {
+		t.Errorf("Amount ending before it starts = %v, want 0", got)
+	}
+}
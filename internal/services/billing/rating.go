@@ -0,0 +1,92 @@
+package billing
+
+import (
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"time"
+)
+
+// Rate returns what a deployment owes for its usage between from and to, rated
+// from its usage events in the order they took effect (see Events)
+func Rate(events []models.UsageEvent, from, to time.Time) float64 {
+	if !to.After(from) {
+		return 0
+	}
+	return owed(events, to) - owed(events, from)
+}
+
+// period is the usage of a deployment on the same pricing terms
+type period struct {
+	plan       *models.PricingPlan
+	hourlyRate float64
+	setupFee   bool          // Only charged on the terms the deployment started on
+	since      time.Time     // First billed instant
+	billed     time.Duration // Running time billed, pauses and the free trial excluded
+}
+
+// amount rates the running time billed in the period as if it was used in one go
+func (p period) amount() float64 {
+	if p.billed <= 0 {
+		return 0
+	}
+	amount := Amount(p.plan, p.hourlyRate, p.since, p.since.Add(p.billed))
+	if !p.setupFee && p.plan != nil {
+		amount -= p.plan.SetupFee
+	}
+	return amount
+}
+
+// owed returns the charges of a deployment for its usage until at. Each period of
+// the same terms is rated on its own: minimum charges, tiers and monthly prices
+// apply to the running time billed on the terms. Nothing is charged during the
+// free trial, the setup fee included.
+func owed(events []models.UsageEvent, at time.Time) float64 {
+	var (
+		total       float64
+		current     period
+		running     bool
+		trialEndsAt *time.Time
+		accrued     time.Time // Running time was billed until then
+	)
+	accrue := func(until time.Time) {
+		if !running {
+			return
+		}
+		from := accrued
+		if trialEndsAt != nil && trialEndsAt.After(from) {
+			from = *trialEndsAt
+		}
+		if until.After(from) {
+			if current.billed == 0 {
+				current.since = from
+			}
+			current.billed += until.Sub(from)
+		}
+		accrued = until
+	}
+
+	for _, event := range events {
+		if event.OccurredAt.After(at) {
+			break
+		}
+		accrue(event.OccurredAt)
+
+		switch event.Kind {
+		case EventStarted:
+			current = period{plan: event.Plan, hourlyRate: event.HourlyRate, setupFee: true}
+			trialEndsAt = event.TrialEndsAt
+			running, accrued = true, event.OccurredAt
+		case EventPriceChanged:
+			// The setup fee moves to the new terms if nothing was billed yet, e.g. during the free trial
+			setupFee := current.setupFee && current.billed == 0
+			total += current.amount()
+			current = period{plan: event.Plan, hourlyRate: event.HourlyRate, setupFee: setupFee}
+		case EventPaused, EventStopped:
+			running = false
+		case EventResumed:
+			running, accrued = true, event.OccurredAt
+		}
+	}
+	accrue(at)
+
+	return total + current.amount()
+}
@@ -0,0 +1,133 @@
+package billing
+
+import (
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"testing"
+	"time"
+)
+
+// t0 is when the deployments of the tests start
+var t0 = time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+// at returns the instant h hours after t0
+func at(h float64) time.Time {
+	return t0.Add(time.Duration(h * float64(time.Hour)))
+}
+
+func started(plan *models.PricingPlan, hourlyRate float64, trialHours float64) models.UsageEvent {
+	event := models.UsageEvent{Kind: EventStarted, OccurredAt: t0, Plan: plan, HourlyRate: hourlyRate}
+	if trialHours > 0 {
+		endsAt := at(trialHours)
+		event.TrialEndsAt = &endsAt
+	}
+	return event
+}
+
+func event(kind string, h float64) models.UsageEvent {
+	return models.UsageEvent{Kind: kind, OccurredAt: at(h)}
+}
+
+func priceChanged(h float64, plan *models.PricingPlan, hourlyRate float64) models.UsageEvent {
+	return models.UsageEvent{Kind: EventPriceChanged, OccurredAt: at(h), Plan: plan, HourlyRate: hourlyRate}
+}
+
+func TestOwed(t *testing.T) {
+	hourly := &models.PricingPlan{ID: 1, Type: PlanHourly, HourlyRate: 2, SetupFee: 5}
+	minimum := &models.PricingPlan{ID: 2, Type: PlanHourly, HourlyRate: 1, MinimumCharge: 10}
+	monthly := &models.PricingPlan{ID: 3, Type: PlanMonthly, MonthlyPrice: 100, SetupFee: 20}
+	tiered := &models.PricingPlan{ID: 4, Type: PlanTiered, Tiers: []models.PriceTier{
+		{UpToHours: hours(10), HourlyRate: 3},
+		{HourlyRate: 1},
+	}}
+
+	tests := []struct {
+		name   string
+		events []models.UsageEvent
+		at     float64 // Hours after t0
+		want   float64
+	}{
+		{"before the start", []models.UsageEvent{started(nil, 1, 0)}, -1, 0},
+		{"hourly rate of the application", []models.UsageEvent{started(nil, 1.5, 0)}, 4, 6},
+		{"setup fee on the first hour", []models.UsageEvent{started(hourly, 0, 0)}, 1, 5 + 2},
+
+		{"during the free trial", []models.UsageEvent{started(hourly, 0, 24)}, 23, 0},
+		{"trial ends, setup fee charged then", []models.UsageEvent{started(hourly, 0, 24)}, 24.5, 5 + 1},
+		{"stopped during the free trial", []models.UsageEvent{started(hourly, 0, 24), event(EventStopped, 10)}, 48, 0},
+
+		{"paused", []models.UsageEvent{started(nil, 1, 0), event(EventPaused, 2)}, 10, 2},
+		{"paused and resumed", []models.UsageEvent{started(nil, 1, 0), event(EventPaused, 2), event(EventResumed, 5)}, 8, 5},
+		{"paused through the end of the trial", []models.UsageEvent{
+			started(nil, 1, 4), event(EventPaused, 2), event(EventResumed, 6),
+		}, 8, 2},
+
+		{"stopped", []models.UsageEvent{started(nil, 1, 0), event(EventStopped, 3)}, 100, 3},
+
+		{"minimum charge", []models.UsageEvent{started(minimum, 0, 0)}, 3, 10},
+		{"minimum charge per period of terms", []models.UsageEvent{
+			started(minimum, 0, 0), priceChanged(3, nil, 1),
+		}, 5, 10 + 2},
+
+		{"tiers across a pause", []models.UsageEvent{
+			started(tiered, 0, 0), event(EventPaused, 8), event(EventResumed, 20),
+		}, 24, 30 + 2}, // 12 hours billed: 10 of the first tier, 2 of the second
+		{"tiers restart on new terms", []models.UsageEvent{
+			started(tiered, 0, 0), priceChanged(8, tiered, 0),
+		}, 12, 24 + 12},
+
+		{"monthly plan", []models.UsageEvent{started(monthly, 0, 0)}, 1, 20 + 100},
+		{"monthly plan rolls over into the next month", []models.UsageEvent{started(monthly, 0, 0)}, 31*24 + 1, 20 + 200},
+
+		{"price change keeps the setup fee once", []models.UsageEvent{
+			started(hourly, 0, 0), priceChanged(2, hourly, 0),
+		}, 4, 5 + 4 + 4},
+		{"price change during the trial moves the setup fee", []models.UsageEvent{
+			started(hourly, 0, 10), priceChanged(5, monthly, 0),
+		}, 11, 20 + 100},
+		{"price change to the hourly rate", []models.UsageEvent{
+			started(hourly, 0, 0), priceChanged(2, nil, 0.5),
+		}, 6, 5 + 4 + 2},
+		{"upgrade keeps the terms", []models.UsageEvent{
+			started(nil, 1, 0), event(EventUpgraded, 2),
+		}, 6, 6},
+	}
+	for _, tt := range tests {
+		if got := owed(tt.events, at(tt.at)); !approx(got, tt.want) {
+			t.Errorf("%s: owed at %vh = %v, want %v", tt.name, tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestRateIsOwedBetween(t *testing.T) {
+	events := []models.UsageEvent{
+		started(&models.PricingPlan{Type: PlanHourly, HourlyRate: 2, SetupFee: 5, MinimumCharge: 6}, 0, 1),
+		event(EventPaused, 3),
+		event(EventResumed, 4),
+		priceChanged(6, nil, 1),
+		event(EventStopped, 9),
+	}
+
+	checkpoints := []float64{-1, 0, 0.5, 1, 2, 3, 3.5, 4, 6, 7, 9, 12}
+	for i, from := range checkpoints {
+		for _, to := range checkpoints[i:] {
+			got := Rate(events, at(from), at(to))
+			if want := owed(events, at(to)) - owed(events, at(from)); to > from && !approx(got, want) {
+				t.Errorf("Rate(%vh, %vh) = %v, want owed(to) - owed(from) = %v", from, to, got, want)
+			}
+			if got < 0 {
+				t.Errorf("Rate(%vh, %vh) = %v, want no credit", from, to, got)
+			}
+			if back := Rate(events, at(to), at(from)); back != 0 {
+				t.Errorf("Rate(%vh, %vh) backwards = %v, want 0", to, from, back)
+			}
+		}
+	}
+
+	// Rates of consecutive windows add up to the whole usage
+	if sum, whole := Rate(events, at(0), at(5))+Rate(events, at(5), at(12)), Rate(events, at(0), at(12)); !approx(sum, whole) {
+		t.Errorf("rates of consecutive windows = %v, want %v", sum, whole)
+	}
+	// 1h trial, 2h then 2h at 2/h past the minimum charge with the setup fee, 3h at 1/h
+	if got := Rate(events, at(0), at(12)); !approx(got, 5+8+3) {
+		t.Errorf("Rate of the whole usage = %v, want %v", got, 5+8+3)
+	}
+}
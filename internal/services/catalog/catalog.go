@@ -3,13 +3,22 @@ package catalog
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/audit"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/secrets"
 	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/jsonschema"
 	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
 	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
+// maxTrialDays bounds the free trials publishers can offer
+const maxTrialDays = 365
+
 type deploymentSpec struct {
 	Type      string `json:"type"`
 	RepoURL   string `json:"repoURL"`
@@ -24,10 +33,19 @@ func AddApplication(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Name        string                 `json:"name"`
 		Description string                 `json:"description"`
-		PublisherID uint                   `json:"publisher_id"`
 		HourlyRate  float64                `json:"hourly_rate"`
 		Deployment  deploymentSpec         `json:",inline"`
-		Inputs      map[string]interface{} `json:"inputs"`
+		Inputs      map[string]interface{} `json:"inputs"`   // JSON Schema of the chart values consumers can set
+		Category    string                 `json:"category"` // One of Categories, "other" by default
+		Tags        []string               `json:"tags"`
+		TrialDays   int                    `json:"trial_days"` // Length of the free trial, none if 0
+
+		// First version of the application, "1.0.0" by default
+		Version      string        `json:"version"`
+		ChartVersion string        `json:"chart_version"`
+		ReleaseNotes string        `json:"release_notes"`
+		Status       string        `json:"status"` // "draft" (default) or "in_review" to submit it right away
+		Plans        []planRequest `json:"plans"`  // Pricing plans, billed at hourly_rate if none
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -35,16 +53,54 @@ func AddApplication(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Status == "" {
+		req.Status = VersionDraft
+	}
+	if req.Status != VersionDraft && req.Status != VersionInReview {
+		http.Error(w, "New applications must be draft or in_review, they are published once approved", http.StatusBadRequest)
+		return
+	}
+
 	// Validate Deployment Type
 	if req.Deployment.Type != "k8s" && req.Deployment.Type != "vm" {
 		http.Error(w, "Invalid deployment type", http.StatusBadRequest)
 		return
 	}
 
-	// Check if the publisher exists
-	var publisher models.User
-	if err := database.DB.First(&publisher, req.PublisherID).Error; err != nil {
-		http.Error(w, "Publisher not found", http.StatusBadRequest)
+	// Validate the inputs schema
+	if _, err := jsonschema.Parse(req.Inputs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Category == "" {
+		req.Category = DefaultCategory
+	}
+	if !IsValidCategory(req.Category) {
+		http.Error(w, fmt.Sprintf("Invalid category. Valid categories are: %s", strings.Join(Categories, ", ")), http.StatusBadRequest)
+		return
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !isValidTrialDays(req.TrialDays) {
+		http.Error(w, fmt.Sprintf("trial_days must be between 0 and %d", maxTrialDays), http.StatusBadRequest)
+		return
+	}
+
+	plans, err := toPlans(req.Plans)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Version == "" {
+		req.Version = "1.0.0"
+	}
+	if _, err := parseSemver(req.Version); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -52,29 +108,70 @@ func AddApplication(w http.ResponseWriter, r *http.Request) {
 	app := models.Application{
 		Name:        req.Name,
 		Description: req.Description,
-		PublisherID: req.PublisherID,
+		PublisherID: middleware.UserID(r), // The caller publishes the application
 		HourlyRate:  req.HourlyRate,
 		Deployment:  models.DeploymentSpec(req.Deployment),
 		Inputs:      req.Inputs, // Set the dynamic inputs
+		Category:    req.Category,
+		Tags:        tags,
+		TrialDays:   req.TrialDays,
 	}
 
-	if err := database.DB.Create(&app).Error; err != nil {
+	// Together with its first version, consumers see the application once an admin
+	// approved it. A version failing chart verification is kept as draft.
+	version := models.ApplicationVersion{
+		Version:      req.Version,
+		ChartVersion: req.ChartVersion,
+		Deployment:   app.Deployment,
+		InputsSchema: app.Inputs,
+		ReleaseNotes: req.ReleaseNotes,
+		Status:       VersionDraft,
+		Plans:        plans,
+	}
+	verified := req.Status != VersionInReview || verifyChart(r.Context(), &version)
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&app).Error; err != nil {
+			return err
+		}
+		version.ApplicationID = app.ID
+		if err := tx.Create(&version).Error; err != nil {
+			return err
+		}
+		if req.Status == VersionInReview && verified {
+			return setVersionStatus(tx, &version, VersionInReview, app.PublisherID, "")
+		}
+		return nil
+	})
+	if err != nil {
 		http.Error(w, "Failed to add application", http.StatusInternalServerError)
 		return
 	}
+	redactApplication(&app)
+	audit.Resource(r, "application", app.ID)
+	audit.Changes(r, nil, app)
 
 	w.WriteHeader(http.StatusCreated)
+	if !verified {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":      fmt.Sprintf("Application: %s created, version %s stays draft as its chart failed verification", app.Name, version.Version),
+			"verification": version.Verification,
+		})
+		return
+	}
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message": fmt.Sprintf("Application: %s created successfully", app.Name),
 	})
 }
 
+// ListApplications API to search the catalog. Filters: q (full-text search over
+// name and description), publisher, deploymentType, category (comma-separated,
+// any of them), tag (repeated or comma-separated, all of them), min_price and
+// max_price; sort: name, price, price_desc, newest, popularity or relevance.
+// Consumers only find applications with an approved version.
 func ListApplications(w http.ResponseWriter, r *http.Request) {
 	// Get query parameters
 	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
 	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
-	publisherName := r.URL.Query().Get("publisher")       // Filter by publisher name
-	deploymentType := r.URL.Query().Get("deploymentType") // Filter by deployment type (k8s/vm)
 
 	if page < 1 {
 		page = 1
@@ -85,28 +182,45 @@ func ListApplications(w http.ResponseWriter, r *http.Request) {
 
 	offset := (page - 1) * limit
 
-	// Query builder
-	query := database.DB.Preload("Publisher").Model(&models.Application{})
-
-	// Apply filters
-	if publisherName != "" {
-		query = query.Joins("JOIN users ON users.id = applications.publisher_id").
-			Where("users.name = ?", publisherName)
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	if deploymentType != "" {
-		query = query.Where("type = ?", deploymentType)
+	filter.visible = func(query *gorm.DB) *gorm.DB { return visibleTo(r, query) }
+	order, err := filter.order(r.URL.Query().Get("sort"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	var total int64
-	query.Count(&total)
+	if err := filter.apply(database.DB.Model(&models.Application{}), "").Count(&total).Error; err != nil {
+		http.Error(w, "Failed to fetch applications", http.StatusInternalServerError)
+		return
+	}
 
 	var applications []models.Application
-	result := query.Limit(limit).Offset(offset).Find(&applications)
+	result := filter.apply(database.DB.Preload("Publisher").Model(&models.Application{}), "").
+		Order(order).
+		Limit(limit).
+		Offset(offset).
+		Find(&applications)
 	if result.Error != nil {
 		http.Error(w, "Failed to fetch applications", http.StatusInternalServerError)
 		return
 	}
 
+	facets, err := filter.facets()
+	if err != nil {
+		http.Error(w, "Failed to count applications", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range applications {
+		redactApplication(&applications[i])
+	}
+
 	// Prepare response
 	response := map[string]interface{}{
 		"data":        applications,
@@ -114,60 +228,102 @@ func ListApplications(w http.ResponseWriter, r *http.Request) {
 		"limit":       limit,
 		"total_items": total,
 		"total_pages": (total + int64(limit) - 1) / int64(limit),
+		"facets":      facets,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-func GetApplication(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id") // Get ID from URL
+// ListCategories API to list the categories applications are filed under
+func ListCategories(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Categories)
+}
 
-	var app models.Application
-	if err := database.DB.Preload("Publisher").First(&app, id).Error; err != nil {
-		http.Error(w, "Application not found", http.StatusNotFound)
+// GetApplication API to get an application, only its publisher sees it before it was approved
+func GetApplication(w http.ResponseWriter, r *http.Request) {
+	app, ok := visibleApplication(w, r, chi.URLParam(r, "id"))
+	if !ok {
 		return
 	}
 
+	redactApplication(&app)
 	json.NewEncoder(w).Encode(app)
 }
 
+// UpdateApplication API (only for the publisher of the application)
 func UpdateApplication(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-
-	var app models.Application
-	if err := database.DB.First(&app, id).Error; err != nil {
-		http.Error(w, "Application not found", http.StatusNotFound)
+	app, ok := ownedApplication(w, r, chi.URLParam(r, "id"))
+	if !ok {
 		return
 	}
 
 	var req struct {
 		Name        string                 `json:"name"`
 		Description string                 `json:"description"`
-		Deployment  models.DeploymentSpec  `json:"deployment"`
-		Inputs      map[string]interface{} `json:"inputs"` // Input fields as JSON
+		Deployment  *models.DeploymentSpec `json:"deployment"`
+		Inputs      map[string]interface{} `json:"inputs"`
+		Category    *string                `json:"category"`   // Left unchanged if omitted
+		Tags        *[]string              `json:"tags"`       // Left unchanged if omitted
+		TrialDays   *int                   `json:"trial_days"` // Left unchanged if omitted, running trials keep their end
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
+	// Changing the spec in place would change what existing deployments point at
+	if req.Deployment != nil || req.Inputs != nil {
+		http.Error(w, fmt.Sprintf("The deployment spec and inputs are versioned, release a new version with POST /api/apps/%d/versions", app.ID), http.StatusBadRequest)
+		return
+	}
+
 	// Update application details
+	before := app
 	app.Name = req.Name
 	app.Description = req.Description
-	app.Deployment = req.Deployment
-	app.Inputs = req.Inputs // Update the inputs
+	if req.Category != nil {
+		if !IsValidCategory(*req.Category) {
+			http.Error(w, fmt.Sprintf("Invalid category. Valid categories are: %s", strings.Join(Categories, ", ")), http.StatusBadRequest)
+			return
+		}
+		app.Category = *req.Category
+	}
+	if req.Tags != nil {
+		tags, err := normalizeTags(*req.Tags)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		app.Tags = tags
+	}
+	if req.TrialDays != nil {
+		if !isValidTrialDays(*req.TrialDays) {
+			http.Error(w, fmt.Sprintf("trial_days must be between 0 and %d", maxTrialDays), http.StatusBadRequest)
+			return
+		}
+		app.TrialDays = *req.TrialDays
+	}
 
 	if err := database.DB.Save(&app).Error; err != nil {
 		http.Error(w, "Failed to update application", http.StatusInternalServerError)
 		return
 	}
 
+	redactApplication(&before)
+	redactApplication(&app)
+	audit.Changes(r, before, app)
 	json.NewEncoder(w).Encode(app)
 }
 
+// DeleteApplication API (only for the publisher of the application)
 func DeleteApplication(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
+	app, ok := ownedApplication(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+	id := app.ID
 
 	// Check if the application has active deployments
 	var count int64
@@ -177,11 +333,60 @@ func DeleteApplication(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Delete application
-	if err := database.DB.Delete(&models.Application{}, id).Error; err != nil {
+	// Uninstalled deployments are kept for audit and billing and still reference the application
+	database.DB.Unscoped().Model(&models.Deployment{}).Where("application_id = ? AND deleted_at IS NOT NULL", id).Count(&count)
+	if count > 0 {
+		http.Error(w, "Cannot delete: Uninstalled deployments are kept for audit and billing", http.StatusConflict)
+		return
+	}
+
+	// Delete application together with its versions and their pricing plans
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		versions := tx.Model(&models.ApplicationVersion{}).Select("id").Where("application_id = ?", id)
+		if err := tx.Where("application_version_id IN (?)", versions).Delete(&models.PricingPlan{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("application_id = ?", id).Delete(&models.ApplicationVersion{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Application{}, id).Error
+	})
+	if err != nil {
 		http.Error(w, "Failed to delete application", http.StatusInternalServerError)
 		return
 	}
+	redactApplication(&app)
+	audit.Changes(r, app, nil)
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func isValidTrialDays(days int) bool {
+	return days >= 0 && days <= maxTrialDays
+}
+
+// ownedApplication loads an application the caller published, writing the
+// error response otherwise. Admins own every application.
+func ownedApplication(w http.ResponseWriter, r *http.Request, id string) (models.Application, bool) {
+	var app models.Application
+	if err := database.DB.First(&app, id).Error; err != nil {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return app, false
+	}
+	if !middleware.Owns(r, app.PublisherID) {
+		middleware.Forbidden(w)
+		return app, false
+	}
+	return app, true
+}
+
+// redactApplication masks the defaults of the secret inputs of an application
+// before it is returned, publishers may ship license keys or passwords as defaults
+func redactApplication(app *models.Application) {
+	app.Inputs = jsonschema.RedactDefaults(app.Inputs, secrets.Mask)
+}
+
+// redactVersion masks the defaults of the secret inputs of a version before it is returned
+func redactVersion(version *models.ApplicationVersion) {
+	version.InputsSchema = jsonschema.RedactDefaults(version.InputsSchema, secrets.Mask)
+}
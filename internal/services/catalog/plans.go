@@ -0,0 +1,234 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/audit"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/billing"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+var (
+	ErrPlanNotFound = errors.New("pricing plan not found")
+	ErrPlanRequired = errors.New("the version offers several pricing plans, pick one")
+)
+
+// planRequest is a pricing plan as publishers declare it
+type planRequest struct {
+	Name          string  `json:"name"` // The type if omitted
+	Type          string  `json:"type"` // One of billing.PlanTypes
+	SetupFee      float64 `json:"setup_fee"`
+	MonthlyPrice  float64 `json:"monthly_price"`
+	HourlyRate    float64 `json:"hourly_rate"`
+	MinimumCharge float64 `json:"minimum_charge"`
+	Tiers         []struct {
+		UpToHours  *float64 `json:"up_to_hours"` // Omitted for the last tier
+		HourlyRate float64  `json:"hourly_rate"`
+	} `json:"tiers"`
+}
+
+// toPlans validates the plans of a request
+func toPlans(requests []planRequest) ([]models.PricingPlan, error) {
+	plans := make([]models.PricingPlan, 0, len(requests))
+	names := make(map[string]bool)
+	for _, req := range requests {
+		plan := models.PricingPlan{
+			Name:          req.Name,
+			Type:          req.Type,
+			SetupFee:      req.SetupFee,
+			MonthlyPrice:  req.MonthlyPrice,
+			HourlyRate:    req.HourlyRate,
+			MinimumCharge: req.MinimumCharge,
+		}
+		if plan.Name == "" {
+			plan.Name = plan.Type
+		}
+		for _, tier := range req.Tiers {
+			plan.Tiers = append(plan.Tiers, models.PriceTier{UpToHours: tier.UpToHours, HourlyRate: tier.HourlyRate})
+		}
+		if err := billing.ValidatePlan(plan); err != nil {
+			return nil, fmt.Errorf("plan %q: %w", plan.Name, err)
+		}
+		if names[plan.Name] {
+			return nil, fmt.Errorf("plan %q is declared twice", plan.Name)
+		}
+		names[plan.Name] = true
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+// ListPricingPlans API to list the pricing plans of a version
+func ListPricingPlans(w http.ResponseWriter, r *http.Request) {
+	app, ok := visibleApplication(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+	version, err := findVersion(database.DB, chi.URLParam(r, "id"), chi.URLParam(r, "versionID"))
+	if err != nil || !middleware.Owns(r, app.PublisherID) && !isPublicVersionStatus(version.Status) {
+		http.Error(w, "Application version not found", http.StatusNotFound)
+		return
+	}
+
+	var plans []models.PricingPlan
+	if err := database.DB.Where("application_version_id = ?", version.ID).Order("id").Find(&plans).Error; err != nil {
+		http.Error(w, "Failed to fetch pricing plans", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plans)
+}
+
+// AddPricingPlan API to offer another pricing plan on a draft version (only for its publisher)
+func AddPricingPlan(w http.ResponseWriter, r *http.Request) {
+	var req planRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	version, ok := editableVersion(w, r)
+	if !ok {
+		return
+	}
+	plans, err := toPlans([]planRequest{req})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	plan := plans[0]
+	plan.ApplicationVersionID = version.ID
+
+	var existing int64
+	database.DB.Model(&models.PricingPlan{}).Where("application_version_id = ? AND name = ?", version.ID, plan.Name).Count(&existing)
+	if existing > 0 {
+		http.Error(w, fmt.Sprintf("Plan %s already exists", plan.Name), http.StatusConflict)
+		return
+	}
+
+	if err := database.DB.Create(&plan).Error; err != nil {
+		http.Error(w, "Failed to add pricing plan", http.StatusInternalServerError)
+		return
+	}
+	audit.Resource(r, "pricing_plan", plan.ID)
+	audit.Changes(r, nil, plan)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(plan)
+}
+
+// DeletePricingPlan API to withdraw a pricing plan from a draft version (only for its publisher)
+func DeletePricingPlan(w http.ResponseWriter, r *http.Request) {
+	version, ok := editableVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var plan models.PricingPlan
+	if err := database.DB.Where("application_version_id = ?", version.ID).First(&plan, chi.URLParam(r, "planID")).Error; err != nil {
+		http.Error(w, "Pricing plan not found", http.StatusNotFound)
+		return
+	}
+	if err := database.DB.Delete(&plan).Error; err != nil {
+		http.Error(w, "Failed to delete pricing plan", http.StatusInternalServerError)
+		return
+	}
+	audit.Changes(r, plan, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResolvePlan returns the pricing plan of version a new deployment is billed
+// on: planID if given, the only plan of the version otherwise. Versions
+// without plans are billed at the hourly rate of their application, nil is
+// returned for them.
+func ResolvePlan(db *gorm.DB, version models.ApplicationVersion, planID uint) (*models.PricingPlan, error) {
+	var plans []models.PricingPlan
+	if err := db.Where("application_version_id = ?", version.ID).Order("id").Find(&plans).Error; err != nil {
+		return nil, err
+	}
+	return pickPlan(version, plans, planID, nil)
+}
+
+// UpgradePlan returns the pricing plan of version a deployment billed on the plan
+// currentID (nil for the hourly rate of its application) is billed on once
+// upgraded to it: planID if given, the plan of the version named like the
+// current one, or its only plan otherwise
+func UpgradePlan(db *gorm.DB, version models.ApplicationVersion, planID uint, currentID *uint) (*models.PricingPlan, error) {
+	var plans []models.PricingPlan
+	if err := db.Where("application_version_id = ?", version.ID).Order("id").Find(&plans).Error; err != nil {
+		return nil, err
+	}
+
+	var current *models.PricingPlan
+	if currentID != nil && planID == 0 {
+		current = &models.PricingPlan{}
+		if err := db.Select("id", "name").First(current, *currentID).Error; err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, err
+			}
+			current = nil
+		}
+	}
+	return pickPlan(version, plans, planID, current)
+}
+
+// pickPlan picks the plan among the plans of version: planID if given, current
+// or the plan named like it if the version offers it, the only plan otherwise
+func pickPlan(version models.ApplicationVersion, plans []models.PricingPlan, planID uint, current *models.PricingPlan) (*models.PricingPlan, error) {
+	if planID != 0 {
+		for i := range plans {
+			if plans[i].ID == planID {
+				return &plans[i], nil
+			}
+		}
+		return nil, fmt.Errorf("%w: version %s has no plan %d", ErrPlanNotFound, version.Version, planID)
+	}
+
+	if current != nil {
+		for i := range plans {
+			if plans[i].ID == current.ID {
+				return &plans[i], nil
+			}
+		}
+		for i := range plans {
+			if plans[i].Name == current.Name {
+				return &plans[i], nil
+			}
+		}
+	}
+
+	switch len(plans) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &plans[0], nil
+	}
+	return nil, ErrPlanRequired
+}
+
+// editableVersion loads the version of the request if the caller publishes it
+// and its plans may still change, writing the error response otherwise
+func editableVersion(w http.ResponseWriter, r *http.Request) (models.ApplicationVersion, bool) {
+	if _, ok := ownedApplication(w, r, chi.URLParam(r, "id")); !ok {
+		return models.ApplicationVersion{}, false
+	}
+	version, err := findVersion(database.DB, chi.URLParam(r, "id"), chi.URLParam(r, "versionID"))
+	if err != nil {
+		http.Error(w, "Application version not found", http.StatusNotFound)
+		return version, false
+	}
+	if version.Status != VersionDraft && version.Status != VersionRejected {
+		http.Error(w, fmt.Sprintf("Version %s is %s, pricing plans only change on drafts; release a new version to change prices", version.Version, version.Status), http.StatusConflict)
+		return version, false
+	}
+	return version, true
+}
@@ -0,0 +1,58 @@
+package catalog
+
+import (
+	"errors"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"testing"
+)
+
+func TestPickPlan(t *testing.T) {
+	v1 := models.ApplicationVersion{ID: 1, Version: "1.0.0"}
+	v2 := models.ApplicationVersion{ID: 2, Version: "2.0.0"}
+	v1Plans := []models.PricingPlan{{ID: 10, ApplicationVersionID: 1, Name: "basic"}, {ID: 11, ApplicationVersionID: 1, Name: "pro"}}
+	v2Plans := []models.PricingPlan{{ID: 20, ApplicationVersionID: 2, Name: "basic"}, {ID: 21, ApplicationVersionID: 2, Name: "pro"}}
+	onlyPlan := []models.PricingPlan{{ID: 30, ApplicationVersionID: 2, Name: "standard"}}
+	pro := &models.PricingPlan{ID: 11, Name: "pro"}
+	retired := &models.PricingPlan{ID: 12, Name: "enterprise"}
+
+	tests := []struct {
+		name    string
+		version models.ApplicationVersion
+		plans   []models.PricingPlan
+		planID  uint
+		current *models.PricingPlan
+		want    uint // Plan picked, 0 for none
+		err     error
+	}{
+		{"install on the requested plan", v1, v1Plans, 11, nil, 11, nil},
+		{"install on the only plan", v2, onlyPlan, 0, nil, 30, nil},
+		{"install on one of several plans", v1, v1Plans, 0, nil, 0, ErrPlanRequired},
+		{"install without plans", v1, nil, 0, nil, 0, nil},
+		{"plan of another version", v2, v2Plans, 11, nil, 0, ErrPlanNotFound},
+		{"values upgrade keeps the plan", v1, v1Plans, 0, pro, 11, nil},
+		{"upgrade remaps to the plan of the same name", v2, v2Plans, 0, pro, 21, nil},
+		{"upgrade on the requested plan", v2, v2Plans, 20, pro, 20, nil},
+		{"upgrade rejects the plan of the old version", v2, v2Plans, 11, pro, 0, ErrPlanNotFound},
+		{"upgrade to the only plan", v2, onlyPlan, 0, pro, 30, nil},
+		{"upgrade without a plan of the same name", v2, v2Plans, 0, retired, 0, ErrPlanRequired},
+		{"upgrade to a version without plans", v2, nil, 0, pro, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := pickPlan(tt.version, tt.plans, tt.planID, tt.current)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("pickPlan error = %v, want %v", err, tt.err)
+			}
+			var got uint
+			if plan != nil {
+				got = plan.ID
+			}
+			if got != tt.want {
+				t.Errorf("pickPlan = plan %d, want %d", got, tt.want)
+			}
+			if plan != nil && plan.ApplicationVersionID != tt.version.ID {
+				t.Errorf("picked plan %d of version %d, want a plan of version %d", plan.ID, plan.ApplicationVersionID, tt.version.ID)
+			}
+		})
+	}
+}
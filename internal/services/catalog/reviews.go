@@ -0,0 +1,244 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/audit"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Review steps
+const (
+	ReviewSubmitted = "submitted"
+	ReviewWithdrawn = "withdrawn"
+	ReviewApproved  = "approved"
+	ReviewRejected  = "rejected"
+)
+
+// publicVersionStatuses are the statuses of the versions consumers can see
+var publicVersionStatuses = []string{VersionPublished, VersionDeprecated}
+
+var errReviewDecision = errors.New("versions in review are approved or rejected by admins")
+
+// reviewItem is a version waiting in the review queue with what admins vet
+type reviewItem struct {
+	models.ApplicationVersion
+	Application struct {
+		ID          uint    `json:"id"`
+		Name        string  `json:"name"`
+		Description string  `json:"description"`
+		PublisherID uint    `json:"publisher_id"`
+		HourlyRate  float64 `json:"hourly_rate"`
+		Category    string  `json:"category"`
+	} `json:"application"`
+}
+
+// ListReviewQueue API to list the versions waiting for review, oldest submission first (only for admins)
+func ListReviewQueue(w http.ResponseWriter, r *http.Request) {
+	var versions []models.ApplicationVersion
+	if err := database.DB.Preload("Plans").Where("status = ?", VersionInReview).Order("submitted_at, id").Find(&versions).Error; err != nil {
+		http.Error(w, "Failed to fetch review queue", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]reviewItem, 0, len(versions))
+	for _, version := range versions {
+		var app models.Application
+		if err := database.DB.First(&app, version.ApplicationID).Error; err != nil {
+			http.Error(w, "Failed to fetch review queue", http.StatusInternalServerError)
+			return
+		}
+
+		redactVersion(&version)
+		item := reviewItem{ApplicationVersion: version}
+		item.Application.ID = app.ID
+		item.Application.Name = app.Name
+		item.Application.Description = app.Description
+		item.Application.PublisherID = app.PublisherID
+		item.Application.HourlyRate = app.HourlyRate
+		item.Application.Category = app.Category
+		items = append(items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// ApproveVersion API to publish a version in review (only for admins)
+func ApproveVersion(w http.ResponseWriter, r *http.Request) {
+	decideReview(w, r, VersionPublished)
+}
+
+// RejectVersion API to send a version in review back to its publisher with the
+// reasons in a comment (only for admins)
+func RejectVersion(w http.ResponseWriter, r *http.Request) {
+	decideReview(w, r, VersionRejected)
+}
+
+// ListVersionReviews API to get the review history of a version (only for its publisher)
+func ListVersionReviews(w http.ResponseWriter, r *http.Request) {
+	if _, ok := ownedApplication(w, r, chi.URLParam(r, "id")); !ok {
+		return
+	}
+	version, err := findVersion(database.DB, chi.URLParam(r, "id"), chi.URLParam(r, "versionID"))
+	if err != nil {
+		http.Error(w, "Application version not found", http.StatusNotFound)
+		return
+	}
+
+	var reviews []models.VersionReview
+	if err := database.DB.Preload("User").
+		Where("application_version_id = ?", version.ID).
+		Order("created_at, id").
+		Find(&reviews).Error; err != nil {
+		http.Error(w, "Failed to fetch reviews", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reviews)
+}
+
+func decideReview(w http.ResponseWriter, r *http.Request, status string) {
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if status == VersionRejected && strings.TrimSpace(req.Comment) == "" {
+		http.Error(w, "A comment explaining the rejection is required", http.StatusBadRequest)
+		return
+	}
+
+	var before, version models.ApplicationVersion
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&version, chi.URLParam(r, "versionID")).Error; err != nil {
+			return ErrVersionNotFound
+		}
+		before = version
+		if version.Status != VersionInReview {
+			return fmt.Errorf("%w: version %s is %s, not in review", ErrInvalidVersionTransition, version.Version, version.Status)
+		}
+		return setVersionStatus(tx, &version, status, middleware.UserID(r), req.Comment)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrVersionNotFound):
+			http.Error(w, "Application version not found", http.StatusNotFound)
+		case errors.Is(err, ErrInvalidVersionTransition):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "Failed to review application version", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("🔎 Version %d of application %d %s", version.ID, version.ApplicationID, version.Status)
+
+	redactVersion(&before)
+	redactVersion(&version)
+	audit.Changes(r, before, version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version)
+}
+
+// setVersionStatus moves a version to another status within tx, recording the
+// review step it is and updating the application once a version is published or
+// deprecated. Of concurrent changes of the same version only the first one succeeds.
+func setVersionStatus(tx *gorm.DB, version *models.ApplicationVersion, to string, userID uint, comment string) error {
+	from := version.Status
+	if !canTransitionVersion(from, to) {
+		return fmt.Errorf("%w: cannot move version %s from %q to %q", ErrInvalidVersionTransition, version.Version, from, to)
+	}
+
+	updates := map[string]interface{}{"status": to}
+	if to == VersionInReview {
+		now := time.Now()
+		updates["submitted_at"] = now
+		version.SubmittedAt = &now
+	}
+	result := tx.Model(&models.ApplicationVersion{}).
+		Where("id = ? AND status = ?", version.ID, from).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: version %s was changed meanwhile", ErrInvalidVersionTransition, version.Version)
+	}
+	version.Status = to
+
+	if action := reviewAction(from, to); action != "" {
+		if err := tx.Create(&models.VersionReview{
+			ApplicationVersionID: version.ID,
+			UserID:               userID,
+			Action:               action,
+			Comment:              comment,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return syncLatestVersion(tx, version.ApplicationID)
+}
+
+// reviewAction returns the review step a status change is, "" if it is none
+func reviewAction(from, to string) string {
+	switch {
+	case to == VersionInReview:
+		return ReviewSubmitted
+	case from == VersionInReview && to == VersionDraft:
+		return ReviewWithdrawn
+	case from == VersionInReview && to == VersionPublished:
+		return ReviewApproved
+	case from == VersionInReview && to == VersionRejected:
+		return ReviewRejected
+	}
+	return ""
+}
+
+// isReviewDecision reports whether a status change approves or rejects a version in review
+func isReviewDecision(from, to string) bool {
+	action := reviewAction(from, to)
+	return action == ReviewApproved || action == ReviewRejected
+}
+
+func isPublicVersionStatus(status string) bool {
+	for _, s := range publicVersionStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// visibleTo restricts a query on applications to the ones the caller can see:
+// applications with a published version, and their own ones for publishers.
+// Admins see every application.
+func visibleTo(r *http.Request, query *gorm.DB) *gorm.DB {
+	if middleware.IsAdmin(r) {
+		return query
+	}
+	return query.Where("(EXISTS (SELECT 1 FROM application_versions v WHERE v.application_id = applications.id AND v.status = ?) OR applications.publisher_id = ?)",
+		VersionPublished, middleware.UserID(r))
+}
+
+// visibleApplication loads an application the caller can see, writing the error
+// response otherwise. Applications that weren't approved yet are not found.
+func visibleApplication(w http.ResponseWriter, r *http.Request, id string) (models.Application, bool) {
+	var app models.Application
+	if err := visibleTo(r, database.DB.Preload("Publisher")).First(&app, id).Error; err != nil {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return app, false
+	}
+	return app, true
+}
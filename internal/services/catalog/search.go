@@ -0,0 +1,230 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/billing"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Categories applications are filed under
+var Categories = []string{
+	"analytics", "databases", "developer-tools", "messaging", "monitoring",
+	"networking", "security", "storage", "web", "other",
+}
+
+// DefaultCategory is the category of applications published without one
+const DefaultCategory = "other"
+
+// Tag limits
+const (
+	maxTags      = 20
+	maxTagLength = 50
+)
+
+// SearchVector is the text applications are searched in, indexed by idx_applications_search
+const SearchVector = "to_tsvector('english', coalesce(applications.name, '') || ' ' || coalesce(applications.description, ''))"
+
+// publishedPlans selects the pricing plans of the published versions of an application
+var publishedPlans = fmt.Sprintf("FROM pricing_plans JOIN application_versions ON application_versions.id = pricing_plans.application_version_id "+
+	"WHERE application_versions.application_id = applications.id AND application_versions.status = '%s'", VersionPublished)
+
+// hourlyPrice is the lowest hourly rate an application can be deployed at, which
+// the price filters and sort orders use: that of the free and hourly plans of its
+// published versions, or its own hourly rate while none of them offers plans.
+// Monthly and tiered plans have no single hourly rate, so applications only
+// offering those have none and never match a price filter.
+var hourlyPrice = fmt.Sprintf("(CASE WHEN EXISTS (SELECT 1 %[1]s) "+
+	"THEN (SELECT MIN(CASE WHEN pricing_plans.type = '%[2]s' THEN 0 ELSE pricing_plans.hourly_rate END) %[1]s AND pricing_plans.type IN ('%[2]s', '%[3]s')) "+
+	"ELSE applications.hourly_rate END)", publishedPlans, billing.PlanFree, billing.PlanHourly)
+
+// Sort orders of ListApplications; "relevance" is the default when searching, "name" otherwise
+var sortOrders = map[string]string{
+	"name":       "applications.name",
+	"price":      hourlyPrice + " NULLS LAST, applications.name",
+	"price_desc": hourlyPrice + " DESC NULLS LAST, applications.name",
+	"newest":     "applications.id DESC",
+	"popularity": "(SELECT COUNT(*) FROM deployments WHERE deployments.application_id = applications.id) DESC, applications.name",
+}
+
+// maxTagFacets bounds the tags counted in the facets of a listing
+const maxTagFacets = 20
+
+// IsValidCategory reports whether category is one of Categories
+func IsValidCategory(category string) bool {
+	for _, c := range Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeTags lowercases and trims tags and drops empty and duplicate ones
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := []string{}
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxTags {
+		return nil, fmt.Errorf("an application can have at most %d tags", maxTags)
+	}
+	return normalized, nil
+}
+
+// catalogFilter is the search of a ListApplications request
+type catalogFilter struct {
+	query          string   // Full-text search over name and description
+	publisher      string   // Exact publisher name
+	deploymentType string   // "k8s" or "vm"
+	categories     []string // Any of them
+	tags           []string // All of them
+	minPrice       *float64 // Lowest hourly rate, see hourlyPrice
+	maxPrice       *float64
+
+	// visible restricts the search to the applications the caller can see
+	visible func(query *gorm.DB) *gorm.DB
+}
+
+// parseFilter reads the filters of a ListApplications request
+func parseFilter(params url.Values) (catalogFilter, error) {
+	filter := catalogFilter{
+		query:          strings.TrimSpace(params.Get("q")),
+		publisher:      params.Get("publisher"),
+		deploymentType: params.Get("deploymentType"),
+	}
+	for _, category := range strings.Split(params.Get("category"), ",") {
+		if category = strings.TrimSpace(category); category != "" {
+			filter.categories = append(filter.categories, category)
+		}
+	}
+	for _, value := range params["tag"] {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+				filter.tags = append(filter.tags, tag)
+			}
+		}
+	}
+	for name, limit := range map[string]**float64{"min_price": &filter.minPrice, "max_price": &filter.maxPrice} {
+		if value := params.Get(name); value != "" {
+			price, err := strconv.ParseFloat(value, 64)
+			if err != nil || price < 0 {
+				return filter, fmt.Errorf("invalid %s", name)
+			}
+			*limit = &price
+		}
+	}
+	return filter, nil
+}
+
+// apply adds the filters to a query on applications, except the one a facet is counted for
+func (f catalogFilter) apply(query *gorm.DB, except string) *gorm.DB {
+	if f.visible != nil {
+		query = f.visible(query)
+	}
+	if f.query != "" {
+		query = query.Where(SearchVector+" @@ websearch_to_tsquery('english', ?)", f.query)
+	}
+	if f.publisher != "" {
+		query = query.Joins("JOIN users ON users.id = applications.publisher_id").
+			Where("users.name = ?", f.publisher)
+	}
+	if f.deploymentType != "" && except != "deployment_types" {
+		query = query.Where("applications.type = ?", f.deploymentType)
+	}
+	if len(f.categories) > 0 && except != "categories" {
+		query = query.Where("applications.category IN ?", f.categories)
+	}
+	if len(f.tags) > 0 {
+		tags, _ := json.Marshal(f.tags)
+		query = query.Where("applications.tags @> CAST(? AS jsonb)", string(tags))
+	}
+	if f.minPrice != nil {
+		query = query.Where(hourlyPrice+" >= ?", *f.minPrice)
+	}
+	if f.maxPrice != nil {
+		query = query.Where(hourlyPrice+" <= ?", *f.maxPrice)
+	}
+	return query
+}
+
+// order returns the ORDER BY clause of a sort order, for gorm's Order
+func (f catalogFilter) order(sort string) (interface{}, error) {
+	if sort == "" {
+		sort = "name"
+		if f.query != "" {
+			sort = "relevance"
+		}
+	}
+	if sort == "relevance" {
+		if f.query == "" {
+			return nil, fmt.Errorf("sorting by relevance requires a search query")
+		}
+		return clause.OrderBy{Expression: gorm.Expr("ts_rank("+SearchVector+", websearch_to_tsquery('english', ?)) DESC, applications.name", f.query)}, nil
+	}
+	order, ok := sortOrders[sort]
+	if !ok {
+		return nil, fmt.Errorf("invalid sort, valid ones are: name, price, price_desc, newest, popularity, relevance")
+	}
+	return order, nil
+}
+
+// facets counts the applications matching the filter per category, tag and
+// deployment type. The category and deployment type counts ignore the filter on
+// their own field, so clients can show what picking another value would match;
+// tags only narrow the search down, so their counts keep the tag filter.
+func (f catalogFilter) facets() (map[string]map[string]int64, error) {
+	type count struct {
+		Value string
+		Count int64
+	}
+	queries := map[string]func() *gorm.DB{
+		"categories": func() *gorm.DB {
+			return f.apply(database.DB.Model(&models.Application{}), "categories").
+				Select("applications.category AS value, COUNT(*) AS count").
+				Group("applications.category")
+		},
+		"deployment_types": func() *gorm.DB {
+			return f.apply(database.DB.Model(&models.Application{}), "deployment_types").
+				Select("applications.type AS value, COUNT(*) AS count").
+				Group("applications.type")
+		},
+		"tags": func() *gorm.DB {
+			// Rows written before tags existed hold no array
+			return f.apply(database.DB.Model(&models.Application{}), "").
+				Joins("CROSS JOIN jsonb_array_elements_text(CASE WHEN jsonb_typeof(applications.tags) = 'array' THEN applications.tags ELSE '[]'::jsonb END) AS tag").
+				Select("tag AS value, COUNT(*) AS count").
+				Group("tag").
+				Order("count DESC, tag").
+				Limit(maxTagFacets)
+		},
+	}
+
+	facets := make(map[string]map[string]int64, len(queries))
+	for name, query := range queries {
+		var counts []count
+		if err := query().Scan(&counts).Error; err != nil {
+			return nil, err
+		}
+		facets[name] = make(map[string]int64, len(counts))
+		for _, c := range counts {
+			facets[name][c.Value] = c.Count
+		}
+	}
+	return facets, nil
+}
@@ -0,0 +1,92 @@
+package catalog
+
+import (
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// dryRun returns a database that builds statements without running them
+func dryRun(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestParseFilterPrices(t *testing.T) {
+	tests := []struct {
+		query    string
+		min, max string // Limits parsed, empty if none
+		err      string
+	}{
+		{"", "", "", ""},
+		{"min_price=0.5&max_price=2", "0.5", "2", ""},
+		{"max_price=0", "", "0", ""},
+		{"min_price=-1", "", "", "invalid min_price"},
+		{"max_price=cheap", "", "", "invalid max_price"},
+	}
+	for _, tt := range tests {
+		params, _ := url.ParseQuery(tt.query)
+		filter, err := parseFilter(params)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("parseFilter(%q) error = %v, want %q", tt.query, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseFilter(%q): %v", tt.query, err)
+			continue
+		}
+		if got := formatPrice(filter.minPrice); got != tt.min {
+			t.Errorf("parseFilter(%q) min price = %q, want %q", tt.query, got, tt.min)
+		}
+		if got := formatPrice(filter.maxPrice); got != tt.max {
+			t.Errorf("parseFilter(%q) max price = %q, want %q", tt.query, got, tt.max)
+		}
+	}
+}
+
+func formatPrice(price *float64) string {
+	if price == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*price, 'f', -1, 64)
+}
+
+func TestPriceFilterUsesPlans(t *testing.T) {
+	minPrice, maxPrice := 0.5, 2.0
+	filter := catalogFilter{minPrice: &minPrice, maxPrice: &maxPrice}
+
+	stmt := filter.apply(dryRun(t).Model(&models.Application{}), "").Find(&[]models.Application{}).Statement
+	sql := stmt.SQL.String()
+
+	// Both limits apply to the rate of the plans, falling back to the rate of the application
+	if strings.Count(sql, "pricing_plans.hourly_rate") != 2 || strings.Count(sql, "ELSE applications.hourly_rate END") != 2 {
+		t.Errorf("price filters don't use the plans of the application:\n%s", sql)
+	}
+	// Only published versions are deployed, and only free and hourly plans have a single hourly rate
+	for _, want := range []string{"application_versions.status = 'published'", "pricing_plans.type IN ('free', 'hourly')"} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("price filters don't restrict to %s:\n%s", want, sql)
+		}
+	}
+	if len(stmt.Vars) != 2 || stmt.Vars[0] != minPrice || stmt.Vars[1] != maxPrice {
+		t.Errorf("price filters bound %v, want [%v %v]", stmt.Vars, minPrice, maxPrice)
+	}
+
+	order, err := filter.order("price_desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := order.(string); !strings.HasPrefix(s, hourlyPrice+" DESC NULLS LAST") {
+		t.Errorf("price_desc sorts by %v, want the hourly price of the plans", order)
+	}
+}
@@ -0,0 +1,91 @@
+package catalog
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// semverPattern matches MAJOR.MINOR.PATCH with an optional pre-release and build metadata
+var semverPattern = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+[0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*)?$`)
+
+type semver struct {
+	core       [3]int
+	prerelease []string
+}
+
+func parseSemver(version string) (semver, error) {
+	match := semverPattern.FindStringSubmatch(version)
+	if match == nil {
+		return semver{}, fmt.Errorf("%q is not a semantic version (MAJOR.MINOR.PATCH)", version)
+	}
+
+	var v semver
+	for i := range v.core {
+		n, err := strconv.Atoi(match[i+1])
+		if err != nil {
+			return semver{}, fmt.Errorf("%q is not a semantic version: %w", version, err)
+		}
+		v.core[i] = n
+	}
+	if match[4] != "" {
+		v.prerelease = strings.Split(match[4], ".")
+	}
+	return v, nil
+}
+
+// compareSemver returns -1, 0 or 1 as a is lower than, equal to or higher than b.
+// Versions that don't parse sort before the ones that do.
+func compareSemver(a, b string) int {
+	va, errA := parseSemver(a)
+	vb, errB := parseSemver(b)
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b)
+	case errA != nil:
+		return -1
+	case errB != nil:
+		return 1
+	}
+
+	for i := range va.core {
+		if va.core[i] != vb.core[i] {
+			return cmp.Compare(va.core[i], vb.core[i])
+		}
+	}
+
+	// A pre-release has lower precedence than the release itself
+	switch {
+	case len(va.prerelease) == 0 && len(vb.prerelease) == 0:
+		return 0
+	case len(va.prerelease) == 0:
+		return 1
+	case len(vb.prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(va.prerelease) && i < len(vb.prerelease); i++ {
+		if c := comparePrereleaseIdentifier(va.prerelease[i], vb.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(va.prerelease), len(vb.prerelease))
+}
+
+// comparePrereleaseIdentifier compares numeric identifiers numerically, which
+// sort before alphanumeric ones compared in ASCII order
+func comparePrereleaseIdentifier(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return cmp.Compare(na, nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
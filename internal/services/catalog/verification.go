@@ -0,0 +1,246 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/utils/helm"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/env"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/jsonschema"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Chart verification modes (CHART_VERIFICATION)
+const (
+	VerifyFull  = "full"  // Index, digest, helm lint and helm template
+	VerifyIndex = "index" // Only the repository index, for hosts without helm
+	VerifyOff   = "off"
+)
+
+// maxCheckOutput bounds the helm output kept in a verification report
+const maxCheckOutput = 4096
+
+// verificationMode returns how charts are verified when versions are submitted for review
+func verificationMode() string {
+	switch mode := os.Getenv("CHART_VERIFICATION"); mode {
+	case "":
+		return VerifyFull
+	case VerifyFull, VerifyIndex, VerifyOff:
+		return mode
+	default:
+		log.Printf("⚠️ Invalid CHART_VERIFICATION=%q, using %s", mode, VerifyFull)
+		return VerifyFull
+	}
+}
+
+// VerifyApplicationVersion API to verify the chart of a version without
+// submitting it (only for its publisher), e.g. to fix a failed submission
+func VerifyApplicationVersion(w http.ResponseWriter, r *http.Request) {
+	if _, ok := ownedApplication(w, r, chi.URLParam(r, "id")); !ok {
+		return
+	}
+	version, err := findVersion(database.DB, chi.URLParam(r, "id"), chi.URLParam(r, "versionID"))
+	if err != nil {
+		http.Error(w, "Application version not found", http.StatusNotFound)
+		return
+	}
+	if version.Deployment.Type != "k8s" {
+		http.Error(w, "Only versions of Kubernetes-based applications have a chart to verify", http.StatusBadRequest)
+		return
+	}
+	if verificationMode() == VerifyOff {
+		http.Error(w, "Chart verification is turned off", http.StatusConflict)
+		return
+	}
+
+	verifyChart(r.Context(), &version)
+	if err := saveVerification(version); err != nil {
+		http.Error(w, "Failed to save verification report", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version.Verification)
+}
+
+// verifyChart checks the Helm chart of a version before it is submitted for
+// review and records the report, digest and app version on it. It reports
+// whether the version can be submitted; versions of VM-based applications and
+// every version when verification is off can. The checks give up once ctx is
+// done or CHART_VERIFICATION_TIMEOUT elapsed.
+func verifyChart(ctx context.Context, version *models.ApplicationVersion) bool {
+	mode := verificationMode()
+	if version.Deployment.Type != "k8s" || mode == VerifyOff {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(ctx, env.Duration("CHART_VERIFICATION_TIMEOUT", 30*time.Second))
+	defer cancel()
+
+	report := &models.ChartVerification{Passed: true, ChartVersion: version.ChartVersion, VerifiedAt: time.Now()}
+	version.Verification = report
+	check := func(name string, passed bool, message, output string) bool {
+		report.Checks = append(report.Checks, models.VerificationCheck{Name: name, Passed: passed, Message: message, Output: trimOutput(output)})
+		report.Passed = report.Passed && passed
+		return passed
+	}
+	skip := func(names ...string) {
+		for _, name := range names {
+			report.Checks = append(report.Checks, models.VerificationCheck{Name: name, Skipped: true, Message: "Not run"})
+		}
+	}
+
+	repoURL, chartName := version.Deployment.RepoURL, version.Deployment.ChartName
+	index, err := helm.FetchIndex(ctx, repoURL)
+	if !check("index", err == nil, indexMessage(repoURL, err), "") {
+		skip("chart", "digest", "lint", "template")
+		return false
+	}
+
+	chart, err := findChart(index, chartName, version.ChartVersion)
+	if err == nil {
+		report.ChartVersion = chart.Version
+		version.ChartDigest = chart.Digest
+		version.AppVersion = chart.AppVersion
+	}
+	if !check("chart", err == nil, chartMessage(chart, err), "") {
+		skip("digest", "lint", "template")
+		return false
+	}
+
+	if mode == VerifyIndex {
+		skip("digest", "lint", "template")
+		return report.Passed
+	}
+
+	chartURL, err := helm.ChartURL(repoURL, chart)
+	if err != nil {
+		check("digest", false, err.Error(), "")
+		skip("lint", "template")
+		return false
+	}
+	path, digest, err := helm.DownloadChart(ctx, chartURL)
+	if err != nil {
+		check("digest", false, err.Error(), "")
+		skip("lint", "template")
+		return false
+	}
+	defer os.Remove(path)
+	switch {
+	case chart.Digest == "":
+		version.ChartDigest = digest
+		check("digest", true, "The index has no digest, recorded the one of the downloaded archive", "")
+	case !strings.EqualFold(chart.Digest, digest):
+		check("digest", false, fmt.Sprintf("The downloaded archive has digest %s, the index lists %s", digest, chart.Digest), "")
+		skip("lint", "template")
+		return false
+	default:
+		check("digest", true, "The downloaded archive matches the digest of the index", "")
+	}
+
+	// Render the chart like a default install would: with the defaults of the inputs
+	schema, _ := jsonschema.Parse(version.InputsSchema)
+	values, _ := schema.Apply(nil)
+
+	output, err := helm.LintChart(ctx, path, values)
+	check("lint", err == nil, resultMessage("helm lint", err), output)
+
+	output, err = helm.TemplateChart(ctx, path, chartName, values)
+	if err == nil {
+		check("template", true, fmt.Sprintf("Rendered %d manifests", strings.Count(output, "# Source: ")), "")
+	} else {
+		check("template", false, resultMessage("helm template", err), output)
+	}
+	return report.Passed
+}
+
+// findChart returns the entry of a chart version in an index, the latest stable
+// one if version is empty
+func findChart(index *helm.Index, chartName, version string) (helm.ChartVersion, error) {
+	entries := index.Versions(chartName)
+	if len(entries) == 0 {
+		return helm.ChartVersion{}, fmt.Errorf("chart %s not found in the repository", chartName)
+	}
+
+	var chart *helm.ChartVersion
+	for i := range entries {
+		entry := &entries[i]
+		if version != "" {
+			if entry.Version == version || strings.TrimPrefix(entry.Version, "v") == strings.TrimPrefix(version, "v") {
+				chart = entry
+				break
+			}
+			continue
+		}
+		parsed, err := parseSemver(strings.TrimPrefix(entry.Version, "v"))
+		if err != nil || len(parsed.prerelease) > 0 {
+			continue
+		}
+		if chart == nil || compareSemver(strings.TrimPrefix(entry.Version, "v"), strings.TrimPrefix(chart.Version, "v")) > 0 {
+			chart = entry
+		}
+	}
+	if chart == nil {
+		if version != "" {
+			return helm.ChartVersion{}, fmt.Errorf("chart %s has no version %s in the repository", chartName, version)
+		}
+		return helm.ChartVersion{}, fmt.Errorf("chart %s has no stable version in the repository", chartName)
+	}
+	if chart.Deprecated {
+		return *chart, fmt.Errorf("chart %s %s is deprecated in the repository", chartName, chart.Version)
+	}
+	return *chart, nil
+}
+
+// saveVerification stores the verification outcome recorded on a version
+func saveVerification(version models.ApplicationVersion) error {
+	return database.DB.Model(&version).Select("chart_digest", "app_version", "verification").Updates(&version).Error
+}
+
+// writeVerificationFailure writes the report of a version that failed
+// verification and so was not submitted for review
+func writeVerificationFailure(w http.ResponseWriter, version models.ApplicationVersion) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":        fmt.Sprintf("Chart verification failed, version %s stays %s", version.Version, version.Status),
+		"version_id":   version.ID,
+		"verification": version.Verification,
+	})
+}
+
+func indexMessage(repoURL string, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("Fetched the index of %s", repoURL)
+}
+
+func chartMessage(chart helm.ChartVersion, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("Found chart %s %s (app version %s)", chart.Name, chart.Version, chart.AppVersion)
+}
+
+func resultMessage(command string, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return command + " passed"
+}
+
+// trimOutput keeps the end of long helm output, where the errors are
+func trimOutput(output string) string {
+	output = strings.TrimSpace(output)
+	if len(output) > maxCheckOutput {
+		return "…" + output[len(output)-maxCheckOutput:]
+	}
+	return output
+}
@@ -0,0 +1,178 @@
+package catalog
+
+import (
+	"context"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/utils/helm"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindChart(t *testing.T) {
+	index := &helm.Index{Entries: map[string][]helm.ChartVersion{
+		"nginx": {
+			{Name: "nginx", Version: "15.2.0"},
+			{Name: "nginx", Version: "v15.10.1"},
+			{Name: "nginx", Version: "16.0.0-rc.1"},
+			{Name: "nginx", Version: "not-semver"},
+		},
+		"legacy": {
+			{Name: "legacy", Version: "1.0.0", Deprecated: true},
+		},
+		"nightly": {
+			{Name: "nightly", Version: "0.1.0-nightly.20240514"},
+		},
+	}}
+
+	tests := []struct {
+		name    string
+		chart   string
+		version string
+		want    string // Version found, empty if none
+		err     string // Part of the error, empty if none
+	}{
+		{"exact version", "nginx", "15.2.0", "15.2.0", ""},
+		{"version without its v prefix", "nginx", "15.10.1", "v15.10.1", ""},
+		{"prerelease asked for", "nginx", "16.0.0-rc.1", "16.0.0-rc.1", ""},
+		{"latest stable", "nginx", "", "v15.10.1", ""},
+		{"missing chart", "redis", "", "", "not found"},
+		{"missing version", "nginx", "14.0.0", "", "has no version 14.0.0"},
+		{"no stable version", "nightly", "", "", "has no stable version"},
+		{"deprecated chart", "legacy", "1.0.0", "1.0.0", "deprecated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chart, err := findChart(index, tt.chart, tt.version)
+			if chart.Version != tt.want {
+				t.Errorf("findChart found version %q, want %q", chart.Version, tt.want)
+			}
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("findChart: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("findChart error = %v, want one about %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// serveRepository serves a chart repository holding nginx 16.0.0 listed with
+// the given digest over plain HTTP on 127.0.0.1, which is allowed for the
+// duration of the test
+func serveRepository(t *testing.T, digest string) string {
+	t.Helper()
+	dir := t.TempDir()
+	index := `apiVersion: v1
+entries:
+  nginx:
+  - name: nginx
+    version: 16.0.0
+    appVersion: "1.25"
+    digest: ` + digest + `
+    urls:
+    - charts/nginx-16.0.0.tgz
+`
+	if err := os.WriteFile(filepath.Join(dir, "index.yaml"), []byte(index), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "charts"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "charts", "nginx-16.0.0.tgz"), []byte("not the archive the index lists"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CHART_REPO_ALLOWED_HOSTS", "127.0.0.1")
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func testVersion(repoURL, chartVersion string) *models.ApplicationVersion {
+	return &models.ApplicationVersion{
+		ChartVersion: chartVersion,
+		Deployment:   models.DeploymentSpec{Type: "k8s", RepoURL: repoURL, ChartName: "nginx"},
+	}
+}
+
+// checkResults returns the outcome of each check of a report: passed, failed or skipped
+func checkResults(report *models.ChartVerification) map[string]string {
+	results := make(map[string]string)
+	for _, check := range report.Checks {
+		switch {
+		case check.Skipped:
+			results[check.Name] = "skipped"
+		case check.Passed:
+			results[check.Name] = "passed"
+		default:
+			results[check.Name] = "failed"
+		}
+	}
+	return results
+}
+
+func TestVerifyChartAgainstTheIndex(t *testing.T) {
+	t.Setenv("CHART_VERIFICATION", VerifyIndex)
+	repoURL := serveRepository(t, strings.Repeat("ab", 32))
+
+	version := testVersion(repoURL, "")
+	if !verifyChart(context.Background(), version) {
+		t.Fatalf("verifyChart failed: %+v", version.Verification)
+	}
+	if version.Verification.ChartVersion != "16.0.0" || version.AppVersion != "1.25" || version.ChartDigest != strings.Repeat("ab", 32) {
+		t.Errorf("verified version = %+v, want the chart, app version and digest of the index", version)
+	}
+	want := map[string]string{"index": "passed", "chart": "passed", "digest": "skipped", "lint": "skipped", "template": "skipped"}
+	if got := checkResults(version.Verification); !reflect.DeepEqual(got, want) {
+		t.Errorf("checks = %v, want %v", got, want)
+	}
+
+	missing := testVersion(repoURL, "17.0.0")
+	if verifyChart(context.Background(), missing) {
+		t.Error("verifyChart passed a version missing from the index")
+	}
+	want = map[string]string{"index": "passed", "chart": "failed", "digest": "skipped", "lint": "skipped", "template": "skipped"}
+	if got := checkResults(missing.Verification); !reflect.DeepEqual(got, want) {
+		t.Errorf("checks = %v, want %v", got, want)
+	}
+}
+
+func TestVerifyChartRejectsADigestMismatch(t *testing.T) {
+	t.Setenv("CHART_VERIFICATION", VerifyFull)
+	repoURL := serveRepository(t, strings.Repeat("ab", 32))
+
+	version := testVersion(repoURL, "16.0.0")
+	if verifyChart(context.Background(), version) {
+		t.Fatal("verifyChart passed a chart whose archive does not match the index")
+	}
+	want := map[string]string{"index": "passed", "chart": "passed", "digest": "failed", "lint": "skipped", "template": "skipped"}
+	if got := checkResults(version.Verification); !reflect.DeepEqual(got, want) {
+		t.Errorf("checks = %v, want %v", got, want)
+	}
+}
+
+func TestVerifyChartRefusesRepositoriesOnPrivateHosts(t *testing.T) {
+	t.Setenv("CHART_VERIFICATION", VerifyIndex)
+	repoURL := serveRepository(t, strings.Repeat("ab", 32))
+	t.Setenv("CHART_REPO_ALLOWED_HOSTS", "")
+
+	version := testVersion(repoURL, "")
+	if verifyChart(context.Background(), version) {
+		t.Fatal("verifyChart fetched the index of a repository that is not allowed")
+	}
+	if got := checkResults(version.Verification)["index"]; got != "failed" {
+		t.Errorf("index check %s, want failed", got)
+	}
+}
+
+func TestVerifyChartSkipsVMApplications(t *testing.T) {
+	version := &models.ApplicationVersion{Deployment: models.DeploymentSpec{Type: "vm"}}
+	if !verifyChart(context.Background(), version) || version.Verification != nil {
+		t.Errorf("verifyChart of a VM-based application = %+v, want it passed without a report", version.Verification)
+	}
+}
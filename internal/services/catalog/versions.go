@@ -0,0 +1,342 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/audit"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/jsonschema"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"net/http"
+	"sort"
+)
+
+// Application version statuses
+const (
+	VersionDraft      = "draft"
+	VersionInReview   = "in_review"
+	VersionRejected   = "rejected"
+	VersionPublished  = "published"
+	VersionDeprecated = "deprecated"
+)
+
+// versionTransitions maps each version status to the statuses it may move to.
+// Versions are submitted for review by their publisher, which only admins can
+// approve (publish) or reject, see isReviewDecision.
+var versionTransitions = map[string][]string{
+	VersionDraft:      {VersionInReview},
+	VersionInReview:   {VersionPublished, VersionRejected, VersionDraft}, // Approve, reject or withdraw
+	VersionRejected:   {VersionInReview, VersionDraft},                   // Resubmit once fixed
+	VersionPublished:  {VersionDeprecated},
+	VersionDeprecated: {VersionPublished}, // Un-deprecate
+}
+
+var (
+	ErrVersionNotFound          = errors.New("application version not found")
+	ErrVersionNotPublished      = errors.New("application version is not published")
+	ErrInvalidVersionTransition = errors.New("invalid application version status transition")
+)
+
+type versionRequest struct {
+	Version      string                 `json:"version"`
+	ChartVersion string                 `json:"chart_version"`
+	Deployment   deploymentSpec         `json:"deployment"`
+	InputsSchema map[string]interface{} `json:"inputs_schema"` // JSON Schema of the chart values consumers can set
+	ReleaseNotes string                 `json:"release_notes"`
+	Status       string                 `json:"status"` // "draft" (default) or "in_review" to submit it right away
+	Plans        []planRequest          `json:"plans"`  // Pricing plans, billed at the hourly rate of the application if none
+}
+
+// AddApplicationVersion API to release a new version of an application (only for its publisher)
+func AddApplicationVersion(w http.ResponseWriter, r *http.Request) {
+	app, ok := ownedApplication(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	var req versionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := parseSemver(req.Version); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := jsonschema.Parse(req.InputsSchema); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Status == "" {
+		req.Status = VersionDraft
+	}
+	if req.Status != VersionDraft && req.Status != VersionInReview {
+		http.Error(w, "New versions must be draft or in_review, they are published once approved", http.StatusBadRequest)
+		return
+	}
+	plans, err := toPlans(req.Plans)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The deployment type is a property of the application, every version shares it
+	if req.Deployment.Type == "" {
+		req.Deployment.Type = app.Deployment.Type
+	}
+	if req.Deployment.Type != app.Deployment.Type {
+		http.Error(w, fmt.Sprintf("Deployment type must be %s like the other versions", app.Deployment.Type), http.StatusBadRequest)
+		return
+	}
+
+	var existing int64
+	database.DB.Model(&models.ApplicationVersion{}).Where("application_id = ? AND version = ?", app.ID, req.Version).Count(&existing)
+	if existing > 0 {
+		http.Error(w, fmt.Sprintf("Version %s already exists", req.Version), http.StatusConflict)
+		return
+	}
+
+	version := models.ApplicationVersion{
+		ApplicationID: app.ID,
+		Version:       req.Version,
+		ChartVersion:  req.ChartVersion,
+		Deployment:    models.DeploymentSpec(req.Deployment),
+		InputsSchema:  req.InputsSchema,
+		ReleaseNotes:  req.ReleaseNotes,
+		Status:        VersionDraft,
+		Plans:         plans,
+	}
+
+	// Versions failing chart verification are kept as drafts, the report tells why
+	verified := req.Status != VersionInReview || verifyChart(r.Context(), &version)
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&version).Error; err != nil {
+			return err
+		}
+		if req.Status == VersionInReview && verified {
+			return setVersionStatus(tx, &version, VersionInReview, middleware.UserID(r), "")
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Failed to add application version", http.StatusInternalServerError)
+		return
+	}
+
+	redactVersion(&version)
+	audit.Resource(r, "application_version", version.ID)
+	audit.Changes(r, nil, version)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(version)
+}
+
+// ListApplicationVersions API to list the versions of an application, newest first
+func ListApplicationVersions(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	app, ok := visibleApplication(w, r, id)
+	if !ok {
+		return
+	}
+
+	query := database.DB.Preload("Plans").Where("application_id = ?", app.ID)
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	// Versions that weren't approved are only shown to their publisher
+	if !middleware.Owns(r, app.PublisherID) {
+		query = query.Where("status IN ?", publicVersionStatuses)
+	}
+
+	var versions []models.ApplicationVersion
+	if err := query.Find(&versions).Error; err != nil {
+		http.Error(w, "Failed to fetch application versions", http.StatusInternalServerError)
+		return
+	}
+	sortVersions(versions)
+	for i := range versions {
+		redactVersion(&versions[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// GetApplicationVersion API to get a version of an application
+func GetApplicationVersion(w http.ResponseWriter, r *http.Request) {
+	app, ok := visibleApplication(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	version, err := findVersion(database.DB.Preload("Plans"), chi.URLParam(r, "id"), chi.URLParam(r, "versionID"))
+	if err != nil || !middleware.Owns(r, app.PublisherID) && !isPublicVersionStatus(version.Status) {
+		http.Error(w, "Application version not found", http.StatusNotFound)
+		return
+	}
+
+	redactVersion(&version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version)
+}
+
+// UpdateApplicationVersionStatus API for the publisher to submit a version for
+// review ("in_review"), withdraw it ("draft") or deprecate and un-deprecate it
+// once approved. The deployment spec of a version cannot be changed, release a
+// new version instead.
+func UpdateApplicationVersionStatus(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Status  string `json:"status"`
+		Comment string `json:"comment"` // Note for the reviewers when submitting
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := ownedApplication(w, r, chi.URLParam(r, "id")); !ok {
+		return
+	}
+
+	// The chart is verified before the version reaches the reviewers
+	if req.Status == VersionInReview {
+		version, err := findVersion(database.DB, chi.URLParam(r, "id"), chi.URLParam(r, "versionID"))
+		if err != nil {
+			http.Error(w, "Application version not found", http.StatusNotFound)
+			return
+		}
+		if version.Status != VersionInReview && canTransitionVersion(version.Status, VersionInReview) {
+			verified := verifyChart(r.Context(), &version)
+			if err := saveVerification(version); err != nil {
+				http.Error(w, "Failed to save verification report", http.StatusInternalServerError)
+				return
+			}
+			if !verified {
+				writeVerificationFailure(w, version)
+				return
+			}
+		}
+	}
+
+	var before, version models.ApplicationVersion
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		version, err = findVersion(tx, chi.URLParam(r, "id"), chi.URLParam(r, "versionID"))
+		if err != nil {
+			return err
+		}
+		before = version
+		if version.Status == req.Status {
+			return nil
+		}
+		if isReviewDecision(version.Status, req.Status) {
+			return errReviewDecision
+		}
+		return setVersionStatus(tx, &version, req.Status, middleware.UserID(r), req.Comment)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrVersionNotFound):
+			http.Error(w, "Application version not found", http.StatusNotFound)
+		case errors.Is(err, errReviewDecision):
+			http.Error(w, "Versions in review are approved or rejected by admins", http.StatusForbidden)
+		case errors.Is(err, ErrInvalidVersionTransition):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "Failed to update application version", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	redactVersion(&before)
+	redactVersion(&version)
+	audit.Changes(r, before, version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version)
+}
+
+// ResolveVersion returns the version of an application a new deployment is
+// pinned to: versionID if given, the latest published version otherwise. Only
+// published versions can be deployed.
+func ResolveVersion(db *gorm.DB, applicationID uint, versionID uint) (models.ApplicationVersion, error) {
+	if versionID != 0 {
+		version, err := findVersion(db, fmt.Sprintf("%d", applicationID), fmt.Sprintf("%d", versionID))
+		if err != nil {
+			return version, err
+		}
+		if version.Status != VersionPublished {
+			return version, fmt.Errorf("%w: %s is %s", ErrVersionNotPublished, version.Version, version.Status)
+		}
+		return version, nil
+	}
+
+	latest, err := latestPublishedVersion(db, applicationID)
+	if err != nil {
+		return latest, err
+	}
+	if latest.ID == 0 {
+		return latest, fmt.Errorf("%w: application has no published version", ErrVersionNotPublished)
+	}
+	return latest, nil
+}
+
+func findVersion(db *gorm.DB, applicationID, versionID string) (models.ApplicationVersion, error) {
+	var version models.ApplicationVersion
+	err := db.Where("application_id = ?", applicationID).First(&version, versionID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return version, ErrVersionNotFound
+	}
+	return version, err
+}
+
+// latestPublishedVersion returns the highest published version of an
+// application, or a zero version if none is published
+func latestPublishedVersion(db *gorm.DB, applicationID uint) (models.ApplicationVersion, error) {
+	var versions []models.ApplicationVersion
+	if err := db.Where("application_id = ? AND status = ?", applicationID, VersionPublished).Find(&versions).Error; err != nil {
+		return models.ApplicationVersion{}, err
+	}
+	if len(versions) == 0 {
+		return models.ApplicationVersion{}, nil
+	}
+	sortVersions(versions)
+	return versions[0], nil
+}
+
+// syncLatestVersion copies the spec of the latest published version onto the
+// application, which is what the catalog lists and filters on
+func syncLatestVersion(tx *gorm.DB, applicationID uint) error {
+	latest, err := latestPublishedVersion(tx, applicationID)
+	if err != nil || latest.ID == 0 {
+		return err
+	}
+
+	return tx.Model(&models.Application{ID: applicationID}).
+		Select("type", "repo_url", "chart_name", "image", "cpu", "memory", "inputs").
+		Updates(&models.Application{
+			Deployment: latest.Deployment,
+			Inputs:     latest.InputsSchema,
+		}).Error
+}
+
+// sortVersions sorts versions by semantic version, newest first
+func sortVersions(versions []models.ApplicationVersion) {
+	sort.Slice(versions, func(i, j int) bool {
+		return compareSemver(versions[i].Version, versions[j].Version) > 0
+	})
+}
+
+func canTransitionVersion(from, to string) bool {
+	for _, allowed := range versionTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
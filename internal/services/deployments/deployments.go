@@ -2,19 +2,36 @@ package deployments
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
 	"github.com/Vinayakatk/marketplace-prototype/internal/queue"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/audit"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/billing"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/catalog"
 	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/deprovisioner"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/lifecycle"
 	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/provisioner"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/secrets"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/orgs"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/quotas"
 	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/envelope"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/jsonschema"
 	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
 	"github.com/go-chi/chi/v5"
 	"gorm.io/gorm"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// APIActor is recorded on the deployment events caused by API calls
+const APIActor = "api"
+
+var errQuotaExceeded = errors.New("quota exceeded")
+
 type deploymentResponse struct {
 	ID          uint `json:"id"`
 	Application struct {
@@ -22,19 +39,77 @@ type deploymentResponse struct {
 		Name        string `json:"name"`
 		Description string `json:"description"`
 	} `json:"application"`
-	DeploymentType string `json:"deployment_type"`
-	ClusterName    string `json:"cluster_name,omitempty"`
-	VMName         string `json:"vm_name,omitempty"`
-	VMIP           string `json:"vm_ip,omitempty"`
-	Status         string `json:"status"`
+	Version        string     `json:"version,omitempty"` // Application version the deployment is pinned to
+	DeploymentType string     `json:"deployment_type"`
+	ClusterName    string     `json:"cluster_name,omitempty"`
+	VMName         string     `json:"vm_name,omitempty"`
+	VMIP           string     `json:"vm_ip,omitempty"`
+	Status         string     `json:"status"`
+	UninstalledAt  *time.Time `json:"uninstalled_at,omitempty"`
+
+	Trial *trialResponse `json:"trial,omitempty"` // Set for deployments started under a free trial
+
+	Values map[string]interface{} `json:"values,omitempty"` // Chart values, secret ones masked
+}
+
+type trialResponse struct {
+	EndsAt            time.Time `json:"ends_at"`
+	ConvertAfterTrial bool      `json:"convert_after_trial"`
+	Outcome           string    `json:"outcome,omitempty"` // "converted" or "expired" once the trial ended
+}
+
+// IncludeUninstalled reports whether a listing should also return uninstalled
+// deployments, which are soft-deleted and hidden unless ?include=uninstalled is set
+func IncludeUninstalled(r *http.Request) bool {
+	for _, include := range strings.Split(r.URL.Query().Get("include"), ",") {
+		if strings.TrimSpace(include) == lifecycle.Uninstalled {
+			return true
+		}
+	}
+	return false
+}
+
+// toResponse maps a deployment to its response DTO, excluding Consumer & Project
+func toResponse(deployment models.Deployment) deploymentResponse {
+	response := deploymentResponse{
+		ID: deployment.ID,
+		Application: struct {
+			ID          uint   `json:"id"`
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		}{
+			ID:          deployment.Application.ID,
+			Name:        deployment.Application.Name,
+			Description: deployment.Application.Description,
+		},
+		Version:        deployment.Version.Version,
+		DeploymentType: deployment.DeploymentType,
+		ClusterName:    deployment.ClusterName,
+		VMName:         deployment.VMName,
+		VMIP:           deployment.VMIP,
+		Status:         deployment.Status,
+		UninstalledAt:  deployment.UninstalledAt,
+	}
+	if deployment.TrialEndsAt != nil {
+		response.Trial = &trialResponse{
+			EndsAt:            *deployment.TrialEndsAt,
+			ConvertAfterTrial: deployment.ConvertAfterTrial,
+			Outcome:           deployment.TrialOutcome,
+		}
+	}
+	return response
 }
 
 // DeployApplication API (only for consumers)
 func DeployApplication(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		ConsumerID    uint `json:"consumer_id"`
-		ApplicationID uint `json:"application_id"`
-		ProjectID     uint `json:"project_id"`
+		ApplicationID        uint                   `json:"application_id"`
+		ApplicationVersionID uint                   `json:"application_version_id"` // Latest published version if omitted
+		ProjectID            uint                   `json:"project_id"`
+		PricingPlanID        uint                   `json:"pricing_plan_id"`     // Required if the version offers several plans
+		Values               map[string]interface{} `json:"values"`              // Chart values, validated against the inputs schema of the version
+		Trial                bool                   `json:"trial"`               // Start under the free trial of the application
+		ConvertAfterTrial    bool                   `json:"convert_after_trial"` // Keep it on its paid plan once the trial ends, uninstalled otherwise
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -42,12 +117,16 @@ func DeployApplication(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate Project Exists
+	// Validate Project Exists and the caller may deploy into it
 	var project models.Project
 	if err := database.DB.First(&project, req.ProjectID).Error; err != nil {
 		http.Error(w, "Project not found", http.StatusNotFound)
 		return
 	}
+	if !orgs.CanManageProject(r, project) {
+		middleware.Forbidden(w)
+		return
+	}
 
 	// Fetch application details
 	var app models.Application
@@ -56,84 +135,167 @@ func DeployApplication(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if the consumer exists
-	var publisher models.User
-	if err := database.DB.First(&publisher, req.ConsumerID).Error; err != nil {
-		http.Error(w, "Publisher not found", http.StatusBadRequest)
+	// Consumers get one free trial per application
+	if req.Trial {
+		if app.TrialDays == 0 {
+			http.Error(w, "Application has no free trial", http.StatusBadRequest)
+			return
+		}
+		var trials int64
+		if err := database.DB.Unscoped().Model(&models.Deployment{}).
+			Where("consumer_id = ? AND application_id = ? AND trial_ends_at IS NOT NULL", middleware.UserID(r), app.ID).
+			Count(&trials).Error; err != nil {
+			http.Error(w, "Failed to check previous trials", http.StatusInternalServerError)
+			return
+		}
+		if trials > 0 {
+			http.Error(w, "Free trial of this application already used", http.StatusConflict)
+			return
+		}
+	}
+
+	// Pin the deployment to a published version
+	version, err := catalog.ResolveVersion(database.DB, app.ID, req.ApplicationVersionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, catalog.ErrVersionNotFound):
+			http.Error(w, "Application version not found", http.StatusNotFound)
+		case errors.Is(err, catalog.ErrVersionNotPublished):
+			http.Error(w, fmt.Sprintf("Cannot deploy: %v", err), http.StatusConflict)
+		default:
+			http.Error(w, "Failed to resolve application version", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	// Initialize Deployment
-	deployment := models.Deployment{
-		ConsumerID:     req.ConsumerID,
-		ApplicationID:  req.ApplicationID,
-		ProjectID:      req.ProjectID,
-		DeploymentType: app.Deployment.Type,
-		Status:         "pending", // Initial status
+	// Bill it on one of the pricing plans of the version
+	plan, err := catalog.ResolvePlan(database.DB, version, req.PricingPlanID)
+	if err != nil {
+		switch {
+		case errors.Is(err, catalog.ErrPlanNotFound):
+			http.Error(w, "Pricing plan not found", http.StatusNotFound)
+		case errors.Is(err, catalog.ErrPlanRequired):
+			http.Error(w, fmt.Sprintf("Version %s offers several pricing plans, pick one with pricing_plan_id", version.Version), http.StatusBadRequest)
+		default:
+			http.Error(w, "Failed to resolve pricing plan", http.StatusInternalServerError)
+		}
+		return
 	}
 
-	// Store Deployment Record (Initial Status)
-	if err := database.DB.Create(&deployment).Error; err != nil {
-		http.Error(w, "Failed to save deployment record", http.StatusInternalServerError)
+	values, secret, ok := validateValues(w, version, req.Values)
+	if !ok {
 		return
 	}
 
-	// Push to Redis Queue for Asynchronous Processing
-	err := queue.PushToInstallerQueue(provisioner.InstallRequest{
-		DeploymentID:  fmt.Sprintf("%d", deployment.ID),
-		ConsumerID:    fmt.Sprintf("%d", req.ConsumerID),
-		ApplicationID: fmt.Sprintf("%d", req.ApplicationID),
-		Application:   app.Name,
-		DeployType:    app.Deployment.Type,
-		RepoURL:       app.Deployment.RepoURL,
-		ChartName:     app.Deployment.ChartName,
-		Inputs:        app.Inputs,
+	// Initialize Deployment
+	deployment := models.Deployment{
+		ConsumerID:           middleware.UserID(r), // The caller consumes the deployment
+		ApplicationID:        req.ApplicationID,
+		ApplicationVersionID: &version.ID,
+		ProjectID:            req.ProjectID,
+		DeploymentType:       version.Deployment.Type,
+		Values:               values,
+		Status:               lifecycle.Pending, // Initial status
+	}
+	if plan != nil {
+		deployment.PricingPlanID = &plan.ID
+	}
+	if req.Trial {
+		endsAt := time.Now().Add(time.Duration(app.TrialDays) * 24 * time.Hour)
+		deployment.TrialEndsAt = &endsAt
+		deployment.ConvertAfterTrial = req.ConvertAfterTrial
+	}
+
+	// Store Deployment Record (Initial Status) together with its encrypted secret
+	// values and its install job, which the outbox relay publishes for asynchronous
+	// processing once committed. The job only carries the plain values.
+	// The quotas of the caller, the project and its organization are checked in the
+	// same transaction, which holds their locks until the deployment is counted
+	var violations []quotas.Violation
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if violations, err = quotas.Check(tx, project, deployment.ConsumerID, version.Deployment); err != nil {
+			return err
+		}
+		if len(violations) > 0 {
+			return errQuotaExceeded
+		}
+		if err := tx.Create(&deployment).Error; err != nil {
+			return err
+		}
+		if err := secrets.Store(tx, deployment.ID, secret); err != nil {
+			return err
+		}
+		return queue.PushToInstallerQueue(tx, provisioner.NewInstallRequest(deployment, app, version))
 	})
 	if err != nil {
-		http.Error(w, "Failed to queue deployment", http.StatusInternalServerError)
+		if errors.Is(err, errQuotaExceeded) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":      "Quota exceeded",
+				"violations": violations,
+			})
+			return
+		}
+		if errors.Is(err, envelope.ErrNotConfigured) {
+			http.Error(w, "Secret values cannot be stored, secrets are not configured", http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, "Failed to save deployment record", http.StatusInternalServerError)
 		return
 	}
 
+	audit.Resource(r, "deployment", deployment.ID)
+
 	// Return Deployment ID
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	response := map[string]interface{}{
 		"message":      "Deployment request queued",
 		"deploymentID": deployment.ID,
-	})
+		"version":      version.Version,
+	}
+	if plan != nil {
+		response["pricing_plan"] = plan.Name
+	}
+	if deployment.TrialEndsAt != nil {
+		response["trial_ends_at"] = deployment.TrialEndsAt
+	}
+	json.NewEncoder(w).Encode(response)
 }
 
 func GetDeployment(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id") // Get deployment ID from URL
 
+	// Uninstalled deployments can still be looked up by ID
 	var deployment models.Deployment
-	if err := database.DB.
+	if err := database.DB.Unscoped().
 		Preload("Application", func(db *gorm.DB) *gorm.DB {
 			// Preload only the fields of Application you want (exclude Publisher)
 			return db.Select("id, name, description")
 		}).
-		Select("id, application_id, deployment_type, cluster_name, vm_name, vm_ip, status").
+		Preload("Version", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id, version")
+		}).
+		Select("id, consumer_id, project_id, application_id, application_version_id, deployment_type, cluster_name, vm_name, vm_ip, status, uninstalled_at, values, trial_ends_at, convert_after_trial, trial_outcome").
 		First(&deployment, id).Error; err != nil {
 		http.Error(w, "Deployment not found", http.StatusNotFound)
 		return
 	}
+	if !orgs.CanViewDeployment(r, deployment) {
+		middleware.Forbidden(w)
+		return
+	}
 
-	// Define response DTO to exclude Consumer & Project
-	response := deploymentResponse{
-		ID: deployment.ID,
-		Application: struct {
-			ID          uint   `json:"id"`
-			Name        string `json:"name"`
-			Description string `json:"description"`
-		}{
-			ID:          deployment.Application.ID,
-			Name:        deployment.Application.Name,
-			Description: deployment.Application.Description,
-		},
-		DeploymentType: deployment.DeploymentType,
-		ClusterName:    deployment.ClusterName,
-		VMName:         deployment.VMName,
-		VMIP:           deployment.VMIP,
-		Status:         deployment.Status,
+	// Secret values are never decrypted for responses, only shown as set
+	fields, err := secrets.Fields(database.DB, deployment.ID)
+	if err != nil {
+		http.Error(w, "Failed to fetch deployment secrets", http.StatusInternalServerError)
+		return
+	}
+	response := toResponse(deployment)
+	if len(deployment.Values) > 0 || len(fields) > 0 {
+		response.Values = secrets.MaskValues(deployment.Values, fields)
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -147,36 +309,83 @@ func DeleteDeployment(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Deployment not found", http.StatusNotFound)
 		return
 	}
+	if !orgs.CanManageDeployment(r, deployment) {
+		middleware.Forbidden(w)
+		return
+	}
 
-	// Queue a delete message
-	queue.PushToUninstallerQueue(deprovisioner.UninstallRequest{
-		DeploymentID:   id,
-		DeploymentType: deployment.DeploymentType,
-		ClusterName:    deployment.ClusterName,
-		VMName:         deployment.VMName,
+	// Move to "uninstalling", stop billing and queue a delete message atomically
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lifecycle.Transition(tx, id, lifecycle.Uninstalling, APIActor, "Deletion requested"); err != nil {
+			return err
+		}
+		if err := billing.Stop(tx, id, time.Now(), APIActor, "Deletion requested"); err != nil {
+			return err
+		}
+		return queue.PushToUninstallerQueue(tx, deprovisioner.UninstallRequest{
+			DeploymentID:   id,
+			DeploymentType: deployment.DeploymentType,
+			ClusterName:    deployment.ClusterName,
+			VMName:         deployment.VMName,
+		})
 	})
+	if err != nil {
+		if errors.Is(err, lifecycle.ErrInvalidTransition) {
+			http.Error(w, fmt.Sprintf("Cannot delete a deployment that is %s", deployment.Status), http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to update deployment status", http.StatusInternalServerError)
+		return
+	}
 
-	// Fetch Billing Record
-	var billing models.BillingRecord
-	if err := database.DB.Where("deployment_id = ?", id).First(&billing).Error; err != nil {
-		http.Error(w, "Billing record not found", http.StatusNotFound)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UpdateTrial API to choose whether a deployment is kept on its paid plan or
+// uninstalled when its free trial ends
+func UpdateTrial(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ConvertAfterTrial *bool `json:"convert_after_trial"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ConvertAfterTrial == nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
 
-	// Calculate final amount
-	endTime := time.Now()
-	totalHours := endTime.Sub(billing.StartTime).Hours()
-	totalCost := totalHours * billing.HourlyRate
+	id := chi.URLParam(r, "id")
+	var deployment models.Deployment
+	if err := database.DB.First(&deployment, id).Error; err != nil {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+		return
+	}
+	if !orgs.CanManageDeployment(r, deployment) {
+		middleware.Forbidden(w)
+		return
+	}
+	if deployment.TrialEndsAt == nil {
+		http.Error(w, "Deployment was not started under a free trial", http.StatusBadRequest)
+		return
+	}
 
-	// Update Billing Record with final amount
-	billing.EndTime = &endTime
-	billing.Amount = totalCost
-	if err := database.DB.Save(&billing).Error; err != nil {
-		http.Error(w, "Failed to save billing record", http.StatusInternalServerError)
+	// Only while the trial runs, the trials job may be ending it
+	result := database.DB.Model(&models.Deployment{}).
+		Where("id = ? AND trial_outcome = ''", deployment.ID).
+		Update("convert_after_trial", *req.ConvertAfterTrial)
+	if result.Error != nil {
+		http.Error(w, "Failed to update trial", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Free trial already ended", http.StatusConflict)
 		return
 	}
+	before := toResponse(deployment).Trial
+	deployment.ConvertAfterTrial = *req.ConvertAfterTrial
+	trial := toResponse(deployment).Trial
+	audit.Changes(r, before, trial)
 
-	w.WriteHeader(http.StatusNoContent)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trial)
 }
 
 // ListUserDeployments API to list deployments of a user
@@ -188,34 +397,58 @@ func ListUserDeployments(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid user ID", http.StatusBadRequest)
 		return
 	}
+	if !middleware.Owns(r, uint(userID)) {
+		middleware.Forbidden(w)
+		return
+	}
 
-	// Get the 'status' query parameter (optional)
-	status := r.URL.Query().Get("status")
+	// Query deployments where the ConsumerID matches the userID
+	listDeployments(w, r, func(db *gorm.DB) *gorm.DB {
+		return db.Where("consumer_id = ?", userID)
+	})
+}
 
-	// Define the allowed statuses for validation
-	validStatuses := []string{"installing", "installed", "failed", "pending"}
-	isValidStatus := false
-	for _, s := range validStatuses {
-		if status == s {
-			isValidStatus = true
-			break
-		}
+// ListOrganizationDeployments API to list the deployments in the projects of an organization
+func ListOrganizationDeployments(w http.ResponseWriter, r *http.Request) {
+	organization, ok := orgs.MemberOrganization(w, r, orgs.RoleViewer)
+	if !ok {
+		return
 	}
 
+	listDeployments(w, r, func(db *gorm.DB) *gorm.DB {
+		return db.Where("project_id IN (?)", database.DB.Model(&models.Project{}).
+			Select("id").
+			Where("organization_id = ?", organization.ID))
+	})
+}
+
+// listDeployments writes the deployments selected by scope, filtered with the
+// optional ?status and ?include=uninstalled query parameters
+func listDeployments(w http.ResponseWriter, r *http.Request, scope func(db *gorm.DB) *gorm.DB) {
+	// Get the 'status' query parameter (optional)
+	status := r.URL.Query().Get("status")
+
 	// If status is invalid, return a Bad Request response
-	if status != "" && !isValidStatus {
-		http.Error(w, "Invalid status. Valid statuses are: installing, installed, failed, pending", http.StatusBadRequest)
+	if status != "" && !lifecycle.IsValid(status) {
+		http.Error(w, fmt.Sprintf("Invalid status. Valid statuses are: %s", strings.Join(lifecycle.Statuses, ", ")), http.StatusBadRequest)
 		return
 	}
 
 	// Create a slice to hold the deployments
 	var deployments []models.Deployment
 
-	// Query deployments where the ConsumerID matches the userID, preloading the Application model
+	// Query the deployments, preloading the Application model
 	query := database.DB.Preload("Application", func(db *gorm.DB) *gorm.DB {
 		// Preload only necessary fields of the Application model
 		return db.Select("id, name, description")
-	}).Where("consumer_id = ?", userID)
+	}).Preload("Version", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id, version")
+	}).Scopes(scope)
+
+	// Uninstalled deployments are soft-deleted, include them when asked for
+	if IncludeUninstalled(r) || status == lifecycle.Uninstalled {
+		query = query.Unscoped()
+	}
 
 	// If a status is provided, filter by status
 	if status != "" {
@@ -224,33 +457,66 @@ func ListUserDeployments(w http.ResponseWriter, r *http.Request) {
 
 	// Execute the query to fetch the deployments
 	if err := query.Find(&deployments).Error; err != nil {
-		http.Error(w, "Error fetching user deployments", http.StatusInternalServerError)
+		http.Error(w, "Error fetching deployments", http.StatusInternalServerError)
 		return
 	}
 
 	// Prepare the response
 	response := make([]deploymentResponse, 0)
 	for _, deployment := range deployments {
-		response = append(response, deploymentResponse{
-			ID: deployment.ID,
-			Application: struct {
-				ID          uint   `json:"id"`
-				Name        string `json:"name"`
-				Description string `json:"description"`
-			}{
-				ID:          deployment.Application.ID,
-				Name:        deployment.Application.Name,
-				Description: deployment.Application.Description,
-			},
-			DeploymentType: deployment.DeploymentType,
-			ClusterName:    deployment.ClusterName,
-			VMName:         deployment.VMName,
-			VMIP:           deployment.VMIP,
-			Status:         deployment.Status,
-		})
+		response = append(response, toResponse(deployment))
 	}
 
 	// Return the deployments as JSON
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+// validateValues applies the defaults of the inputs schema of version to values
+// and validates the result, writing the field errors as response otherwise. The
+// values of secret inputs are split off from the plain ones, keyed by their path.
+func validateValues(w http.ResponseWriter, version models.ApplicationVersion, values map[string]interface{}) (map[string]interface{}, map[string]interface{}, bool) {
+	schema, err := jsonschema.Parse(version.InputsSchema)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Version %s has an invalid inputs schema", version.Version), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	merged, fieldErrors := schema.Apply(values)
+	if len(fieldErrors) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  "Invalid values",
+			"fields": fieldErrors,
+		})
+		return nil, nil, false
+	}
+
+	plain, secret := secrets.Split(merged, schema.SecretFields())
+	return plain, secret, true
+}
+
+// ListDeploymentEvents API to list the status history of a deployment
+func ListDeploymentEvents(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var deployment models.Deployment
+	if err := database.DB.Unscoped().Select("id", "consumer_id", "project_id").First(&deployment, id).Error; err != nil {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+		return
+	}
+	if !orgs.CanViewDeployment(r, deployment) {
+		middleware.Forbidden(w)
+		return
+	}
+
+	var events []models.DeploymentEvent
+	if err := database.DB.Where("deployment_id = ?", deployment.ID).Order("created_at, id").Find(&events).Error; err != nil {
+		http.Error(w, "Failed to fetch deployment events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
@@ -1,13 +1,19 @@
 package deprovisioner
 
 import (
+	"errors"
 	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/lifecycle"
 	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
 	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm"
 	"os/exec"
 	"time"
 )
 
+// UninstallerActor is recorded on the deployment events caused by uninstall jobs
+const UninstallerActor = "uninstaller"
+
 type UninstallRequest struct {
 	DeploymentID   string
 	DeploymentType string
@@ -60,7 +66,7 @@ func (v *VMCleaner) Clean() error {
 }
 
 // CleanResource selects the correct cleaner based on DeploymentType
-func CleanResource(req UninstallRequest) {
+func CleanResource(req UninstallRequest) error {
 	var cleaner ResourceCleaner
 
 	switch req.DeploymentType {
@@ -70,22 +76,45 @@ func CleanResource(req UninstallRequest) {
 		cleaner = &VMCleaner{VMName: req.VMName}
 	default:
 		fmt.Printf("⚠️ Unsupported deployment type: %s\n", req.DeploymentType)
-		return
+		return fmt.Errorf("unsupported deployment type: %s", req.DeploymentType)
 	}
 
 	// Execute cleanup
 	if err := cleaner.Clean(); err != nil {
 		fmt.Printf("❌ Failed to clean resource: %v\n", err)
+		if recordErr := lifecycle.Record(database.DB, req.DeploymentID, lifecycle.Uninstalling, UninstallerActor, fmt.Sprintf("Cleanup failed: %v", err)); recordErr != nil {
+			fmt.Printf("❌ Failed to record cleanup failure: %v\n", recordErr)
+			return errors.Join(err, fmt.Errorf("failed to record cleanup failure: %w", recordErr))
+		}
+		return err
 	}
 
+	// A deployment already gone was uninstalled by an earlier attempt, any other
+	// error fails the job so it is retried
 	var deployment models.Deployment
-	if err := database.DB.First(&deployment, req.DeploymentID).Error; err != nil {
+	err := database.DB.First(&deployment, req.DeploymentID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
 		fmt.Printf("deployment not found: %v\n", err)
-		return
+		return nil
+	}
+	if err != nil {
+		fmt.Printf("failed to load deployment %s: %v\n", req.DeploymentID, err)
+		return err
 	}
-	// Delete deployment from DB
-	if err := database.DB.Delete(&deployment).Error; err != nil {
-		fmt.Printf("failed to delete deployment: %v\n", err)
-		return
+
+	// Keep the deployment as an "uninstalled" record for audit and billing
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lifecycle.Transition(tx, req.DeploymentID, lifecycle.Uninstalled, UninstallerActor, ""); err != nil {
+			return err
+		}
+		if err := tx.Model(&deployment).Update("uninstalled_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&deployment).Error
+	})
+	if err != nil {
+		fmt.Printf("failed to mark deployment as uninstalled: %v\n", err)
+		return err
 	}
+	return nil
 }
@@ -0,0 +1,129 @@
+package lifecycle
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"strconv"
+)
+
+// Deployment statuses
+const (
+	Pending      = "pending"
+	Installing   = "installing"
+	Installed    = "installed"
+	Failed       = "failed"
+	Upgrading    = "upgrading"
+	Uninstalling = "uninstalling"
+	Uninstalled  = "uninstalled"
+)
+
+// Statuses lists every deployment status in lifecycle order
+var Statuses = []string{Pending, Installing, Installed, Failed, Upgrading, Uninstalling, Uninstalled}
+
+// transitions maps each status to the statuses a deployment may move to from it
+var transitions = map[string][]string{
+	Pending:      {Installing, Failed, Uninstalling},
+	Installing:   {Installed, Failed, Pending}, // Back to pending when a lost install is requeued
+	Installed:    {Upgrading, Uninstalling},
+	Failed:       {Installing, Upgrading, Uninstalling}, // Installing/upgrading again when the failed job is retried
+	Upgrading:    {Installed, Failed},
+	Uninstalling: {Uninstalled, Failed},
+	Uninstalled:  {}, // Terminal
+}
+
+var ErrInvalidTransition = errors.New("invalid deployment status transition")
+
+// TransitionError is returned when a deployment cannot move from its current status to the requested one
+type TransitionError struct {
+	DeploymentID string
+	From         string
+	To           string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("deployment %s cannot move from %q to %q", e.DeploymentID, e.From, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// IsValid reports whether status is a known deployment status
+func IsValid(status string) bool {
+	_, ok := transitions[status]
+	return ok
+}
+
+// CanTransition reports whether a deployment may move from one status to another
+func CanTransition(from, to string) bool {
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition moves the deployment to status to and records the change as a
+// deployment event, rejecting transitions the lifecycle doesn't allow. Moving to
+// the current status is a no-op. db may be a transaction.
+func Transition(db *gorm.DB, deploymentID, to, actor, message string) error {
+	return transition(db, deploymentID, to, actor, message, "")
+}
+
+// Fail moves the deployment to "failed", recording cause on the deployment event
+func Fail(db *gorm.DB, deploymentID, actor string, cause error) error {
+	return transition(db, deploymentID, Failed, actor, "", cause.Error())
+}
+
+func transition(db *gorm.DB, deploymentID, to, actor, message, errMsg string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var deployment models.Deployment
+		if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+			Select("id, status").
+			First(&deployment, deploymentID).Error; err != nil {
+			return err
+		}
+
+		if deployment.Status == to {
+			return nil
+		}
+		if !CanTransition(deployment.Status, to) {
+			return &TransitionError{DeploymentID: deploymentID, From: deployment.Status, To: to}
+		}
+
+		if err := tx.Model(&deployment).Update("status", to).Error; err != nil {
+			return err
+		}
+
+		log.Printf("🔀 Deployment %s: %s → %s (%s)", deploymentID, deployment.Status, to, actor)
+		return tx.Create(&models.DeploymentEvent{
+			DeploymentID: deployment.ID,
+			FromStatus:   deployment.Status,
+			ToStatus:     to,
+			Actor:        actor,
+			Message:      message,
+			Error:        errMsg,
+		}).Error
+	})
+}
+
+// Record adds a deployment event that doesn't change the status of the deployment
+func Record(db *gorm.DB, deploymentID, status, actor, message string) error {
+	id, err := strconv.ParseUint(deploymentID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid deployment id %q: %w", deploymentID, err)
+	}
+
+	return db.Create(&models.DeploymentEvent{
+		DeploymentID: uint(id),
+		FromStatus:   status,
+		ToStatus:     status,
+		Actor:        actor,
+		Message:      message,
+	}).Error
+}
@@ -2,6 +2,7 @@ package provisioner
 
 import (
 	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/secrets"
 	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/utils/helm"
 	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/utils/kubernetes"
 	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
@@ -14,32 +15,29 @@ type KubernetesProvisioner struct {
 	InstallReq InstallRequest
 }
 
+// Provision creates a KIND cluster and deploys the Helm chart on it. The
+// deployment status is moved by the caller depending on the result.
 func (kp *KubernetesProvisioner) Provision() error {
-	// Fetch Deployment Record
-	var deployment models.Deployment
-	if err := database.DB.First(&deployment, kp.InstallReq.DeploymentID).Error; err != nil {
-		log.Println("❌ Deployment not found:", err)
-		return err
-	}
 	clusterName := fmt.Sprintf("kind-cluster-%s-%s-%s", kp.InstallReq.ConsumerID, kp.InstallReq.ApplicationID, kp.InstallReq.DeploymentID)
 	log.Printf("🚀 Provisioning Kubernetes Cluster: %s", clusterName)
 
 	if err := kubernetes.CreateKindCluster(clusterName); err != nil {
-		// Update status to "failed"
-		database.DB.Model(&deployment).Update("status", "failed")
 		return fmt.Errorf("❌ failed to create KIND cluster: %w", err)
 	}
 
 	if err := switchKubeContext(clusterName); err != nil {
-		// Update status to "failed"
-		database.DB.Model(&deployment).Update("status", "failed")
 		return fmt.Errorf("❌ failed to switch context: %w", err)
 	}
 
-	if err := helm.DeployHelmChart(clusterName, kp.InstallReq.RepoURL, kp.InstallReq.ChartName, kp.InstallReq.Application, kp.InstallReq.ApplicationID); err != nil {
-		// Update status to "failed"
-		database.DB.Model(&deployment).Update("status", "failed")
-		return fmt.Errorf("❌ failed to deploy Helm chart: %w", err)
+	// Secret values are only decrypted right before they are handed to Helm
+	secret, err := secrets.Load(database.DB, kp.InstallReq.DeploymentID)
+	if err != nil {
+		return fmt.Errorf("❌ failed to load secret values: %w", err)
+	}
+	values := secrets.Merge(kp.InstallReq.Values, secret)
+
+	if err := helm.DeployHelmChart(clusterName, kp.InstallReq.RepoURL, kp.InstallReq.ChartName, kp.InstallReq.ChartVersion, kp.InstallReq.Application, kp.InstallReq.ApplicationID, values); err != nil {
+		return fmt.Errorf("❌ failed to deploy Helm chart: %w", secrets.RedactError(err, secret))
 	}
 
 	// Update deployment record
@@ -48,19 +46,6 @@ func (kp *KubernetesProvisioner) Provision() error {
 		return err
 	}
 
-	if err := database.DB.First(&deployment, kp.InstallReq.DeploymentID).Error; err != nil {
-		log.Println("❌ Deployment not found:", err)
-		return err
-	}
-
-	// Mark Deployment as Completed
-	if err := database.DB.Save(&deployment).Error; err != nil {
-		log.Println("❌ Failed to update deployment record:", err)
-	}
-
-	// Update status to "installed"
-	database.DB.Model(&deployment).Update("status", "installed")
-
 	return nil
 }
 
@@ -2,6 +2,7 @@ package provisioner
 
 import (
 	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
 )
 
 // InstallRequest represents a message in the queue
@@ -10,12 +11,31 @@ type InstallRequest struct {
 	ConsumerID    string
 	ApplicationID string
 	Application   string
+	Version       string // Application version the deployment is pinned to
 	DeployType    string
 	RepoURL       string
 	ChartName     string
-	Inputs        map[string]interface{}
+	ChartVersion  string
+	Values        map[string]interface{} // Chart values validated against the inputs schema, with defaults applied
 }
 
+// NewInstallRequest builds the install request of a deployment of version of app
+func NewInstallRequest(deployment models.Deployment, app models.Application, version models.ApplicationVersion) InstallRequest {
+	return InstallRequest{
+		DeploymentID:  fmt.Sprintf("%d", deployment.ID),
+		ConsumerID:    fmt.Sprintf("%d", deployment.ConsumerID),
+		ApplicationID: fmt.Sprintf("%d", deployment.ApplicationID),
+		Application:   app.Name,
+		Version:       version.Version,
+		DeployType:    version.Deployment.Type,
+		RepoURL:       version.Deployment.RepoURL,
+		ChartName:     version.Deployment.ChartName,
+		ChartVersion:  version.ChartVersion,
+		Values:        deployment.Values,
+	}
+}
+
+// Provisioner creates the resources of a deployment and records them on the deployment
 type Provisioner interface {
 	Provision() error
 }
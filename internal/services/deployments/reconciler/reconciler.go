@@ -0,0 +1,218 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/queue"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/billing"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/lifecycle"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/provisioner"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/utils/kubernetes"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/env"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"time"
+)
+
+// Actor is recorded on the deployment events written by the reconciler
+const Actor = "reconciler"
+
+// maxRequeues is how many times a deployment is requeued before it is marked failed
+const maxRequeues = 3
+
+// lockKey identifies the advisory lock that keeps instances from reconciling at the same time
+const lockKey = 727001
+
+// Start reconciles deployments right away and then every RECONCILE_INTERVAL until ctx is cancelled
+func Start(ctx context.Context) {
+	interval := env.Duration("RECONCILE_INTERVAL", 5*time.Minute)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := Reconcile(ctx); err != nil {
+			log.Println("❌ Reconciliation failed:", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Reconcile compares deployments stuck in "pending" or "installing" with the
+// queue and the actual resources, then requeues them, marks them failed or marks
+// them installed. Deployments changed within RECONCILE_GRACE are left alone as
+// their job may not have been queued or picked up yet.
+func Reconcile(ctx context.Context) error {
+	grace := env.Duration("RECONCILE_GRACE", 2*time.Minute)
+
+	// The lock is held by the connection rather than a transaction, so no
+	// transaction stays open while queues and clusters are inspected
+	return database.DB.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		var locked bool
+		if err := conn.Raw("SELECT pg_try_advisory_lock(?)", lockKey).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			log.Println("⏭️ Another instance is reconciling deployments, skipping")
+			return nil
+		}
+		defer func() {
+			// Released even when ctx is cancelled, the connection goes back to the pool
+			if err := conn.WithContext(context.WithoutCancel(ctx)).Exec("SELECT pg_advisory_unlock(?)", lockKey).Error; err != nil {
+				log.Println("❌ Failed to release the reconciliation lock:", err)
+			}
+		}()
+
+		var deployments []models.Deployment
+		if err := conn.Preload("Application").Preload("Version").
+			Where("status IN ?", []string{lifecycle.Pending, lifecycle.Installing}).
+			Where("updated_at IS NULL OR updated_at < ?", time.Now().Add(-grace)).
+			Find(&deployments).Error; err != nil {
+			return err
+		}
+		if len(deployments) == 0 {
+			return nil
+		}
+		log.Printf("🔍 Reconciling %d stuck deployment(s)", len(deployments))
+
+		queued, err := queue.QueuedDeployments(ctx, queue.InstallerQueue)
+		if err != nil {
+			return fmt.Errorf("failed to list queued installs: %w", err)
+		}
+
+		deadLettered := make(map[string]queue.DeadLetter)
+		if letters, err := queue.ListDeadLetters(ctx, queue.InstallerQueue, 1000); err != nil {
+			log.Println("⚠️ Failed to list dead-lettered installs:", err)
+		} else {
+			for _, letter := range letters {
+				deadLettered[letter.Payload["deployment_id"]] = letter
+			}
+		}
+
+		// A nil map means the clusters could not be listed; Kubernetes deployments
+		// are then only requeued or failed based on the queue
+		clusters, err := kindClusters()
+		if err != nil {
+			log.Println("⚠️ Skipping cluster checks:", err)
+		}
+
+		// Each deployment is corrected in its own transaction, a failure leaves the others alone
+		for _, deployment := range deployments {
+			if err := conn.Transaction(func(tx *gorm.DB) error {
+				if changed, err := changedSince(tx, deployment); err != nil || changed {
+					return err
+				}
+				return reconcileDeployment(ctx, tx, deployment, queued, deadLettered, clusters)
+			}); err != nil {
+				log.Printf("❌ Failed to reconcile deployment %d: %v", deployment.ID, err)
+			}
+		}
+		return nil
+	})
+}
+
+// changedSince locks a deployment and reports whether it changed since it was
+// found stuck, e.g. because its install job went on meanwhile
+func changedSince(tx *gorm.DB, deployment models.Deployment) (bool, error) {
+	var current models.Deployment
+	if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+		Select("id", "status", "updated_at").
+		First(&current, deployment.ID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return true, nil
+		}
+		return false, err
+	}
+	return current.Status != deployment.Status || !current.UpdatedAt.Equal(deployment.UpdatedAt), nil
+}
+
+func reconcileDeployment(ctx context.Context, tx *gorm.DB, deployment models.Deployment, queued map[string]bool, deadLettered map[string]queue.DeadLetter, clusters map[string]bool) error {
+	id := fmt.Sprintf("%d", deployment.ID)
+
+	// The install job is still waiting or being processed
+	if queued[id] {
+		return nil
+	}
+
+	if letter, ok := deadLettered[id]; ok {
+		return correct(tx, deployment, lifecycle.Failed, fmt.Sprintf("Install job was dead-lettered: %s", letter.LastError))
+	}
+
+	switch deployment.DeploymentType {
+	case "k8s":
+		// The cluster name is only recorded once the Helm chart was deployed
+		if clusters == nil {
+			break
+		}
+		if deployment.ClusterName != "" && clusters[deployment.ClusterName] {
+			return correct(tx, deployment, lifecycle.Installed, fmt.Sprintf("Cluster %s is running", deployment.ClusterName))
+		}
+	case "vm":
+		if deployment.VMName != "" {
+			return correct(tx, deployment, lifecycle.Installed, fmt.Sprintf("VM %s is provisioned", deployment.VMName))
+		}
+	}
+
+	var requeues int64
+	if err := tx.Model(&models.DeploymentEvent{}).
+		Where("deployment_id = ? AND actor = ? AND to_status = ?", deployment.ID, Actor, lifecycle.Pending).
+		Count(&requeues).Error; err != nil {
+		return err
+	}
+	if requeues >= maxRequeues {
+		return correct(tx, deployment, lifecycle.Failed, fmt.Sprintf("Install job was lost %d times, giving up", requeues+1))
+	}
+
+	if err := correct(tx, deployment, lifecycle.Pending, "Install job was lost, requeued"); err != nil {
+		return err
+	}
+	return queue.PushToInstallerQueue(tx, provisioner.NewInstallRequest(deployment, deployment.Application, deployment.Version))
+}
+
+// correct moves the deployment to status through the lifecycle, which records
+// the correction as a deployment event
+func correct(tx *gorm.DB, deployment models.Deployment, status, message string) error {
+	id := fmt.Sprintf("%d", deployment.ID)
+	log.Printf("🩹 Deployment %s: %s → %s (%s)", id, deployment.Status, status, message)
+
+	switch {
+	case status == deployment.Status:
+		return lifecycle.Record(tx, id, status, Actor, message)
+	case status == lifecycle.Failed:
+		return lifecycle.Fail(tx, id, Actor, errors.New(message))
+	case status == lifecycle.Installed && deployment.Status == lifecycle.Pending:
+		if err := lifecycle.Transition(tx, id, lifecycle.Installing, Actor, message); err != nil {
+			return err
+		}
+	}
+	if err := lifecycle.Transition(tx, id, status, Actor, message); err != nil {
+		return err
+	}
+
+	// The lost install job didn't get to start billing the deployment
+	if status == lifecycle.Installed {
+		return billing.Start(tx, id, Actor)
+	}
+	return nil
+}
+
+func kindClusters() (map[string]bool, error) {
+	names, err := kubernetes.ListKindClusters()
+	if err != nil {
+		return nil, err
+	}
+
+	clusters := make(map[string]bool, len(names))
+	for _, name := range names {
+		clusters[name] = true
+	}
+	return clusters, nil
+}
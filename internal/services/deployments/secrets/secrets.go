@@ -0,0 +1,323 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/envelope"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Mask replaces secret values in API responses and error messages
+const Mask = "********"
+
+// minRedactLength keeps short secret values from masking unrelated text in errors
+const minRedactLength = 4
+
+// Split separates the values at the dotted paths in fields from the plain values.
+// The secret values are keyed by their path; values is not modified.
+func Split(values map[string]interface{}, fields []string) (map[string]interface{}, map[string]interface{}) {
+	plain := clone(values)
+	secret := make(map[string]interface{})
+	for _, field := range fields {
+		parent, key := lookup(plain, field, false)
+		if parent == nil {
+			continue
+		}
+		if value, ok := parent[key]; ok {
+			secret[field] = value
+			delete(parent, key)
+		}
+	}
+	return plain, secret
+}
+
+// Merge returns values with the secret values set at their paths
+func Merge(values, secret map[string]interface{}) map[string]interface{} {
+	merged := clone(values)
+	for field, value := range secret {
+		parent, key := lookup(merged, field, true)
+		parent[key] = value
+	}
+	return merged
+}
+
+// Fill returns values with the secret values set at the paths values doesn't
+// set. Secret values below a path values set to something else than an object
+// are dropped with it.
+func Fill(values, secret map[string]interface{}) map[string]interface{} {
+	missing := make(map[string]interface{})
+	for field, value := range secret {
+		if isSet(values, field) {
+			continue
+		}
+		missing[field] = value
+	}
+	return Merge(values, missing)
+}
+
+// MaskValues returns values with the secret fields set to Mask
+func MaskValues(values map[string]interface{}, fields []string) map[string]interface{} {
+	masked := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		masked[field] = Mask
+	}
+	return Merge(values, masked)
+}
+
+// RedactError replaces the secret values mentioned in err, e.g. in the output of
+// a failed Helm command, so they don't end up in logs and deployment events
+func RedactError(err error, secret map[string]interface{}) error {
+	if err == nil || len(secret) == 0 {
+		return err
+	}
+
+	message := err.Error()
+	redacted := message
+	for _, value := range secret {
+		if s, ok := value.(string); ok && len(s) >= minRedactLength {
+			redacted = strings.ReplaceAll(redacted, s, Mask)
+		}
+	}
+	if redacted == message {
+		return err
+	}
+	return errors.New(redacted)
+}
+
+// Seal encrypts secret values, keyed by their path. No secret values seal to
+// the zero SealedSecret, without needing secrets to be configured.
+func Seal(secret map[string]interface{}) (models.SealedSecret, error) {
+	if len(secret) == 0 {
+		return models.SealedSecret{}, nil
+	}
+	keyring, err := envelope.Default()
+	if err != nil {
+		return models.SealedSecret{}, err
+	}
+	return seal(keyring, secret)
+}
+
+// Open decrypts sealed secret values, keyed by their path. It is only meant to
+// be called when the values are handed to Helm.
+func Open(sealed models.SealedSecret) (map[string]interface{}, error) {
+	if sealed.KeyID == "" {
+		return nil, nil
+	}
+	keyring, err := envelope.Default()
+	if err != nil {
+		return nil, err
+	}
+	return open(keyring, sealed)
+}
+
+// Store encrypts the secret values of a deployment, replacing the stored ones.
+// db may be a transaction.
+func Store(db *gorm.DB, deploymentID uint, secret map[string]interface{}) error {
+	sealed, err := Seal(secret)
+	if err != nil {
+		return err
+	}
+	return Activate(db, deploymentID, sealed)
+}
+
+// Activate replaces the stored secret values of a deployment with sealed ones,
+// e.g. those of a revision once it was deployed. db may be a transaction.
+func Activate(db *gorm.DB, deploymentID uint, sealed models.SealedSecret) error {
+	if sealed.KeyID == "" {
+		return db.Where("deployment_id = ?", deploymentID).Delete(&models.DeploymentSecret{}).Error
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "deployment_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"fields", "key_id", "wrapped_key", "ciphertext", "updated_at"}),
+	}).Create(&models.DeploymentSecret{
+		DeploymentID: deploymentID,
+		Secret:       sealed,
+	}).Error
+}
+
+// Load decrypts the secret values of a deployment, keyed by their path. It is
+// only meant to be called when the values are handed to Helm.
+func Load(db *gorm.DB, deploymentID string) (map[string]interface{}, error) {
+	sealed, err := Sealed(db, deploymentID)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := Open(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secret values of deployment %s: %w", deploymentID, err)
+	}
+	return secret, nil
+}
+
+// Sealed returns the stored secret values of a deployment without decrypting
+// them, e.g. to record them on a revision
+func Sealed(db *gorm.DB, deploymentID string) (models.SealedSecret, error) {
+	var stored models.DeploymentSecret
+	err := db.Where("deployment_id = ?", deploymentID).First(&stored).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.SealedSecret{}, nil
+	}
+	return stored.Secret, err
+}
+
+// Fields returns the paths of the secret values stored for a deployment without decrypting them
+func Fields(db *gorm.DB, deploymentID uint) ([]string, error) {
+	var stored models.DeploymentSecret
+	err := db.Select("fields").Where("deployment_id = ?", deploymentID).First(&stored).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return stored.Secret.Fields, err
+}
+
+// Rotate rewraps the data keys of every deployment and revision secret that is
+// not wrapped by the active master key yet, and returns how many were rewrapped
+func Rotate(db *gorm.DB) (int, error) {
+	keyring, err := envelope.Default()
+	if err != nil {
+		return 0, err
+	}
+
+	var stale []models.DeploymentSecret
+	if err := db.Where("key_id <> ?", keyring.ActiveKeyID()).Find(&stale).Error; err != nil {
+		return 0, err
+	}
+	rotated := 0
+	for _, stored := range stale {
+		n, err := rewrap(db.Model(&stored), keyring, "", stored.Secret)
+		if err != nil {
+			return rotated, fmt.Errorf("failed to rewrap secret of deployment %d: %w", stored.DeploymentID, err)
+		}
+		rotated += n
+	}
+
+	var revisions []models.DeploymentRevision
+	if err := db.Where("secret_key_id <> '' AND secret_key_id <> ?", keyring.ActiveKeyID()).Find(&revisions).Error; err != nil {
+		return rotated, err
+	}
+	for _, revision := range revisions {
+		n, err := rewrap(db.Model(&revision), keyring, "secret_", revision.Secret)
+		if err != nil {
+			return rotated, fmt.Errorf("failed to rewrap secret of revision %d of deployment %d: %w", revision.Revision, revision.DeploymentID, err)
+		}
+		rotated += n
+	}
+
+	log.Printf("🔑 Rewrapped %d deployment secret(s) with master key %s", rotated, keyring.ActiveKeyID())
+	return rotated, nil
+}
+
+// rewrap rewraps the data key of sealed in the row of model, whose columns
+// start with prefix, unless somebody replaced it meanwhile
+func rewrap(model *gorm.DB, keyring *envelope.Keyring, prefix string, sealed models.SealedSecret) (int, error) {
+	rewrapped, err := keyring.Rewrap(envelope.Envelope{
+		KeyID:      sealed.KeyID,
+		WrappedKey: sealed.WrappedKey,
+		Ciphertext: sealed.Ciphertext,
+	})
+	if err != nil {
+		return 0, err
+	}
+	result := model.Where(prefix+"key_id = ?", sealed.KeyID).Updates(map[string]interface{}{
+		prefix + "key_id":      rewrapped.KeyID,
+		prefix + "wrapped_key": rewrapped.WrappedKey,
+	})
+	return int(result.RowsAffected), result.Error
+}
+
+// seal encrypts secret values with keyring
+func seal(keyring *envelope.Keyring, secret map[string]interface{}) (models.SealedSecret, error) {
+	plaintext, err := json.Marshal(secret)
+	if err != nil {
+		return models.SealedSecret{}, err
+	}
+	sealed, err := keyring.Encrypt(plaintext)
+	if err != nil {
+		return models.SealedSecret{}, fmt.Errorf("failed to encrypt secret values: %w", err)
+	}
+
+	fields := make([]string, 0, len(secret))
+	for field := range secret {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	return models.SealedSecret{
+		Fields:     fields,
+		KeyID:      sealed.KeyID,
+		WrappedKey: sealed.WrappedKey,
+		Ciphertext: sealed.Ciphertext,
+	}, nil
+}
+
+// open decrypts secret values with keyring
+func open(keyring *envelope.Keyring, sealed models.SealedSecret) (map[string]interface{}, error) {
+	plaintext, err := keyring.Decrypt(envelope.Envelope{
+		KeyID:      sealed.KeyID,
+		WrappedKey: sealed.WrappedKey,
+		Ciphertext: sealed.Ciphertext,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var secret map[string]interface{}
+	if err := json.Unmarshal(plaintext, &secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// lookup returns the map holding the last segment of a dotted path and that
+// segment. Missing intermediate maps are created if create is set, otherwise a
+// nil map is returned.
+func lookup(values map[string]interface{}, path string, create bool) (map[string]interface{}, string) {
+	segments := strings.Split(path, ".")
+	current := values
+	for _, segment := range segments[:len(segments)-1] {
+		next, ok := current[segment].(map[string]interface{})
+		if !ok {
+			if !create {
+				return nil, ""
+			}
+			next = make(map[string]interface{})
+			current[segment] = next
+		}
+		current = next
+	}
+	return current, segments[len(segments)-1]
+}
+
+// isSet reports whether values set the dotted path, or a part of it to
+// something else than an object
+func isSet(values map[string]interface{}, path string) bool {
+	current := values
+	for _, segment := range strings.Split(path, ".") {
+		value, ok := current[segment]
+		if !ok {
+			return false
+		}
+		if current, ok = value.(map[string]interface{}); !ok {
+			return true
+		}
+	}
+	return true
+}
+
+// clone deep-copies the nested maps of values
+func clone(values map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		if nested, ok := value.(map[string]interface{}); ok {
+			value = clone(nested)
+		}
+		copied[key] = value
+	}
+	return copied
+}
@@ -0,0 +1,320 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/envelope"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/jsonschema"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// inputsSchema declares a secret input at the root and a nested one with a default
+const inputsSchema = `{
+	"type": "object",
+	"properties": {
+		"replicaCount": {"type": "integer", "default": 1},
+		"adminPassword": {"type": "string", "minLength": 8, "secret": true},
+		"auth": {
+			"type": "object",
+			"properties": {
+				"user": {"type": "string", "default": "admin"},
+				"token": {"type": "string", "secret": true, "default": "changeme"}
+			}
+		}
+	}
+}`
+
+// decode decodes JSON as it arrives in requests
+func decode(t *testing.T, raw string) map[string]interface{} {
+	t.Helper()
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// apply validates values like deployments do and splits off their secret values
+func apply(t *testing.T, schema *jsonschema.Schema, values map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	t.Helper()
+	merged, errs := schema.Apply(values)
+	if len(errs) > 0 {
+		t.Fatalf("Apply: %v", errs)
+	}
+	return Split(merged, schema.SecretFields())
+}
+
+func TestSplitMergeRoundTrip(t *testing.T) {
+	schema, err := jsonschema.Parse(decode(t, inputsSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	given := decode(t, `{"adminPassword": "hunter2hunter2", "auth": {"user": "ops"}}`)
+	plain, secret := apply(t, schema, given)
+
+	if want := decode(t, `{"replicaCount": 1, "auth": {"user": "ops"}}`); !reflect.DeepEqual(plain, want) {
+		t.Errorf("plain values = %v, want %v", plain, want)
+	}
+	if want := decode(t, `{"adminPassword": "hunter2hunter2", "auth.token": "changeme"}`); !reflect.DeepEqual(secret, want) {
+		t.Errorf("secret values = %v, want %v", secret, want)
+	}
+	if given["adminPassword"] != "hunter2hunter2" {
+		t.Error("Split modified the given values")
+	}
+
+	merged, _ := schema.Apply(given)
+	if got := Merge(plain, secret); !reflect.DeepEqual(got, merged) {
+		t.Errorf("Merge = %v, want the validated values %v", got, merged)
+	}
+	if _, ok := plain["adminPassword"]; ok {
+		t.Error("Merge modified the plain values")
+	}
+}
+
+func TestFillRoundTrip(t *testing.T) {
+	schema, err := jsonschema.Parse(decode(t, inputsSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, stored := apply(t, schema, decode(t, `{"adminPassword": "hunter2hunter2", "auth": {"token": "t0k3n"}}`))
+
+	tests := []struct {
+		name   string
+		values string // Values of the upgrade
+		plain  string
+		secret string
+	}{
+		{"secret values not set again are kept", `{"replicaCount": 3}`,
+			`{"replicaCount": 3, "auth": {"user": "admin"}}`, `{"adminPassword": "hunter2hunter2", "auth.token": "t0k3n"}`},
+		{"secret values set again replace the stored ones", `{"adminPassword": "correcthorse", "auth": {"token": "n3w"}}`,
+			`{"replicaCount": 1, "auth": {"user": "admin"}}`, `{"adminPassword": "correcthorse", "auth.token": "n3w"}`},
+		{"stored values win over defaults", `{"auth": {"user": "ops"}}`,
+			`{"replicaCount": 1, "auth": {"user": "ops"}}`, `{"adminPassword": "hunter2hunter2", "auth.token": "t0k3n"}`},
+		{"parent replaced by a value of another type", `{"auth": "none"}`, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := decode(t, tt.values)
+			filled := Fill(values, stored)
+			if !reflect.DeepEqual(values, decode(t, tt.values)) {
+				t.Error("Fill modified the values")
+			}
+
+			if tt.plain == "" {
+				// The stored secret is not put back under the string, which validation rejects
+				if filled["auth"] != "none" {
+					t.Errorf("Fill replaced auth by %v", filled["auth"])
+				}
+				if _, errs := schema.Apply(filled); len(errs) != 1 || errs[0].Field != "auth" {
+					t.Errorf("Apply(%v) = %v, want auth rejected", filled, errs)
+				}
+				return
+			}
+			plain, secret := apply(t, schema, filled)
+			if want := decode(t, tt.plain); !reflect.DeepEqual(plain, want) {
+				t.Errorf("plain values = %v, want %v", plain, want)
+			}
+			if want := decode(t, tt.secret); !reflect.DeepEqual(secret, want) {
+				t.Errorf("secret values = %v, want %v", secret, want)
+			}
+		})
+	}
+}
+
+// newKeyring returns a keyring of new random master keys, the first one active
+func newKeyring(t *testing.T, ids ...string) (*envelope.Keyring, string) {
+	t.Helper()
+	entries := make([]string, len(ids))
+	for i, id := range ids {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatal(err)
+		}
+		entries[i] = id + ":" + base64.StdEncoding.EncodeToString(key)
+	}
+	spec := strings.Join(entries, ",")
+	keyring, err := envelope.ParseKeyring(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring, spec
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	keyring, _ := newKeyring(t, "k1")
+	secret := decode(t, `{"adminPassword": "hunter2hunter2", "auth.token": "t0k3n", "tls": {"key": "-----BEGIN KEY-----"}}`)
+
+	sealed, err := seal(keyring, secret)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if want := []string{"adminPassword", "auth.token", "tls"}; !reflect.DeepEqual(sealed.Fields, want) {
+		t.Errorf("sealed fields = %v, want the sorted paths %v", sealed.Fields, want)
+	}
+	if sealed.KeyID != "k1" || bytes.Contains(sealed.Ciphertext, []byte("hunter2")) {
+		t.Errorf("sealed = key %s, ciphertext %q, want it encrypted with k1", sealed.KeyID, sealed.Ciphertext)
+	}
+
+	got, err := open(keyring, sealed)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if !reflect.DeepEqual(got, secret) {
+		t.Errorf("open = %v, want %v", got, secret)
+	}
+
+	tampered := sealed
+	tampered.Ciphertext = append([]byte{}, sealed.Ciphertext...)
+	tampered.Ciphertext[len(tampered.Ciphertext)-1] ^= 0x01
+	if _, err := open(keyring, tampered); err == nil {
+		t.Error("open of a tampered secret succeeded")
+	}
+	other, _ := newKeyring(t, "k1")
+	if _, err := open(other, sealed); err == nil {
+		t.Error("open with another master key of the same ID succeeded")
+	}
+}
+
+func TestSealNothing(t *testing.T) {
+	// Deployments without secret values don't need secrets to be configured
+	for _, secret := range []map[string]interface{}{nil, {}} {
+		sealed, err := Seal(secret)
+		if err != nil || !reflect.DeepEqual(sealed, models.SealedSecret{}) {
+			t.Errorf("Seal(%v) = %+v, %v, want nothing sealed", secret, sealed, err)
+		}
+	}
+	if got, err := Open(models.SealedSecret{}); got != nil || err != nil {
+		t.Errorf("Open of nothing = %v, %v, want no secret values", got, err)
+	}
+}
+
+// dryRun returns a database that builds statements without running them, and
+// the updates it was asked to run
+func dryRun(t *testing.T) (*gorm.DB, *[]*gorm.Statement) {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var updates []*gorm.Statement
+	if err := db.Callback().Update().After("gorm:update").Register("test:record", func(tx *gorm.DB) {
+		updates = append(updates, tx.Statement)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return db, &updates
+}
+
+func TestRewrapRotatesTheMasterKey(t *testing.T) {
+	_, oldSpec := newKeyring(t, "k1")
+	_, newSpec := newKeyring(t, "k2")
+	old, _ := envelope.ParseKeyring(oldSpec)
+	rotating, _ := envelope.ParseKeyring(newSpec + "," + oldSpec)
+	rotated, _ := envelope.ParseKeyring(newSpec)
+
+	secret := map[string]interface{}{"adminPassword": "hunter2hunter2"}
+	sealed, err := seal(old, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Secrets of deployments and of their revisions are rewrapped alike
+	tests := []struct {
+		name   string
+		model  interface{}
+		prefix string
+	}{
+		{"deployment secret", &models.DeploymentSecret{ID: 3}, ""},
+		{"revision secret", &models.DeploymentRevision{ID: 7}, "secret_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, updates := dryRun(t)
+			if _, err := rewrap(db.Model(tt.model), rotating, tt.prefix, sealed); err != nil {
+				t.Fatalf("rewrap: %v", err)
+			}
+			if len(*updates) != 1 {
+				t.Fatalf("ran %d updates, want 1", len(*updates))
+			}
+			stmt := (*updates)[0]
+			sql := stmt.SQL.String()
+			for _, want := range []string{`"` + tt.prefix + `key_id"=`, `"` + tt.prefix + `wrapped_key"=`, tt.prefix + "key_id = $"} {
+				if !strings.Contains(sql, want) {
+					t.Errorf("rewrapped with %s, want %s", sql, want)
+				}
+			}
+			if strings.Contains(sql, "ciphertext") {
+				t.Errorf("rewrapping rewrote the ciphertext: %s", sql)
+			}
+
+			// Only rewrapped if still wrapped by the old key, and readable without it afterwards
+			var keyIDs []string
+			var wrapped []byte
+			for _, v := range stmt.Vars {
+				switch v := v.(type) {
+				case string:
+					keyIDs = append(keyIDs, v)
+				case []byte:
+					wrapped = v
+				}
+			}
+			sort.Strings(keyIDs)
+			if !reflect.DeepEqual(keyIDs, []string{"k1", "k2"}) {
+				t.Errorf("rewrapped with key IDs %v, want k2 where k1", keyIDs)
+			}
+			got, err := open(rotated, models.SealedSecret{KeyID: "k2", WrappedKey: wrapped, Ciphertext: sealed.Ciphertext})
+			if err != nil || !reflect.DeepEqual(got, secret) {
+				t.Errorf("open after the rotation = %v, %v, want %v", got, err, secret)
+			}
+		})
+	}
+
+	if _, err := open(rotated, sealed); !errors.Is(err, envelope.ErrUnknownKey) {
+		t.Errorf("open of a secret that was not rewrapped = %v, want %v", err, envelope.ErrUnknownKey)
+	}
+}
+
+func TestMaskValues(t *testing.T) {
+	values := decode(t, `{"replicaCount": 2, "auth": {"user": "ops"}}`)
+	masked := MaskValues(values, []string{"adminPassword", "auth.token"})
+
+	want := decode(t, `{"replicaCount": 2, "adminPassword": "********", "auth": {"user": "ops", "token": "********"}}`)
+	if !reflect.DeepEqual(masked, want) {
+		t.Errorf("MaskValues = %v, want %v", masked, want)
+	}
+	if _, ok := values["adminPassword"]; ok {
+		t.Error("MaskValues modified the values")
+	}
+	if got := MaskValues(values, nil); !reflect.DeepEqual(got, values) {
+		t.Errorf("MaskValues without secret fields = %v, want the values", got)
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	secret := map[string]interface{}{"adminPassword": "hunter2hunter2", "pin": "123", "replicas": 3}
+	failure := errors.New(`helm upgrade failed: --set adminPassword=hunter2hunter2 pin=123`)
+
+	got := RedactError(failure, secret)
+	if want := `helm upgrade failed: --set adminPassword=******** pin=123`; got.Error() != want {
+		t.Errorf("RedactError = %q, want %q", got, want)
+	}
+
+	// Errors mentioning no secret value are returned as they are, so they can still be matched
+	plain := errors.New("cluster not found")
+	if got := RedactError(plain, secret); got != plain {
+		t.Errorf("RedactError = %v, want the error itself", got)
+	}
+	if RedactError(nil, secret) != nil || RedactError(plain, nil) != plain {
+		t.Error("RedactError changed a nil error or an error without secret values")
+	}
+}
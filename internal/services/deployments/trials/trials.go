@@ -0,0 +1,184 @@
+package trials
+
+import (
+	"context"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/queue"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/audit"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/billing"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/deprovisioner"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/lifecycle"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/notifications"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/env"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+// Actor is recorded on the deployment events and audit entries written when trials end
+const Actor = "trials"
+
+// Trial outcomes
+const (
+	Converted = "converted" // The deployment stays on its paid plan
+	Expired   = "expired"   // The deployment was uninstalled
+)
+
+// lockKey identifies the advisory lock that keeps instances from ending the same trials
+const lockKey = 727002
+
+// Start checks trials right away and then every TRIAL_CHECK_INTERVAL until ctx is cancelled
+func Start(ctx context.Context) {
+	interval := env.Duration("TRIAL_CHECK_INTERVAL", 5*time.Minute)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := Check(ctx); err != nil {
+			log.Println("❌ Trial check failed:", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check notifies the consumers of trials ending within TRIAL_NOTICE_PERIOD and
+// ends the trials that expired: deployments the consumer opted to keep convert
+// to their paid plan, the others are uninstalled.
+func Check(ctx context.Context) error {
+	notice := env.Duration("TRIAL_NOTICE_PERIOD", 72*time.Hour)
+
+	return database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var locked bool
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", lockKey).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			log.Println("⏭️ Another instance is checking trials, skipping")
+			return nil
+		}
+
+		now := time.Now()
+		if err := notifyEnding(tx, now, notice); err != nil {
+			return err
+		}
+
+		var deployments []models.Deployment
+		if err := tx.Preload("Application").
+			Where("trial_ends_at <= ? AND trial_outcome = ''", now).
+			Where("status NOT IN ?", []string{lifecycle.Uninstalling, lifecycle.Uninstalled}).
+			Find(&deployments).Error; err != nil {
+			return err
+		}
+		for _, deployment := range deployments {
+			// Each trial ends on its own, a failure leaves the others alone
+			if err := tx.Transaction(func(tx *gorm.DB) error {
+				return endTrial(tx, deployment)
+			}); err != nil {
+				log.Printf("❌ Failed to end the trial of deployment %d: %v", deployment.ID, err)
+			}
+		}
+		return nil
+	})
+}
+
+// notifyEnding tells consumers once that the trial of their deployment ends soon
+func notifyEnding(tx *gorm.DB, now time.Time, notice time.Duration) error {
+	var deployments []models.Deployment
+	if err := tx.Preload("Application").
+		Where("trial_ends_at > ? AND trial_ends_at <= ? AND trial_notified_at IS NULL", now, now.Add(notice)).
+		Where("status NOT IN ?", []string{lifecycle.Uninstalling, lifecycle.Uninstalled}).
+		Find(&deployments).Error; err != nil {
+		return err
+	}
+
+	for _, deployment := range deployments {
+		next := "it will be uninstalled unless you opt to keep it on its paid plan"
+		if deployment.ConvertAfterTrial {
+			next = "it will then be billed on its paid plan"
+		}
+		message := fmt.Sprintf("The free trial of %s (deployment %d) ends on %s, %s",
+			deployment.Application.Name, deployment.ID, deployment.TrialEndsAt.Format(time.RFC1123), next)
+
+		if err := tx.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.Deployment{}).Where("id = ?", deployment.ID).Update("trial_notified_at", now).Error; err != nil {
+				return err
+			}
+			return notifications.Notify(tx, deployment.ConsumerID, &deployment.ID, notifications.TrialEnding, message)
+		}); err != nil {
+			log.Printf("❌ Failed to notify the end of the trial of deployment %d: %v", deployment.ID, err)
+		}
+	}
+	return nil
+}
+
+// endTrial converts or uninstalls a deployment whose trial expired
+func endTrial(tx *gorm.DB, deployment models.Deployment) error {
+	id := fmt.Sprintf("%d", deployment.ID)
+
+	if deployment.ConvertAfterTrial {
+		err := setOutcome(tx, deployment, Converted)
+		if err == nil {
+			err = notifications.Notify(tx, deployment.ConsumerID, &deployment.ID, notifications.TrialConverted,
+				fmt.Sprintf("The free trial of %s (deployment %d) ended, it is now billed on its paid plan", deployment.Application.Name, deployment.ID))
+		}
+		audit.Background(Actor, "convert", "deployment", id, err)
+		if err == nil {
+			log.Printf("💳 Trial of deployment %s converted to its paid plan", id)
+		}
+		return err
+	}
+
+	// Jobs in flight are left to finish, the trial ends on a later check
+	if deployment.Status == lifecycle.Installing || deployment.Status == lifecycle.Upgrading {
+		log.Printf("⏳ Trial of deployment %s expired while %s, retrying on the next check", id, deployment.Status)
+		return nil
+	}
+
+	// Uninstall it like a deletion would, billing stopping at the end of the trial
+	// so the time until this check is not charged
+	err := lifecycle.Transition(tx, id, lifecycle.Uninstalling, Actor, "Free trial ended")
+	if err == nil {
+		err = queue.PushToUninstallerQueue(tx, deprovisioner.UninstallRequest{
+			DeploymentID:   id,
+			DeploymentType: deployment.DeploymentType,
+			ClusterName:    deployment.ClusterName,
+			VMName:         deployment.VMName,
+		})
+	}
+	if err == nil {
+		err = billing.Stop(tx, id, *deployment.TrialEndsAt, Actor, "Free trial ended")
+	}
+	if err == nil {
+		err = setOutcome(tx, deployment, Expired)
+	}
+	if err == nil {
+		err = notifications.Notify(tx, deployment.ConsumerID, &deployment.ID, notifications.TrialExpired,
+			fmt.Sprintf("The free trial of %s (deployment %d) ended, the deployment is being uninstalled", deployment.Application.Name, deployment.ID))
+	}
+	audit.Background(Actor, "expire", "deployment", id, err)
+	if err == nil {
+		log.Printf("⌛ Trial of deployment %s expired, uninstalling", id)
+	}
+	return err
+}
+
+// setOutcome records how a trial ended, unless another change ended it first
+func setOutcome(tx *gorm.DB, deployment models.Deployment, outcome string) error {
+	result := tx.Model(&models.Deployment{}).
+		Where("id = ? AND trial_outcome = ''", deployment.ID).
+		Update("trial_outcome", outcome)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("trial of deployment %d already ended", deployment.ID)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package upgrader
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/billing"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/lifecycle"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/secrets"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/utils/helm"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm"
+	"log"
+)
+
+// UpgraderActor is recorded on the deployment events caused by upgrade jobs
+const UpgraderActor = "upgrader"
+
+// Revision actions
+const (
+	ActionInstall  = "install"
+	ActionUpgrade  = "upgrade"
+	ActionRollback = "rollback"
+)
+
+// Revision statuses
+const (
+	RevisionPending    = "pending"
+	RevisionDeployed   = "deployed"
+	RevisionSuperseded = "superseded"
+	RevisionFailed     = "failed"
+)
+
+// ErrStaleRevision is returned when a newer revision of the deployment was requested meanwhile
+var ErrStaleRevision = errors.New("a newer revision of the deployment was requested")
+
+// UpgradeRequest represents an upgrade or rollback job in the queue
+type UpgradeRequest struct {
+	DeploymentID   string
+	DeploymentType string
+	RevisionID     string
+	Action         string
+}
+
+// NextRevision returns the number of the next revision of a deployment. tx must
+// hold the lock of the deployment row, e.g. taken by lifecycle.Transition.
+func NextRevision(tx *gorm.DB, deploymentID uint) (int, error) {
+	var last int
+	err := tx.Model(&models.DeploymentRevision{}).
+		Where("deployment_id = ?", deploymentID).
+		Select("COALESCE(MAX(revision), 0)").
+		Scan(&last).Error
+	return last + 1, err
+}
+
+// Apply runs the Helm upgrade or rollback of a pending revision on the cluster of
+// the deployment. On success the deployment moves to the version, values,
+// secret values and pricing plan of the revision; it keeps being billed as it
+// never stops.
+func Apply(req UpgradeRequest) error {
+	var revision models.DeploymentRevision
+	if err := database.DB.Preload("Version").First(&revision, req.RevisionID).Error; err != nil {
+		return err
+	}
+
+	// The job may be delivered again after the revision was deployed
+	if revision.Status == RevisionDeployed || revision.Status == RevisionSuperseded {
+		log.Printf("⏭️ Revision %d of deployment %s is already deployed", revision.Revision, req.DeploymentID)
+		return nil
+	}
+
+	var deployment models.Deployment
+	if err := database.DB.Preload("Application").Preload("Version").First(&deployment, revision.DeploymentID).Error; err != nil {
+		return err
+	}
+
+	next, err := NextRevision(database.DB, deployment.ID)
+	if err != nil {
+		return err
+	}
+	if revision.Revision != next-1 {
+		return fmt.Errorf("%w: revision %d of deployment %s", ErrStaleRevision, revision.Revision, req.DeploymentID)
+	}
+
+	// Retries of a failed attempt move the deployment back to "upgrading"
+	message := describe(revision)
+	if err := lifecycle.Transition(database.DB, req.DeploymentID, lifecycle.Upgrading, UpgraderActor, message); err != nil {
+		return err
+	}
+
+	// The release is named after the chart, which versions of an application cannot change
+	release := deployment.Version.Deployment.ChartName
+	if err := run(deployment, revision, release); err != nil {
+		log.Printf("❌ %s of deployment %s failed: %v", message, req.DeploymentID, err)
+		fail(req.DeploymentID, revision, err)
+		return err
+	}
+
+	helmRevision, err := helm.ReleaseRevision(deployment.ClusterName, release)
+	if err != nil {
+		log.Println("⚠️ Failed to get the Helm revision, the deployment cannot be rolled back to this revision:", err)
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.DeploymentRevision{}).
+			Where("deployment_id = ? AND status = ?", deployment.ID, RevisionDeployed).
+			Update("status", RevisionSuperseded).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&revision).Updates(map[string]interface{}{
+			"status":        RevisionDeployed,
+			"helm_revision": helmRevision,
+			"error":         nil,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&deployment).Select("application_version_id", "values").Updates(&models.Deployment{
+			ApplicationVersionID: &revision.ApplicationVersionID,
+			Values:               revision.Values,
+		}).Error; err != nil {
+			return err
+		}
+		if err := secrets.Activate(tx, deployment.ID, revision.Secret); err != nil {
+			return err
+		}
+		if err := billing.Upgrade(tx, req.DeploymentID, revision.ApplicationVersionID, revision.PricingPlanID, UpgraderActor, message); err != nil {
+			return err
+		}
+		return lifecycle.Transition(tx, req.DeploymentID, lifecycle.Installed, UpgraderActor, message)
+	})
+}
+
+// RecordInstallRevision records the first revision of a deployment once it was installed
+func RecordInstallRevision(deploymentID string) error {
+	var deployment models.Deployment
+	if err := database.DB.Preload("Version").First(&deployment, deploymentID).Error; err != nil {
+		return err
+	}
+	if deployment.ApplicationVersionID == nil {
+		return nil
+	}
+
+	var helmRevision int
+	if deployment.DeploymentType == "k8s" {
+		var err error
+		if helmRevision, err = helm.ReleaseRevision(deployment.ClusterName, deployment.Version.Deployment.ChartName); err != nil {
+			log.Println("⚠️ Failed to get the Helm revision, the deployment cannot be rolled back to its install:", err)
+		}
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		// A retried install job may have recorded it already
+		var existing int64
+		if err := tx.Model(&models.DeploymentRevision{}).Where("deployment_id = ?", deployment.ID).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return nil
+		}
+
+		// Kept so rolling back to the install reinstates its secret values
+		sealed, err := secrets.Sealed(tx, deploymentID)
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&models.DeploymentRevision{
+			DeploymentID:         deployment.ID,
+			Revision:             1,
+			Action:               ActionInstall,
+			ApplicationVersionID: *deployment.ApplicationVersionID,
+			Values:               deployment.Values,
+			HelmRevision:         helmRevision,
+			PricingPlanID:        deployment.PricingPlanID,
+			Status:               RevisionDeployed,
+			Secret:               sealed,
+		}).Error
+	})
+}
+
+func run(deployment models.Deployment, revision models.DeploymentRevision, release string) error {
+	switch revision.Action {
+	case ActionUpgrade:
+		// Secret values are only decrypted right before they are handed to Helm
+		secret, err := secrets.Open(revision.Secret)
+		if err != nil {
+			return fmt.Errorf("failed to load secret values: %w", err)
+		}
+
+		spec := revision.Version.Deployment
+		err = helm.DeployHelmChart(deployment.ClusterName, spec.RepoURL, spec.ChartName, revision.Version.ChartVersion,
+			deployment.Application.Name, fmt.Sprintf("%d", deployment.ApplicationID), secrets.Merge(revision.Values, secret))
+		return secrets.RedactError(err, secret)
+	case ActionRollback:
+		var target models.DeploymentRevision
+		if err := database.DB.Where("deployment_id = ? AND revision = ?", deployment.ID, revision.RollbackTo).First(&target).Error; err != nil {
+			return fmt.Errorf("failed to find revision %d: %w", revision.RollbackTo, err)
+		}
+		return helm.RollbackRelease(deployment.ClusterName, release, target.HelmRevision)
+	default:
+		return fmt.Errorf("unsupported revision action: %s", revision.Action)
+	}
+}
+
+// fail records the error on the revision and marks the deployment failed; the
+// revision is retried with the job unless a newer one is requested
+func fail(deploymentID string, revision models.DeploymentRevision, cause error) {
+	if err := database.DB.Model(&revision).Updates(map[string]interface{}{
+		"status": RevisionFailed,
+		"error":  cause.Error(),
+	}).Error; err != nil {
+		log.Println("❌ Failed to mark revision as failed:", err)
+	}
+	if err := lifecycle.Fail(database.DB, deploymentID, UpgraderActor, cause); err != nil {
+		log.Println("❌ Failed to mark deployment as failed:", err)
+	}
+}
+
+func describe(revision models.DeploymentRevision) string {
+	if revision.Action == ActionRollback {
+		return fmt.Sprintf("Rollback to revision %d", revision.RollbackTo)
+	}
+	return fmt.Sprintf("Upgrade to version %s (revision %d)", revision.Version.Version, revision.Revision)
+}
@@ -0,0 +1,278 @@
+package deployments
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
+	"github.com/Vinayakatk/marketplace-prototype/internal/queue"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/catalog"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/lifecycle"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/secrets"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/upgrader"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/orgs"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/envelope"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+// UpgradeDeployment API to move a running deployment to another version and/or
+// other values in place, keeping its cluster and billing record
+func UpgradeDeployment(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ApplicationVersionID uint                   `json:"application_version_id"` // Current version if omitted
+		PricingPlanID        uint                   `json:"pricing_plan_id"`        // Plan of the version named like the current one, or its only plan, if omitted
+		Values               map[string]interface{} `json:"values"`                 // Current values if omitted
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	deployment, ok := upgradableDeployment(w, r)
+	if !ok {
+		return
+	}
+
+	if req.ApplicationVersionID == 0 && req.PricingPlanID == 0 && req.Values == nil {
+		http.Error(w, "Nothing to upgrade, set application_version_id, pricing_plan_id and/or values", http.StatusBadRequest)
+		return
+	}
+
+	version := deployment.Version
+	if req.ApplicationVersionID != 0 {
+		var err error
+		version, err = catalog.ResolveVersion(database.DB, deployment.ApplicationID, req.ApplicationVersionID)
+		if err != nil {
+			switch {
+			case errors.Is(err, catalog.ErrVersionNotFound):
+				http.Error(w, "Application version not found", http.StatusNotFound)
+			case errors.Is(err, catalog.ErrVersionNotPublished):
+				http.Error(w, fmt.Sprintf("Cannot upgrade: %v", err), http.StatusConflict)
+			default:
+				http.Error(w, "Failed to resolve application version", http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	// The Helm release is named after the chart, so it cannot change in place
+	if version.Deployment.ChartName != deployment.Version.Deployment.ChartName {
+		http.Error(w, "Cannot upgrade to a version with another chart, deploy it instead", http.StatusBadRequest)
+		return
+	}
+
+	// Plans belong to a version, so the deployment moves to one of the target version
+	plan, err := catalog.UpgradePlan(database.DB, version, req.PricingPlanID, deployment.PricingPlanID)
+	if err != nil {
+		switch {
+		case errors.Is(err, catalog.ErrPlanNotFound):
+			http.Error(w, "Pricing plan not found", http.StatusNotFound)
+		case errors.Is(err, catalog.ErrPlanRequired):
+			http.Error(w, fmt.Sprintf("Version %s offers several pricing plans, pick one with pricing_plan_id", version.Version), http.StatusBadRequest)
+		default:
+			http.Error(w, "Failed to resolve pricing plan", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	values := deployment.Values
+	if req.Values != nil {
+		values = req.Values
+	}
+
+	// Secret values that are not set again are kept
+	existing, err := secrets.Load(database.DB, fmt.Sprintf("%d", deployment.ID))
+	if err != nil {
+		http.Error(w, "Failed to load secret values", http.StatusInternalServerError)
+		return
+	}
+	values, secret, ok := validateValues(w, version, secrets.Fill(values, existing))
+	if !ok {
+		return
+	}
+
+	revision := models.DeploymentRevision{
+		Action:               upgrader.ActionUpgrade,
+		ApplicationVersionID: version.ID,
+		Values:               values,
+		Status:               upgrader.RevisionPending,
+	}
+	if plan != nil {
+		revision.PricingPlanID = &plan.ID
+	}
+	message := fmt.Sprintf("Upgrade to version %s requested", version.Version)
+	requestRevision(w, deployment, &revision, secret, message)
+}
+
+// RollbackDeployment API to return a deployment to a previous revision, by
+// default the one deployed before the current one
+func RollbackDeployment(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Revision int `json:"revision"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	deployment, ok := upgradableDeployment(w, r)
+	if !ok {
+		return
+	}
+
+	// Revisions that were replaced since can be rolled back to, and the deployed one
+	// when the upgrade away from it failed
+	statuses := []string{upgrader.RevisionSuperseded}
+	if deployment.Status == lifecycle.Failed {
+		statuses = append(statuses, upgrader.RevisionDeployed)
+	}
+	query := database.DB.Where("deployment_id = ? AND status IN ?", deployment.ID, statuses)
+	if req.Revision != 0 {
+		query = query.Where("revision = ?", req.Revision)
+	}
+	var target models.DeploymentRevision
+	if err := query.Order("revision DESC").First(&target).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "No previous revision to roll back to", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to fetch deployment revisions", http.StatusInternalServerError)
+		return
+	}
+	if target.HelmRevision == 0 {
+		http.Error(w, fmt.Sprintf("Revision %d has no Helm revision to roll back to", target.Revision), http.StatusConflict)
+		return
+	}
+
+	revision := models.DeploymentRevision{
+		Action:               upgrader.ActionRollback,
+		ApplicationVersionID: target.ApplicationVersionID,
+		Values:               target.Values,
+		RollbackTo:           target.Revision,
+		PricingPlanID:        target.PricingPlanID, // Plans of published versions don't change
+		Status:               upgrader.RevisionPending,
+		Secret:               target.Secret, // Reinstated with the values once rolled back
+	}
+	message := fmt.Sprintf("Rollback to revision %d requested", target.Revision)
+	requestRevision(w, deployment, &revision, nil, message)
+}
+
+// ListDeploymentRevisions API to list the revisions of a deployment, newest first
+func ListDeploymentRevisions(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var deployment models.Deployment
+	if err := database.DB.Unscoped().Select("id", "consumer_id", "project_id").First(&deployment, id).Error; err != nil {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+		return
+	}
+	if !orgs.CanViewDeployment(r, deployment) {
+		middleware.Forbidden(w)
+		return
+	}
+
+	var revisions []models.DeploymentRevision
+	if err := database.DB.Preload("Version").
+		Where("deployment_id = ?", deployment.ID).
+		Order("revision DESC").
+		Find(&revisions).Error; err != nil {
+		http.Error(w, "Failed to fetch deployment revisions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// upgradableDeployment loads the deployment of the request if the caller can
+// manage it and it can be upgraded or rolled back, writing the error response otherwise
+func upgradableDeployment(w http.ResponseWriter, r *http.Request) (models.Deployment, bool) {
+	var deployment models.Deployment
+	if err := database.DB.Preload("Version").First(&deployment, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+		return deployment, false
+	}
+	if !orgs.CanManageDeployment(r, deployment) {
+		middleware.Forbidden(w)
+		return deployment, false
+	}
+
+	if deployment.DeploymentType != "k8s" {
+		http.Error(w, "Only Kubernetes deployments can be upgraded", http.StatusBadRequest)
+		return deployment, false
+	}
+	if !lifecycle.CanTransition(deployment.Status, lifecycle.Upgrading) {
+		http.Error(w, fmt.Sprintf("Cannot upgrade a deployment that is %s", deployment.Status), http.StatusConflict)
+		return deployment, false
+	}
+	if deployment.ClusterName == "" {
+		http.Error(w, "Deployment was never installed, delete and deploy it again", http.StatusConflict)
+		return deployment, false
+	}
+	if deployment.ApplicationVersionID == nil {
+		http.Error(w, "Deployment is not pinned to an application version", http.StatusConflict)
+		return deployment, false
+	}
+	return deployment, true
+}
+
+// requestRevision moves the deployment to "upgrading", records the pending
+// revision with the secret values it deploys, unless secret is nil, and queues
+// its job in one transaction. The deployment keeps its secret values until the
+// revision is deployed.
+func requestRevision(w http.ResponseWriter, deployment models.Deployment, revision *models.DeploymentRevision, secret map[string]interface{}, message string) {
+	id := fmt.Sprintf("%d", deployment.ID)
+	revision.DeploymentID = deployment.ID
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if secret != nil {
+			sealed, err := secrets.Seal(secret)
+			if err != nil {
+				return err
+			}
+			revision.Secret = sealed
+		}
+		if err := lifecycle.Transition(tx, id, lifecycle.Upgrading, APIActor, message); err != nil {
+			return err
+		}
+
+		next, err := upgrader.NextRevision(tx, deployment.ID)
+		if err != nil {
+			return err
+		}
+		revision.Revision = next
+		if err := tx.Create(revision).Error; err != nil {
+			return err
+		}
+
+		return queue.PushToUpgraderQueue(tx, upgrader.UpgradeRequest{
+			DeploymentID:   id,
+			DeploymentType: deployment.DeploymentType,
+			RevisionID:     fmt.Sprintf("%d", revision.ID),
+			Action:         revision.Action,
+		})
+	})
+	if err != nil {
+		if errors.Is(err, lifecycle.ErrInvalidTransition) {
+			http.Error(w, "Deployment status changed meanwhile, retry", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, envelope.ErrNotConfigured) {
+			http.Error(w, "Secret values cannot be stored, secrets are not configured", http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, "Failed to queue upgrade", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  message,
+		"revision": revision.Revision,
+	})
+}
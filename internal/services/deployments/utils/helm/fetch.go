@@ -0,0 +1,138 @@
+package helm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxRedirects bounds the redirects followed when fetching from a chart repository
+const maxRedirects = 5
+
+// Charts are fetched from URLs given by publishers, so the client only reaches
+// public addresses unless their host is allowed, checks every redirect and
+// gives up quickly.
+var httpClient = &http.Client{
+	Timeout: time.Minute,
+	Transport: &http.Transport{
+		DialContext:           dialAllowed,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		return checkURL(req.URL)
+	},
+}
+
+// allowedHosts returns the hosts of CHART_REPO_ALLOWED_HOSTS, a comma separated
+// list of chart repository hosts reachable even on private addresses and over
+// plain HTTP, e.g. a ChartMuseum inside the cluster
+func allowedHosts() map[string]bool {
+	hosts := make(map[string]bool)
+	for _, host := range strings.Split(os.Getenv("CHART_REPO_ALLOWED_HOSTS"), ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts[host] = true
+		}
+	}
+	return hosts
+}
+
+func isAllowedHost(host string) bool {
+	return allowedHosts()[strings.ToLower(host)]
+}
+
+// checkURL refuses URLs charts are not fetched from: anything but HTTPS, or
+// plain HTTP on an allowed host
+func checkURL(u *url.URL) error {
+	if u.Hostname() == "" {
+		return fmt.Errorf("%s has no host", u.Redacted())
+	}
+	switch {
+	case u.Scheme == "https":
+		return nil
+	case u.Scheme == "http" && isAllowedHost(u.Hostname()):
+		return nil
+	case u.Scheme == "http":
+		return fmt.Errorf("%s is not served over HTTPS, add %s to CHART_REPO_ALLOWED_HOSTS to allow it", u.Redacted(), u.Hostname())
+	default:
+		return fmt.Errorf("%s scheme of %s is not supported, only HTTPS chart repositories are", u.Scheme, u.Redacted())
+	}
+}
+
+// dialAllowed connects to allowed hosts as is and to other hosts only on
+// public addresses, checking the addresses it actually dials so a host cannot
+// resolve to a public address when checked and a private one when dialed
+func dialAllowed(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if isAllowedHost(host) {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return nil, fmt.Errorf("%s resolves to the non-public address %s, add it to CHART_REPO_ALLOWED_HOSTS to allow it", host, addr.IP)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%s has no address", host)
+	}
+	return dialer.DialContext(ctx, network, net.JoinHostPort(addrs[0].IP.String(), port))
+}
+
+// cgnat is the shared address space of carrier-grade NAT (RFC 6598)
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPublicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !cgnat.Contains(ip)
+}
+
+// get fetches u, refusing it unless checkURL allows it, and reads at most
+// limit bytes of its body into w
+func get(ctx context.Context, u string, w io.Writer, limit int64) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if err := checkURL(parsed); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+
+	n, err := io.Copy(w, io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return err
+	}
+	if n > limit {
+		return fmt.Errorf("larger than %d MB", limit>>20)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package helm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheckURL(t *testing.T) {
+	t.Setenv("CHART_REPO_ALLOWED_HOSTS", " ChartMuseum.charts.svc , ")
+
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{"https://charts.bitnami.com/bitnami", true},
+		{"http://chartmuseum.charts.svc:8080", true},
+		{"http://charts.bitnami.com/bitnami", false},
+		{"file:///etc/passwd", false},
+		{"ftp://charts.example.com/index.yaml", false},
+		{"gopher://127.0.0.1:6379/_INFO", false},
+		{"https:///index.yaml", false},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checkURL(u); (err == nil) != tt.allowed {
+			t.Errorf("checkURL(%s) = %v, want allowed %v", tt.url, err, tt.allowed)
+		}
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for address, want := range map[string]bool{
+		"140.82.112.3":    true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.96.0.1":       false,
+		"172.16.4.2":      false,
+		"192.168.1.10":    false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"fd00::1":         false,
+		"fe80::1":         false,
+		"0.0.0.0":         false,
+		"224.0.0.1":       false,
+	} {
+		if got := isPublicIP(net.ParseIP(address)); got != want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", address, got, want)
+		}
+	}
+}
+
+func TestGetLimitsTheBodySize(t *testing.T) {
+	t.Setenv("CHART_REPO_ALLOWED_HOSTS", "127.0.0.1")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), 2<<20+1))
+	}))
+	defer server.Close()
+
+	var body bytes.Buffer
+	if err := get(context.Background(), server.URL, &body, 2<<20); err == nil || !strings.Contains(err.Error(), "larger than 2 MB") {
+		t.Errorf("get of an oversized body = %v, want it refused", err)
+	}
+	body.Reset()
+	if err := get(context.Background(), server.URL, &body, 4<<20); err != nil || body.Len() != 2<<20+1 {
+		t.Errorf("get = %d bytes, %v, want the whole body", body.Len(), err)
+	}
+}
+
+func TestGetHonoursTheContext(t *testing.T) {
+	t.Setenv("CHART_REPO_ALLOWED_HOSTS", "127.0.0.1")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := get(ctx, server.URL, &bytes.Buffer{}, 1<<20); err == nil {
+		t.Error("get with a cancelled context succeeded")
+	}
+}
+
+func TestDownloadChart(t *testing.T) {
+	server := serveTestdata(t)
+
+	path, digest, err := DownloadChart(context.Background(), server.URL+"/index.yaml")
+	if err != nil {
+		t.Fatalf("DownloadChart: %v", err)
+	}
+	defer os.Remove(path)
+
+	data, err := os.ReadFile("testdata/index.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if want := hex.EncodeToString(sum[:]); digest != want {
+		t.Errorf("DownloadChart digest = %s, want %s", digest, want)
+	}
+	if downloaded, err := os.ReadFile(path); err != nil || !bytes.Equal(downloaded, data) {
+		t.Errorf("downloaded file differs from the served one: %v", err)
+	}
+
+	if _, _, err := DownloadChart(context.Background(), server.URL+"/missing.tgz"); err == nil {
+		t.Error("DownloadChart of a missing chart succeeded")
+	}
+}
@@ -1,64 +1,166 @@
 package helm
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"os/exec"
+	"strconv"
 )
 
-func DeployHelmChart(clusterName, repoURL, chartName, application, applicationID string) error {
-	// Construct a unique repo name
-	repoName := fmt.Sprintf("%s-%s", application, applicationID)
+// runHelm runs a helm command and returns its combined output, replaced in tests
+var runHelm = func(args ...string) ([]byte, error) {
+	return exec.Command("helm", args...).CombinedOutput()
+}
+
+// repoAlias returns the name the chart repository of an application is added
+// under. It is keyed by the repository URL, so versions released from another
+// repository never resolve their chart through the alias of a previous one.
+func repoAlias(application, applicationID, repoURL string) string {
+	sum := sha256.Sum256([]byte(repoURL))
+	return fmt.Sprintf("%s-%s-%s", application, applicationID, hex.EncodeToString(sum[:4]))
+}
+
+// DeployHelmChart installs or upgrades chartName from repoURL on the KIND cluster
+// with the given values. An empty chartVersion installs the latest version of the chart.
+func DeployHelmChart(clusterName, repoURL, chartName, chartVersion, application, applicationID string, values map[string]interface{}) error {
+	repoName := repoAlias(application, applicationID, repoURL)
+
+	// Add the repo, or point the alias at repoURL again if it exists
+	if output, err := runHelm("repo", "add", "--force-update", repoName, repoURL); err != nil {
+		return fmt.Errorf("failed to add repo: %v\n%s", err, string(output))
+	}
+	log.Printf("✅ Helm repo %s added successfully", repoName)
 
-	// Check if the repo already exists
-	listCmd := exec.Command("helm", "repo", "list", "--output", "json")
-	output, err := listCmd.CombinedOutput()
+	// Update the index of this repo only
+	if output, err := runHelm("repo", "update", repoName); err != nil {
+		return fmt.Errorf("failed to update repo: %v\n%s", err, string(output))
+	}
+
+	// Install the Helm chart using the unique repo name. "upgrade --install" keeps
+	// retried installs from failing on a release left behind by a previous attempt.
+	args := []string{"upgrade", "--install", chartName, repoName + "/" + chartName, "--kube-context", "kind-" + clusterName}
+	if chartVersion != "" {
+		args = append(args, "--version", chartVersion)
+	}
+	if len(values) > 0 {
+		valuesFile, err := writeValuesFile(values)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(valuesFile)
+		args = append(args, "--values", valuesFile)
+	}
+	output, err := runHelm(args...)
 	if err != nil {
-		return fmt.Errorf("failed to list Helm repos: %v\n%s", err, string(output))
+		return fmt.Errorf("failed to deploy Helm chart: %v\n%s", err, string(output))
 	}
 
-	// Parse JSON output to check if the repo exists
-	var repos []struct {
-		Name string `json:"name"`
+	fmt.Printf("✅ Helm chart %s %s deployed successfully on cluster %s\n", chartName, chartVersion, clusterName)
+	return nil
+}
+
+// RollbackRelease rolls the release back to a previous revision of it
+func RollbackRelease(clusterName, release string, revision int) error {
+	cmd := exec.Command("helm", "rollback", release, strconv.Itoa(revision), "--wait", "--kube-context", "kind-"+clusterName)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to roll back Helm release: %v\n%s", err, string(output))
 	}
-	if err := json.Unmarshal(output, &repos); err != nil {
-		return fmt.Errorf("failed to parse Helm repo list: %v\n%s", err, string(output))
+
+	fmt.Printf("✅ Helm release %s rolled back to revision %d on cluster %s\n", release, revision, clusterName)
+	return nil
+}
+
+// ReleaseRevision returns the current revision of the release
+func ReleaseRevision(clusterName, release string) (int, error) {
+	cmd := exec.Command("helm", "history", release, "--max", "1", "--output", "json", "--kube-context", "kind-"+clusterName)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get Helm release history: %v\n%s", err, string(output))
 	}
 
-	// Check if the repo is already present
-	repoExists := false
-	for _, repo := range repos {
-		if repo.Name == repoName {
-			repoExists = true
-			break
-		}
+	var history []struct {
+		Revision int `json:"revision"`
 	}
+	if err := json.Unmarshal(output, &history); err != nil {
+		return 0, fmt.Errorf("failed to parse Helm release history: %v\n%s", err, string(output))
+	}
+	if len(history) == 0 {
+		return 0, fmt.Errorf("release %s has no Helm history", release)
+	}
+	return history[len(history)-1].Revision, nil
+}
+
+// maxChartSize bounds the chart archives downloaded
+const maxChartSize = 16 << 20
+
+// DownloadChart downloads a chart archive to a temporary file, returning its path
+// and SHA-256 digest. The caller removes the file.
+func DownloadChart(ctx context.Context, chartURL string) (string, string, error) {
+	file, err := os.CreateTemp("", "helm-chart-*.tgz")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create chart file: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if err := get(ctx, chartURL, io.MultiWriter(file, hash), maxChartSize); err != nil {
+		os.Remove(file.Name())
+		return "", "", fmt.Errorf("failed to download chart %s: %w", chartURL, err)
+	}
+	return file.Name(), hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// LintChart runs helm lint on a chart archive with the given values, returning its output
+func LintChart(ctx context.Context, chartPath string, values map[string]interface{}) (string, error) {
+	return runWithValues(ctx, values, "lint", chartPath)
+}
+
+// TemplateChart renders a chart archive with the given values without installing
+// it, returning the rendered manifests or the rendering errors
+func TemplateChart(ctx context.Context, chartPath, release string, values map[string]interface{}) (string, error) {
+	return runWithValues(ctx, values, "template", release, chartPath)
+}
 
-	if repoExists {
-		log.Printf("✅ Helm repo %s already exists, skipping add", repoName)
-	} else {
-		// Add repo if it doesn't exist
-		addCmd := exec.Command("helm", "repo", "add", repoName, repoURL)
-		if addOutput, addErr := addCmd.CombinedOutput(); addErr != nil {
-			return fmt.Errorf("failed to add repo: %v\n%s", addErr, string(addOutput))
+// runWithValues runs a helm command with the values passed as a values file
+func runWithValues(ctx context.Context, values map[string]interface{}, args ...string) (string, error) {
+	if len(values) > 0 {
+		valuesFile, err := writeValuesFile(values)
+		if err != nil {
+			return "", err
 		}
-		log.Printf("✅ Helm repo %s added successfully", repoName)
+		defer os.Remove(valuesFile)
+		args = append(args, "--values", valuesFile)
 	}
+	output, err := exec.CommandContext(ctx, "helm", args...).CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("helm %s failed: %v", args[0], err)
+	}
+	return string(output), nil
+}
 
-	// Update the Helm repo
-	updateRepoCmd := exec.Command("helm", "repo", "update")
-	if output, err := updateRepoCmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to update repo: %v\n%s", err, string(output))
+// writeValuesFile writes values to a temporary file for --values. JSON is valid
+// YAML, so Helm reads it as is.
+func writeValuesFile(values map[string]interface{}) (string, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal Helm values: %w", err)
 	}
 
-	// Install the Helm chart using the unique repo name
-	installCmd := exec.Command("helm", "install", chartName, repoName+"/"+chartName, "--kube-context", "kind-"+clusterName)
-	output, err = installCmd.CombinedOutput()
+	file, err := os.CreateTemp("", "helm-values-*.json")
 	if err != nil {
-		return fmt.Errorf("failed to deploy Helm chart: %v\n%s", err, string(output))
+		return "", fmt.Errorf("failed to create Helm values file: %w", err)
 	}
+	defer file.Close()
 
-	fmt.Printf("✅ Helm chart %s deployed successfully on cluster %s\n", chartName, clusterName)
-	return nil
+	if _, err := file.Write(data); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write Helm values file: %w", err)
+	}
+	return file.Name(), nil
 }
@@ -0,0 +1,115 @@
+package helm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fakeHelm replaces the helm binary for the duration of a test, keeping the
+// repos it was told to add, and returns the commands it ran
+func fakeHelm(t *testing.T, repos map[string]string) *[][]string {
+	t.Helper()
+	var commands [][]string
+	old := runHelm
+	t.Cleanup(func() { runHelm = old })
+
+	runHelm = func(args ...string) ([]byte, error) {
+		commands = append(commands, args)
+		switch {
+		case len(args) == 5 && args[0] == "repo" && args[1] == "add" && args[2] == "--force-update":
+			repos[args[3]] = args[4]
+		case len(args) == 3 && args[0] == "repo" && args[1] == "update":
+			if _, ok := repos[args[2]]; !ok {
+				return []byte("Error: no repositories found"), errors.New("exit status 1")
+			}
+		}
+		return nil, nil
+	}
+	return &commands
+}
+
+// installedFrom returns the repo URL the chart of the last install was pulled from
+func installedFrom(t *testing.T, commands [][]string, repos map[string]string) string {
+	t.Helper()
+	for i := len(commands) - 1; i >= 0; i-- {
+		if args := commands[i]; args[0] == "upgrade" {
+			alias, _, _ := strings.Cut(args[3], "/")
+			url, ok := repos[alias]
+			if !ok {
+				t.Fatalf("installed %s from the unknown repo %s", args[3], alias)
+			}
+			return url
+		}
+	}
+	t.Fatal("no chart installed")
+	return ""
+}
+
+func TestDeployHelmChartPullsEachVersionFromItsRepo(t *testing.T) {
+	repos := make(map[string]string)
+	commands := fakeHelm(t, repos)
+
+	const v1, v2 = "https://charts.example.com/stable", "https://mirror.example.org/charts"
+	if err := DeployHelmChart("c1", v1, "nginx", "16.0.0", "nginx", "3", nil); err != nil {
+		t.Fatalf("DeployHelmChart: %v", err)
+	}
+	if got := installedFrom(t, *commands, repos); got != v1 {
+		t.Errorf("version 1 installed from %s, want %s", got, v1)
+	}
+
+	// The next version of the application moved to another repository
+	if err := DeployHelmChart("c1", v2, "nginx", "17.0.0", "nginx", "3", map[string]interface{}{"replicas": 2}); err != nil {
+		t.Fatalf("DeployHelmChart: %v", err)
+	}
+	if got := installedFrom(t, *commands, repos); got != v2 {
+		t.Errorf("version 2 installed from %s, want %s", got, v2)
+	}
+
+	// Going back to the first repository reuses its alias, still pointing at it
+	if err := DeployHelmChart("c2", v1, "nginx", "16.0.0", "nginx", "3", nil); err != nil {
+		t.Fatalf("DeployHelmChart: %v", err)
+	}
+	if got := installedFrom(t, *commands, repos); got != v1 {
+		t.Errorf("version 1 installed again from %s, want %s", got, v1)
+	}
+	if len(repos) != 2 {
+		t.Errorf("repos added = %v, want one per repository URL", repos)
+	}
+
+	last := (*commands)[len(*commands)-1]
+	if want := []string{"upgrade", "--install", "nginx", repoAlias("nginx", "3", v1) + "/nginx", "--kube-context", "kind-c2", "--version", "16.0.0"}; strings.Join(last, " ") != strings.Join(want, " ") {
+		t.Errorf("install command = %v, want %v", last, want)
+	}
+}
+
+func TestDeployHelmChartRefreshesAnExistingAlias(t *testing.T) {
+	const repoURL = "https://charts.example.com/stable"
+	alias := repoAlias("nginx", "3", repoURL)
+	// Left behind pointing elsewhere, e.g. by hand
+	repos := map[string]string{alias: "https://stale.example.com"}
+	fakeHelm(t, repos)
+
+	if err := DeployHelmChart("c1", repoURL, "nginx", "", "nginx", "3", nil); err != nil {
+		t.Fatalf("DeployHelmChart: %v", err)
+	}
+	if repos[alias] != repoURL {
+		t.Errorf("alias %s points at %s, want %s", alias, repos[alias], repoURL)
+	}
+}
+
+func TestRepoAlias(t *testing.T) {
+	a := repoAlias("nginx", "3", "https://charts.example.com/stable")
+	if a != repoAlias("nginx", "3", "https://charts.example.com/stable") {
+		t.Error("repoAlias is not stable")
+	}
+	if a == repoAlias("nginx", "3", "https://charts.example.com/incubator") {
+		t.Error("two repository URLs share an alias")
+	}
+	if a == repoAlias("nginx", "4", "https://charts.example.com/stable") {
+		t.Error("two applications share an alias")
+	}
+	if !strings.HasPrefix(a, "nginx-3-") {
+		t.Errorf("repoAlias = %s, want it to start with the application", a)
+	}
+}
@@ -0,0 +1,141 @@
+package helm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// maxIndexSize bounds the index files read, the ones of big public repos are tens of MB
+const maxIndexSize = 64 << 20
+
+// ChartVersion is an entry of a chart in a repository index
+type ChartVersion struct {
+	Name       string
+	Version    string
+	AppVersion string
+	Digest     string   // SHA-256 of the chart archive
+	URLs       []string // Absolute or relative to the repository
+	Deprecated bool
+}
+
+// Index is the index.yaml of a chart repository
+type Index struct {
+	Entries map[string][]ChartVersion
+}
+
+// FetchIndex downloads and parses the index.yaml of the chart repository at repoURL
+func FetchIndex(ctx context.Context, repoURL string) (*Index, error) {
+	if strings.HasPrefix(repoURL, "oci://") {
+		return nil, fmt.Errorf("OCI registries have no index, only HTTP chart repositories are supported")
+	}
+	indexURL := strings.TrimSuffix(repoURL, "/") + "/index.yaml"
+
+	var data bytes.Buffer
+	if err := get(ctx, indexURL, &data, maxIndexSize); err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", indexURL, err)
+	}
+
+	index, err := parseIndex(data.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", indexURL, err)
+	}
+	return index, nil
+}
+
+// parseIndex reads an index in YAML or, as some repositories serve it, JSON
+func parseIndex(data []byte) (*Index, error) {
+	var raw interface{}
+	if text := strings.TrimSpace(string(data)); strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if raw, err = parseYAML(text); err != nil {
+			return nil, err
+		}
+	}
+
+	document, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("index is not a mapping")
+	}
+	entries, ok := document["entries"].(map[string]interface{})
+	if !ok && document["entries"] != nil {
+		return nil, fmt.Errorf("index entries are not a mapping")
+	}
+
+	index := &Index{Entries: make(map[string][]ChartVersion, len(entries))}
+	for name, list := range entries {
+		items, _ := list.([]interface{})
+		for _, item := range items {
+			fields, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			chart := ChartVersion{
+				Name:       stringField(fields, "name"),
+				Version:    stringField(fields, "version"),
+				AppVersion: stringField(fields, "appVersion"),
+				Digest:     stringField(fields, "digest"),
+				Deprecated: stringField(fields, "deprecated") == "true",
+			}
+			if chart.Name == "" {
+				chart.Name = name
+			}
+			urls, _ := fields["urls"].([]interface{})
+			for _, u := range urls {
+				if s, ok := u.(string); ok {
+					chart.URLs = append(chart.URLs, s)
+				}
+			}
+			index.Entries[name] = append(index.Entries[name], chart)
+		}
+	}
+	return index, nil
+}
+
+// Versions returns the entries of a chart, nil if the repository doesn't have it
+func (i *Index) Versions(chartName string) []ChartVersion {
+	return i.Entries[chartName]
+}
+
+// ChartURL resolves the download URL of a chart entry against its repository.
+// Charts hosted elsewhere than their repository are refused unless their host
+// is in CHART_REPO_ALLOWED_HOSTS.
+func ChartURL(repoURL string, chart ChartVersion) (string, error) {
+	if len(chart.URLs) == 0 {
+		return "", fmt.Errorf("chart %s %s has no download URL", chart.Name, chart.Version)
+	}
+	base, err := url.Parse(strings.TrimSuffix(repoURL, "/") + "/")
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(chart.URLs[0])
+	if err != nil {
+		return "", err
+	}
+
+	chartURL := base.ResolveReference(ref)
+	if !strings.EqualFold(chartURL.Hostname(), base.Hostname()) && !isAllowedHost(chartURL.Hostname()) {
+		return "", fmt.Errorf("chart %s %s is hosted on %s, not on the repository host; add it to CHART_REPO_ALLOWED_HOSTS to allow it",
+			chart.Name, chart.Version, chartURL.Hostname())
+	}
+	return chartURL.String(), nil
+}
+
+// stringField returns a field of a decoded entry as a string, JSON indexes may hold other types
+func stringField(fields map[string]interface{}, name string) string {
+	switch value := fields[name].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		return fmt.Sprint(value)
+	}
+}
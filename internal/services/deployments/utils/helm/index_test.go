@@ -0,0 +1,190 @@
+package helm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseIndexOfHelmRepoIndex(t *testing.T) {
+	data, err := os.ReadFile("testdata/index.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := parseIndex(data)
+	if err != nil {
+		t.Fatalf("parseIndex: %v", err)
+	}
+
+	want := map[string][]ChartVersion{
+		"mariadb": {
+			{
+				Name:       "mariadb",
+				Version:    "18.0.4",
+				AppVersion: "11.3.2",
+				Digest:     "9b3f7c1f0c5e5a4a2f9a6dfa4a1b0f3c2e1d0c9b8a7f6e5d4c3b2a1f0e9d8c7b",
+				URLs:       []string{"https://charts.bitnami.com/bitnami/mariadb-18.0.4.tgz"},
+			},
+			{
+				Name:       "mariadb",
+				Version:    "18.0.3",
+				AppVersion: "11.3",
+				Digest:     "1f0e9d8c7b9b3f7c1f0c5e5a4a2f9a6dfa4a1b0f3c2e1d0c9b8a7f6e5d4c3b2a",
+				URLs:       []string{"mariadb-18.0.3.tgz"},
+			},
+		},
+		"nginx": {
+			{
+				Name:       "nginx",
+				Version:    "16.0.0",
+				AppVersion: "1.25.4",
+				Digest:     "0c9b8a7f6e5d4c3b2a1f0e9d8c7b9b3f7c1f0c5e5a4a2f9a6dfa4a1b0f3c2e1d",
+				URLs:       []string{"nginx-16.0.0.tgz"},
+				Deprecated: true,
+			},
+			{
+				Name:       "nginx",
+				Version:    "17.0.0-rc.1",
+				AppVersion: "1.27.0-rc.1",
+				URLs:       []string{"nginx-17.0.0-rc.1.tgz"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(index.Entries, want) {
+		t.Errorf("parseIndex entries =\n%+v\nwant\n%+v", index.Entries, want)
+	}
+	if versions := index.Versions("redis"); versions != nil {
+		t.Errorf("Versions of a missing chart = %v, want nil", versions)
+	}
+}
+
+func TestParseIndexJSON(t *testing.T) {
+	data := `{
+		"apiVersion": "v1",
+		"entries": {
+			"nginx": [
+				{"name": "nginx", "version": "16.0.0", "appVersion": 1.25, "deprecated": true,
+				 "digest": "abc", "urls": ["nginx-16.0.0.tgz"]}
+			]
+		}
+	}`
+	index, err := parseIndex([]byte(data))
+	if err != nil {
+		t.Fatalf("parseIndex: %v", err)
+	}
+	want := []ChartVersion{{
+		Name:       "nginx",
+		Version:    "16.0.0",
+		AppVersion: "1.25",
+		Digest:     "abc",
+		URLs:       []string{"nginx-16.0.0.tgz"},
+		Deprecated: true,
+	}}
+	if got := index.Versions("nginx"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Versions(nginx) = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseIndexErrors(t *testing.T) {
+	for _, data := range []string{
+		"- not\n- a mapping\n",
+		"entries:\n- nginx\n",
+		`{"entries": [}`,
+	} {
+		if index, err := parseIndex([]byte(data)); err == nil {
+			t.Errorf("parseIndex(%q) = %+v, want an error", data, index)
+		}
+	}
+}
+
+// serveTestdata serves the testdata directory as a chart repository over plain
+// HTTP on 127.0.0.1, which is allowed for the duration of the test
+func serveTestdata(t *testing.T) *httptest.Server {
+	t.Helper()
+	t.Setenv("CHART_REPO_ALLOWED_HOSTS", "127.0.0.1")
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetchIndex(t *testing.T) {
+	server := serveTestdata(t)
+
+	index, err := FetchIndex(context.Background(), server.URL+"/")
+	if err != nil {
+		t.Fatalf("FetchIndex: %v", err)
+	}
+	if versions := index.Versions("mariadb"); len(versions) != 2 {
+		t.Errorf("Versions(mariadb) = %+v, want 2 versions", versions)
+	}
+
+	if _, err := FetchIndex(context.Background(), server.URL+"/missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("FetchIndex of a missing repository = %v, want a 404 error", err)
+	}
+	if _, err := FetchIndex(context.Background(), "oci://registry-1.docker.io/bitnamicharts"); err == nil {
+		t.Error("FetchIndex of an OCI registry succeeded")
+	}
+}
+
+func TestFetchIndexRefusesPrivateHosts(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer server.Close()
+	tlsServer := httptest.NewTLSServer(http.FileServer(http.Dir("testdata")))
+	defer tlsServer.Close()
+
+	if _, err := FetchIndex(context.Background(), server.URL); err == nil || !strings.Contains(err.Error(), "HTTPS") {
+		t.Errorf("FetchIndex over plain HTTP = %v, want it refused", err)
+	}
+	if _, err := FetchIndex(context.Background(), tlsServer.URL); err == nil || !strings.Contains(err.Error(), "non-public address") {
+		t.Errorf("FetchIndex of a loopback address = %v, want it refused", err)
+	}
+}
+
+func TestFetchIndexStopsAtRedirectsToPrivateHosts(t *testing.T) {
+	server := serveTestdata(t)
+	redirect := httptest.NewServer(http.RedirectHandler("http://localhost:"+server.URL[strings.LastIndex(server.URL, ":")+1:]+"/index.yaml", http.StatusFound))
+	defer redirect.Close()
+
+	if _, err := FetchIndex(context.Background(), redirect.URL); err == nil || !strings.Contains(err.Error(), "HTTPS") {
+		t.Errorf("FetchIndex redirected to a host that is not allowed = %v, want it refused", err)
+	}
+}
+
+func TestChartURL(t *testing.T) {
+	t.Setenv("CHART_REPO_ALLOWED_HOSTS", "mirror.example.com")
+
+	tests := []struct {
+		name    string
+		repoURL string
+		url     string
+		want    string
+	}{
+		{"relative", "https://charts.example.com/stable", "nginx-16.0.0.tgz", "https://charts.example.com/stable/nginx-16.0.0.tgz"},
+		{"relative to a repository with a trailing slash", "https://charts.example.com/stable/", "charts/nginx-16.0.0.tgz", "https://charts.example.com/stable/charts/nginx-16.0.0.tgz"},
+		{"absolute on the repository host", "https://charts.example.com/stable", "https://charts.example.com/archive/nginx-16.0.0.tgz", "https://charts.example.com/archive/nginx-16.0.0.tgz"},
+		{"absolute on an allowed host", "https://charts.example.com/stable", "https://mirror.example.com/nginx-16.0.0.tgz", "https://mirror.example.com/nginx-16.0.0.tgz"},
+		{"absolute on another host", "https://charts.example.com/stable", "https://169.254.169.254/latest/meta-data", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ChartURL(tt.repoURL, ChartVersion{Name: "nginx", Version: "16.0.0", URLs: []string{tt.url}})
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("ChartURL = %s, want it refused", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ChartURL = %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := ChartURL("https://charts.example.com", ChartVersion{Name: "nginx"}); err == nil {
+		t.Error("ChartURL of an entry without URLs succeeded")
+	}
+}
@@ -0,0 +1,315 @@
+package helm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML reads the subset of YAML Helm writes repository indexes in: block
+// mappings and sequences, plain and quoted scalars (including ones wrapped over
+// several lines), block scalars and flow sequences of scalars. Scalars are
+// returned as strings, mappings as map[string]interface{} and sequences as
+// []interface{}. Anchors, tags and multiple documents are not supported.
+func parseYAML(data string) (interface{}, error) {
+	p := &yamlParser{lines: strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")}
+	p.skipBlank()
+	if p.pos < len(p.lines) && strings.TrimSpace(p.lines[p.pos]) == "---" {
+		p.pos++
+	}
+	value, err := p.parseNode(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.skipBlank(); p.pos < len(p.lines) {
+		return nil, p.errorf("unexpected content")
+	}
+	return value, nil
+}
+
+type yamlParser struct {
+	lines []string
+	pos   int
+}
+
+// skipBlank moves past empty and comment lines
+func (p *yamlParser) skipBlank() {
+	for p.pos < len(p.lines) {
+		text := strings.TrimSpace(p.lines[p.pos])
+		if text != "" && !strings.HasPrefix(text, "#") {
+			return
+		}
+		p.pos++
+	}
+}
+
+// current returns the indentation and content of the next significant line
+func (p *yamlParser) current() (int, string, bool) {
+	p.skipBlank()
+	if p.pos >= len(p.lines) {
+		return 0, "", false
+	}
+	line := strings.TrimRight(p.lines[p.pos], " \t")
+	text := strings.TrimLeft(line, " ")
+	return len(line) - len(text), text, true
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+// parseNode reads the mapping or sequence starting at the next line, if it is
+// indented at least by indent
+func (p *yamlParser) parseNode(indent int) (interface{}, error) {
+	col, text, ok := p.current()
+	if !ok || col < indent {
+		return nil, nil
+	}
+	if isSequenceItem(text) {
+		return p.parseSequence(col)
+	}
+	if _, _, isKey := splitKey(text); !isKey {
+		return p.scalar(text, col-1)
+	}
+	return p.parseMapping(col)
+}
+
+func (p *yamlParser) parseMapping(indent int) (map[string]interface{}, error) {
+	mapping := make(map[string]interface{})
+	for {
+		col, text, ok := p.current()
+		if !ok || col < indent || (col == indent && isSequenceItem(text)) {
+			return mapping, nil
+		}
+		if col > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		key, rest, isKey := splitKey(text)
+		if !isKey {
+			return nil, p.errorf("expected a key")
+		}
+
+		value, err := p.parseValue(indent, rest, true)
+		if err != nil {
+			return nil, err
+		}
+		mapping[key] = value
+	}
+}
+
+func (p *yamlParser) parseSequence(indent int) ([]interface{}, error) {
+	sequence := []interface{}{}
+	for {
+		col, text, ok := p.current()
+		if !ok || col != indent || !isSequenceItem(text) {
+			if ok && col > indent {
+				return nil, p.errorf("unexpected indentation")
+			}
+			return sequence, nil
+		}
+
+		content := strings.TrimLeft(text[1:], " ")
+		if content == "" || strings.HasPrefix(content, "#") {
+			p.pos++
+			item, err := p.parseNode(indent + 1)
+			if err != nil {
+				return nil, err
+			}
+			sequence = append(sequence, item)
+			continue
+		}
+
+		// "- key: value" starts a mapping indented like its first key, read it as
+		// if the dash was a space
+		if _, _, isKey := splitKey(content); isKey || isSequenceItem(content) {
+			col := indent + len(text) - len(content)
+			p.lines[p.pos] = strings.Repeat(" ", col) + content
+			item, err := p.parseNode(col)
+			if err != nil {
+				return nil, err
+			}
+			sequence = append(sequence, item)
+			continue
+		}
+
+		item, err := p.parseValue(indent, content, false)
+		if err != nil {
+			return nil, err
+		}
+		sequence = append(sequence, item)
+	}
+}
+
+// parseValue reads the value following a key or dash on the current line: a
+// nested node on the next lines if rest is empty, a block scalar or a scalar
+func (p *yamlParser) parseValue(indent int, rest string, afterKey bool) (interface{}, error) {
+	rest = stripComment(rest)
+	switch {
+	case rest == "":
+		p.pos++
+		col, text, ok := p.current()
+		// Sequences may be indented like the key they belong to
+		if ok && afterKey && col == indent && isSequenceItem(text) {
+			return p.parseSequence(col)
+		}
+		return p.parseNode(indent + 1)
+	case rest[0] == '|' || rest[0] == '>':
+		p.pos++
+		return p.blockScalar(indent, rest), nil
+	case rest[0] == '[':
+		p.pos++
+		return flowSequence(rest)
+	case rest == "{}":
+		p.pos++
+		return map[string]interface{}{}, nil
+	}
+	return p.scalar(rest, indent)
+}
+
+// scalar reads a scalar starting on the current line and continued on the
+// following lines indented deeper than indent
+func (p *yamlParser) scalar(text string, indent int) (string, error) {
+	parts := []string{stripComment(text)}
+	for p.pos++; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || len(line)-len(strings.TrimLeft(line, " ")) <= indent {
+			break
+		}
+		// A key indented deeper than its siblings is no continuation
+		if _, _, isKey := splitKey(trimmed); isKey {
+			return "", p.errorf("unexpected indentation")
+		}
+		parts = append(parts, trimmed)
+	}
+	return unquote(strings.Join(parts, " ")), nil
+}
+
+// blockScalar reads the lines of a literal (|) or folded (>) block scalar,
+// header being the indicator with its optional chomping indicator (- or +)
+func (p *yamlParser) blockScalar(indent int, header string) string {
+	var lines []string
+	blockIndent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := strings.TrimRight(p.lines[p.pos], " \t")
+		if line == "" {
+			lines = append(lines, "")
+			continue
+		}
+		col := len(line) - len(strings.TrimLeft(line, " "))
+		if col <= indent {
+			break
+		}
+		if blockIndent < 0 {
+			blockIndent = col
+		}
+		if col < blockIndent {
+			break
+		}
+		lines = append(lines, line[blockIndent:])
+	}
+	// Trailing empty lines are kept only with the + chomping indicator
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+
+	var text string
+	if header[0] == '>' {
+		// Line breaks fold into spaces, empty lines into line breaks
+		var b strings.Builder
+		for i, line := range lines {
+			switch {
+			case i == 0:
+			case line == "":
+				b.WriteString("\n")
+				continue
+			case lines[i-1] != "":
+				b.WriteString(" ")
+			}
+			b.WriteString(line)
+		}
+		text = b.String()
+	} else {
+		text = strings.Join(lines, "\n")
+	}
+
+	switch {
+	case strings.Contains(header, "-"):
+		return text
+	case strings.Contains(header, "+"):
+		return text + strings.Repeat("\n", trailing+1)
+	}
+	return text + "\n"
+}
+
+// flowSequence reads a one-line flow sequence of scalars, e.g. [a, "b"]
+func flowSequence(text string) ([]interface{}, error) {
+	if !strings.HasSuffix(text, "]") {
+		return nil, fmt.Errorf("unsupported flow sequence %q", text)
+	}
+	sequence := []interface{}{}
+	inner := strings.TrimSpace(text[1 : len(text)-1])
+	if inner == "" {
+		return sequence, nil
+	}
+	for _, item := range strings.Split(inner, ",") {
+		sequence = append(sequence, unquote(strings.TrimSpace(item)))
+	}
+	return sequence, nil
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitKey splits "key: value" into its key and value, skipping colons in quoted keys
+func splitKey(text string) (string, string, bool) {
+	start := 0
+	if text != "" && (text[0] == '"' || text[0] == '\'') {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 {
+			return "", "", false
+		}
+		start = end + 2
+	}
+	for i := start; i < len(text); i++ {
+		if text[i] == ':' && (i == len(text)-1 || text[i+1] == ' ') {
+			return unquote(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+		if text[i] == ' ' && i+1 < len(text) && text[i+1] == '#' {
+			return "", "", false
+		}
+	}
+	return "", "", false
+}
+
+// stripComment removes a trailing comment from an unquoted value
+func stripComment(text string) string {
+	text = strings.TrimSpace(text)
+	if text == "" || text[0] == '"' || text[0] == '\'' {
+		return text
+	}
+	if i := strings.Index(text, " #"); i >= 0 {
+		return strings.TrimSpace(text[:i])
+	}
+	return text
+}
+
+func unquote(text string) string {
+	text = strings.TrimSpace(text)
+	if len(text) >= 2 && text[0] == '"' && text[len(text)-1] == '"' {
+		if value, err := strconv.Unquote(text); err == nil {
+			return value
+		}
+		return text[1 : len(text)-1]
+	}
+	if len(text) >= 2 && text[0] == '\'' && text[len(text)-1] == '\'' {
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'")
+	}
+	return text
+}
@@ -0,0 +1,138 @@
+package helm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want interface{}
+	}{
+		{
+			name: "block mapping",
+			data: "apiVersion: v1\ngenerated: 2024-05-14\n",
+			want: map[string]interface{}{"apiVersion": "v1", "generated": "2024-05-14"},
+		},
+		{
+			name: "nested mappings",
+			data: "entries:\n  nginx:\n    name: nginx\n",
+			want: map[string]interface{}{"entries": map[string]interface{}{"nginx": map[string]interface{}{"name": "nginx"}}},
+		},
+		{
+			name: "sequence indented like its key",
+			data: "urls:\n- a.tgz\n- b.tgz\nname: x\n",
+			want: map[string]interface{}{"urls": []interface{}{"a.tgz", "b.tgz"}, "name": "x"},
+		},
+		{
+			name: "sequence indented deeper than its key",
+			data: "urls:\n  - a.tgz\n  - b.tgz\n",
+			want: map[string]interface{}{"urls": []interface{}{"a.tgz", "b.tgz"}},
+		},
+		{
+			name: "sequence of mappings",
+			data: "nginx:\n- name: nginx\n  version: 1.0.0\n- name: nginx\n  version: 2.0.0\n",
+			want: map[string]interface{}{"nginx": []interface{}{
+				map[string]interface{}{"name": "nginx", "version": "1.0.0"},
+				map[string]interface{}{"name": "nginx", "version": "2.0.0"},
+			}},
+		},
+		{
+			name: "nested sequences",
+			data: "- - a\n  - b\n- c\n",
+			want: []interface{}{[]interface{}{"a", "b"}, "c"},
+		},
+		{
+			name: "item on the line after its dash",
+			data: "-\n  name: a\n",
+			want: []interface{}{map[string]interface{}{"name": "a"}},
+		},
+		{
+			name: "plain scalar wrapped over several lines",
+			data: "description: MariaDB is an open source\n  SQL database server\n  used around the world.\nname: mariadb\n",
+			want: map[string]interface{}{"description": "MariaDB is an open source SQL database server used around the world.", "name": "mariadb"},
+		},
+		{
+			name: "double-quoted scalars",
+			data: "created: \"2024-05-14T10:21:37Z\"\nappVersion: \"1.0\"\nnote: \"tab\\there # not a comment\"\n",
+			want: map[string]interface{}{"created": "2024-05-14T10:21:37Z", "appVersion": "1.0", "note": "tab\there # not a comment"},
+		},
+		{
+			name: "single-quoted scalar with an escaped quote",
+			data: "description: 'the community''s fork: MariaDB'\n",
+			want: map[string]interface{}{"description": "the community's fork: MariaDB"},
+		},
+		{
+			name: "quoted key holding a colon",
+			data: "\"a: b\": c\n",
+			want: map[string]interface{}{"a: b": "c"},
+		},
+		{
+			name: "literal block scalar",
+			data: "description: |\n  line one\n  line two\nname: x\n",
+			want: map[string]interface{}{"description": "line one\nline two\n", "name": "x"},
+		},
+		{
+			name: "stripped literal block scalar",
+			data: "description: |-\n  line one\n  line two\n",
+			want: map[string]interface{}{"description": "line one\nline two"},
+		},
+		{
+			name: "folded block scalar",
+			data: "description: >\n  folded\n  text\n\n  new paragraph\nname: x\n",
+			want: map[string]interface{}{"description": "folded text\nnew paragraph\n", "name": "x"},
+		},
+		{
+			name: "flow sequence and empty mapping",
+			data: "keywords: [web, \"http server\"]\nempty: []\nserverInfo: {}\n",
+			want: map[string]interface{}{"keywords": []interface{}{"web", "http server"}, "empty": []interface{}{}, "serverInfo": map[string]interface{}{}},
+		},
+		{
+			name: "comments, blank lines and document start",
+			data: "# generated by helm\n---\n\nname: x # the name\n\n# trailing\n",
+			want: map[string]interface{}{"name": "x"},
+		},
+		{
+			name: "empty value",
+			data: "icon:\nname: x\n",
+			want: map[string]interface{}{"icon": nil, "name": "x"},
+		},
+		{
+			name: "windows line endings",
+			data: "name: x\r\nversion: 1.0.0\r\n",
+			want: map[string]interface{}{"name": "x", "version": "1.0.0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAML(tt.data)
+			if err != nil {
+				t.Fatalf("parseYAML: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseYAML =\n%#v\nwant\n%#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"deeper indented key", "name: x\n    version: 1\n"},
+		{"line that is no key", "name: x\njust text\n"},
+		{"sequence item after a mapping", "name: x\n- item\n"},
+		{"unterminated flow sequence", "keywords: [a, b\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := parseYAML(tt.data); err == nil {
+				t.Errorf("parseYAML = %#v, want an error", got)
+			}
+		})
+	}
+}
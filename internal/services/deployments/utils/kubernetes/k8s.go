@@ -9,14 +9,11 @@ import (
 // CreateKindCluster creates a local Kubernetes cluster using Kind
 func CreateKindCluster(clusterName string) error {
 	// Check if the cluster already exists
-	checkCmd := exec.Command("kind", "get", "clusters")
-	output, err := checkCmd.CombinedOutput()
+	existingClusters, err := ListKindClusters()
 	if err != nil {
-		return fmt.Errorf("failed to list Kind clusters: %v\n%s", err, string(output))
+		return err
 	}
 
-	// Convert output to a list and check if clusterName exists
-	existingClusters := strings.Split(strings.TrimSpace(string(output)), "\n")
 	for _, cluster := range existingClusters {
 		if cluster == clusterName {
 			fmt.Printf("✅ Kind cluster %s already exists, skipping creation\n", clusterName)
@@ -35,6 +32,23 @@ func CreateKindCluster(clusterName string) error {
 	return nil
 }
 
+// ListKindClusters returns the names of the existing Kind clusters
+func ListKindClusters() ([]string, error) {
+	cmd := exec.Command("kind", "get", "clusters")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Kind clusters: %v\n%s", err, string(output))
+	}
+
+	var clusters []string
+	for _, cluster := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if cluster != "" {
+			clusters = append(clusters, cluster)
+		}
+	}
+	return clusters, nil
+}
+
 func DeleteKindCluster(clusterName string) error {
 	cmd := exec.Command("kind", "delete", "cluster", "--name", clusterName)
 	return cmd.Run()
@@ -0,0 +1,76 @@
+package notifications
+
+import (
+	"encoding/json"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Notification kinds
+const (
+	TrialEnding    = "trial_ending"
+	TrialConverted = "trial_converted"
+	TrialExpired   = "trial_expired"
+)
+
+// maxListed bounds the notifications returned by ListNotifications
+const maxListed = 100
+
+// Notify records a notification for a user. db may be a transaction.
+func Notify(db *gorm.DB, userID uint, deploymentID *uint, kind, message string) error {
+	if err := db.Create(&models.Notification{
+		UserID:       userID,
+		DeploymentID: deploymentID,
+		Kind:         kind,
+		Message:      message,
+	}).Error; err != nil {
+		return err
+	}
+	log.Printf("🔔 Notified user %d: %s", userID, message)
+	return nil
+}
+
+// ListNotifications API to list the latest notifications of the caller, newest
+// first; ?unread=true only returns the ones not marked read
+func ListNotifications(w http.ResponseWriter, r *http.Request) {
+	query := database.DB.Where("user_id = ?", middleware.UserID(r))
+	if r.URL.Query().Get("unread") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var notifications []models.Notification
+	if err := query.Order("id DESC").Limit(maxListed).Find(&notifications).Error; err != nil {
+		http.Error(w, "Failed to fetch notifications", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
+
+// MarkNotificationRead API to mark a notification of the caller read
+func MarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	var notification models.Notification
+	if err := database.DB.Where("user_id = ?", middleware.UserID(r)).First(&notification, chi.URLParam(r, "notificationID")).Error; err != nil {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	}
+
+	if notification.ReadAt == nil {
+		now := time.Now()
+		if err := database.DB.Model(&notification).Update("read_at", now).Error; err != nil {
+			http.Error(w, "Failed to update notification", http.StatusInternalServerError)
+			return
+		}
+		notification.ReadAt = &now
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notification)
+}
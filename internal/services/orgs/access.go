@@ -0,0 +1,108 @@
+package orgs
+
+import (
+	"errors"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+)
+
+// Membership roles, from most to least privileged. Owners manage the members,
+// maintainers manage projects and deployments and viewers can only read them.
+const (
+	RoleOwner      = "owner"
+	RoleMaintainer = "maintainer"
+	RoleViewer     = "viewer"
+)
+
+// Roles lists the valid membership roles
+var Roles = []string{RoleOwner, RoleMaintainer, RoleViewer}
+
+var rank = map[string]int{RoleViewer: 1, RoleMaintainer: 2, RoleOwner: 3}
+
+// IsValidRole reports whether role is one of Roles
+func IsValidRole(role string) bool {
+	_, ok := rank[role]
+	return ok
+}
+
+// AtLeast reports whether role grants at least the privileges of min
+func AtLeast(role, min string) bool {
+	return rank[role] >= rank[min]
+}
+
+// MemberRole returns the role of a user in an organization, "" if they are not a member
+func MemberRole(db *gorm.DB, organizationID, userID uint) (string, error) {
+	var membership models.Membership
+	err := db.Where("organization_id = ? AND user_id = ?", organizationID, userID).First(&membership).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	return membership.Role, err
+}
+
+// HasOrganizationRole reports whether the caller has at least role min in an
+// organization. Admins have every role.
+func HasOrganizationRole(r *http.Request, organizationID uint, min string) bool {
+	if middleware.IsAdmin(r) {
+		return true
+	}
+	role, err := MemberRole(database.DB, organizationID, middleware.UserID(r))
+	if err != nil {
+		log.Println("❌ Failed to look up membership:", err)
+		return false
+	}
+	return role != "" && AtLeast(role, min)
+}
+
+// HasProjectRole reports whether the caller has at least role min on a project:
+// the owner of a project has every role, members of the organization sharing it
+// their membership role
+func HasProjectRole(r *http.Request, project models.Project, min string) bool {
+	if middleware.Owns(r, project.UserID) {
+		return true
+	}
+	if project.OrganizationID == nil {
+		return false
+	}
+	return HasOrganizationRole(r, *project.OrganizationID, min)
+}
+
+// CanViewProject reports whether the caller can see a project, its deployments and billing
+func CanViewProject(r *http.Request, project models.Project) bool {
+	return HasProjectRole(r, project, RoleViewer)
+}
+
+// CanManageProject reports whether the caller can deploy into a project and change its deployments
+func CanManageProject(r *http.Request, project models.Project) bool {
+	return HasProjectRole(r, project, RoleMaintainer)
+}
+
+// CanViewDeployment reports whether the caller can see a deployment: its consumer
+// and everyone who can view its project
+func CanViewDeployment(r *http.Request, deployment models.Deployment) bool {
+	return authorizeDeployment(r, deployment, CanViewProject)
+}
+
+// CanManageDeployment reports whether the caller can delete, upgrade or roll back a
+// deployment: its consumer and everyone who can manage its project
+func CanManageDeployment(r *http.Request, deployment models.Deployment) bool {
+	return authorizeDeployment(r, deployment, CanManageProject)
+}
+
+func authorizeDeployment(r *http.Request, deployment models.Deployment, can func(*http.Request, models.Project) bool) bool {
+	if middleware.Owns(r, deployment.ConsumerID) {
+		return true
+	}
+	var project models.Project
+	if err := database.DB.First(&project, deployment.ProjectID).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Println("❌ Failed to look up project:", err)
+		}
+		return false
+	}
+	return can(r, project)
+}
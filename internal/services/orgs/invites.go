@@ -0,0 +1,227 @@
+package orgs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/audit"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Invite statuses
+const (
+	InvitePending  = "pending"
+	InviteAccepted = "accepted"
+	InviteDeclined = "declined"
+	InviteRevoked  = "revoked"
+)
+
+var (
+	errInviteNotPending = errors.New("invite is not pending anymore")
+	errInviteExpired    = errors.New("invite is expired")
+	errAlreadyMember    = errors.New("user is already a member")
+)
+
+// CreateInvite API to invite a user into an organization (only for owners)
+func CreateInvite(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID uint   `json:"user_id"`
+		Role   string `json:"role"` // "viewer" by default
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = RoleViewer
+	}
+	if !IsValidRole(req.Role) {
+		http.Error(w, fmt.Sprintf("Invalid role. Valid roles are: %s", strings.Join(Roles, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	organization, ok := MemberOrganization(w, r, RoleOwner)
+	if !ok {
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, req.UserID).Error; err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	role, err := MemberRole(database.DB, organization.ID, user.ID)
+	if err != nil {
+		http.Error(w, "Failed to check membership", http.StatusInternalServerError)
+		return
+	}
+	if role != "" {
+		http.Error(w, fmt.Sprintf("User is already a %s of the organization", role), http.StatusConflict)
+		return
+	}
+
+	invite := models.Invite{
+		OrganizationID: organization.ID,
+		UserID:         user.ID,
+		Role:           req.Role,
+		InvitedBy:      middleware.UserID(r),
+		Status:         InvitePending,
+		ExpiresAt:      time.Now().Add(inviteTTL()),
+	}
+	if err := database.DB.Create(&invite).Error; err != nil {
+		http.Error(w, "Failed to create invite", http.StatusInternalServerError)
+		return
+	}
+	audit.Resource(r, "invite", invite.ID)
+	log.Printf("✉️ User %d invited into organization %d as %s", user.ID, organization.ID, req.Role)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invite)
+}
+
+// ListOrganizationInvites API to list the invites of an organization (only for owners)
+func ListOrganizationInvites(w http.ResponseWriter, r *http.Request) {
+	organization, ok := MemberOrganization(w, r, RoleOwner)
+	if !ok {
+		return
+	}
+
+	query := database.DB.Where("organization_id = ?", organization.ID).Order("id DESC")
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var invites []models.Invite
+	if err := query.Find(&invites).Error; err != nil {
+		http.Error(w, "Failed to fetch invites", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+// ListMyInvites API to list the pending invites of the caller
+func ListMyInvites(w http.ResponseWriter, r *http.Request) {
+	var invites []models.Invite
+	if err := database.DB.Preload("Organization").
+		Where("user_id = ? AND status = ? AND expires_at > ?", middleware.UserID(r), InvitePending, time.Now()).
+		Order("id DESC").
+		Find(&invites).Error; err != nil {
+		http.Error(w, "Failed to fetch invites", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+// AcceptInvite API for the invited user to join the organization
+func AcceptInvite(w http.ResponseWriter, r *http.Request) {
+	answerInvite(w, r, InviteAccepted)
+}
+
+// DeclineInvite API for the invited user to turn the invite down
+func DeclineInvite(w http.ResponseWriter, r *http.Request) {
+	answerInvite(w, r, InviteDeclined)
+}
+
+// RevokeInvite API to withdraw a pending invite (only for owners)
+func RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	organization, ok := MemberOrganization(w, r, RoleOwner)
+	if !ok {
+		return
+	}
+
+	result := database.DB.Model(&models.Invite{}).
+		Where("id = ? AND organization_id = ? AND status = ?", chi.URLParam(r, "inviteID"), organization.ID, InvitePending).
+		Update("status", InviteRevoked)
+	if result.Error != nil {
+		http.Error(w, "Failed to revoke invite", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Pending invite not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func answerInvite(w http.ResponseWriter, r *http.Request, status string) {
+	var invite models.Invite
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&invite, chi.URLParam(r, "inviteID")).Error; err != nil {
+			return err
+		}
+		if invite.UserID != middleware.UserID(r) {
+			return gorm.ErrRecordNotFound // Don't reveal invites of other users
+		}
+
+		// Only one of concurrent answers moves the invite out of "pending"
+		result := tx.Model(&invite).Where("status = ?", InvitePending).Update("status", status)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInviteNotPending
+		}
+		if status != InviteAccepted {
+			return nil
+		}
+		if time.Now().After(invite.ExpiresAt) {
+			return errInviteExpired
+		}
+
+		role, err := MemberRole(tx, invite.OrganizationID, invite.UserID)
+		if err != nil {
+			return err
+		}
+		if role != "" {
+			return errAlreadyMember
+		}
+		return tx.Create(&models.Membership{
+			OrganizationID: invite.OrganizationID,
+			UserID:         invite.UserID,
+			Role:           invite.Role,
+		}).Error
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			http.Error(w, "Invite not found", http.StatusNotFound)
+		case errors.Is(err, errInviteNotPending), errors.Is(err, errAlreadyMember):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, errInviteExpired):
+			http.Error(w, err.Error(), http.StatusGone)
+		default:
+			http.Error(w, "Failed to answer invite", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Printf("✉️ User %d %s the invite into organization %d", invite.UserID, status, invite.OrganizationID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// inviteTTL is how long invites can be accepted (ORG_INVITE_TTL)
+func inviteTTL() time.Duration {
+	if value := os.Getenv("ORG_INVITE_TTL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("⚠️ Invalid ORG_INVITE_TTL=%q, using 168h", value)
+	}
+	return 7 * 24 * time.Hour
+}
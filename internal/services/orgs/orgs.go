@@ -0,0 +1,251 @@
+package orgs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/audit"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errLastOwner = errors.New("an organization needs at least one owner")
+
+// CreateOrganization API, the caller becomes its owner
+func CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		http.Error(w, "Organization name is required", http.StatusBadRequest)
+		return
+	}
+
+	organization := models.Organization{Name: req.Name}
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&organization).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.Membership{
+			OrganizationID: organization.ID,
+			UserID:         middleware.UserID(r),
+			Role:           RoleOwner,
+		}).Error
+	})
+	if err != nil {
+		http.Error(w, "Failed to create organization", http.StatusInternalServerError)
+		return
+	}
+	audit.Resource(r, "organization", organization.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(organization)
+}
+
+// ListOrganizations API to list the organizations of the caller (all of them for admins)
+func ListOrganizations(w http.ResponseWriter, r *http.Request) {
+	query := database.DB.Order("id")
+	if !middleware.IsAdmin(r) {
+		query = query.Where("id IN (?)", database.DB.Model(&models.Membership{}).
+			Select("organization_id").
+			Where("user_id = ?", middleware.UserID(r)))
+	}
+
+	var organizations []models.Organization
+	if err := query.Find(&organizations).Error; err != nil {
+		http.Error(w, "Failed to fetch organizations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(organizations)
+}
+
+// GetOrganization API to get an organization with its members
+func GetOrganization(w http.ResponseWriter, r *http.Request) {
+	organization, ok := MemberOrganization(w, r, RoleViewer)
+	if !ok {
+		return
+	}
+
+	if err := database.DB.Preload("Members", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Preload("Members.User").First(&organization, organization.ID).Error; err != nil {
+		http.Error(w, "Failed to fetch organization", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(organization)
+}
+
+// DeleteOrganization API (only for owners). Its projects have to be deleted or
+// moved out first.
+func DeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	organization, ok := MemberOrganization(w, r, RoleOwner)
+	if !ok {
+		return
+	}
+
+	var projects int64
+	if err := database.DB.Model(&models.Project{}).Where("organization_id = ?", organization.ID).Count(&projects).Error; err != nil {
+		http.Error(w, "Failed to check projects", http.StatusInternalServerError)
+		return
+	}
+	if projects > 0 {
+		http.Error(w, "Cannot delete an organization that has projects", http.StatusConflict)
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("organization_id = ?", organization.ID).Delete(&models.Invite{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("organization_id = ?", organization.ID).Delete(&models.Membership{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&organization).Error
+	})
+	if err != nil {
+		http.Error(w, "Failed to delete organization", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UpdateMember API to change the role of a member (only for owners)
+func UpdateMember(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if !IsValidRole(req.Role) {
+		http.Error(w, fmt.Sprintf("Invalid role. Valid roles are: %s", strings.Join(Roles, ", ")), http.StatusBadRequest)
+		return
+	}
+
+	organization, ok := MemberOrganization(w, r, RoleOwner)
+	if !ok {
+		return
+	}
+
+	var membership models.Membership
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("organization_id = ? AND user_id = ?", organization.ID, chi.URLParam(r, "userID")).
+			First(&membership).Error; err != nil {
+			return err
+		}
+		if membership.Role == RoleOwner && req.Role != RoleOwner {
+			if err := ensureAnotherOwner(tx, organization.ID, membership.UserID); err != nil {
+				return err
+			}
+		}
+		membership.Role = req.Role
+		return tx.Model(&membership).Update("role", req.Role).Error
+	})
+	if err != nil {
+		writeMembershipError(w, err, "Failed to update member")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(membership)
+}
+
+// RemoveMember API to remove a member (only for owners), or for members to leave
+func RemoveMember(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	min := RoleOwner
+	if uint(userID) == middleware.UserID(r) {
+		min = RoleViewer
+	}
+	organization, ok := MemberOrganization(w, r, min)
+	if !ok {
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var membership models.Membership
+		if err := tx.Where("organization_id = ? AND user_id = ?", organization.ID, userID).First(&membership).Error; err != nil {
+			return err
+		}
+		if membership.Role == RoleOwner {
+			if err := ensureAnotherOwner(tx, organization.ID, membership.UserID); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&membership).Error
+	})
+	if err != nil {
+		writeMembershipError(w, err, "Failed to remove member")
+		return
+	}
+
+	log.Printf("👥 User %d removed from organization %d", userID, organization.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MemberOrganization loads the organization of the request if the caller has at
+// least role min in it, writing the error response otherwise
+func MemberOrganization(w http.ResponseWriter, r *http.Request, min string) (models.Organization, bool) {
+	var organization models.Organization
+	if err := database.DB.First(&organization, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Organization not found", http.StatusNotFound)
+		return organization, false
+	}
+	if !HasOrganizationRole(r, organization.ID, min) {
+		middleware.Forbidden(w)
+		return organization, false
+	}
+	return organization, true
+}
+
+// ensureAnotherOwner fails if userID is the last owner of an organization. The
+// owner rows are locked so two owners cannot leave at the same time.
+func ensureAnotherOwner(tx *gorm.DB, organizationID, userID uint) error {
+	var owners []models.Membership
+	if err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+		Where("organization_id = ? AND role = ?", organizationID, RoleOwner).
+		Find(&owners).Error; err != nil {
+		return err
+	}
+	for _, owner := range owners {
+		if owner.UserID != userID {
+			return nil
+		}
+	}
+	return errLastOwner
+}
+
+func writeMembershipError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "Member not found", http.StatusNotFound)
+	case errors.Is(err, errLastOwner):
+		http.Error(w, "Cannot remove the last owner of an organization", http.StatusConflict)
+	default:
+		http.Error(w, message, http.StatusInternalServerError)
+	}
+}
@@ -3,17 +3,22 @@ package projects
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/audit"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/orgs"
 	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
 	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
 	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
 	"net/http"
 	"strconv"
 )
 
 func CreateProject(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Name   string `json:"name"`
-		UserID uint   `json:"user_id"`
+		Name           string `json:"name"`
+		OrganizationID *uint  `json:"organization_id"` // Share the project with the members of an organization
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -21,11 +26,27 @@ func CreateProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	project := models.Project{Name: req.Name, UserID: req.UserID}
+	// Only maintainers can add projects to an organization
+	if req.OrganizationID != nil {
+		var organization models.Organization
+		if err := database.DB.First(&organization, *req.OrganizationID).Error; err != nil {
+			http.Error(w, "Organization not found", http.StatusNotFound)
+			return
+		}
+		if !orgs.HasOrganizationRole(r, organization.ID, orgs.RoleMaintainer) {
+			middleware.Forbidden(w)
+			return
+		}
+	}
+
+	// The caller owns the project
+	project := models.Project{Name: req.Name, UserID: middleware.UserID(r), OrganizationID: req.OrganizationID}
 	if err := database.DB.Create(&project).Error; err != nil {
 		http.Error(w, "Failed to create project", http.StatusInternalServerError)
 		return
 	}
+	audit.Resource(r, "project", project.ID)
+	audit.Changes(r, nil, project)
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -40,9 +61,13 @@ func ListProjects(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid user_id", http.StatusBadRequest)
 		return
 	}
+	if !middleware.Owns(r, uint(userID)) {
+		middleware.Forbidden(w)
+		return
+	}
 
 	var projects []models.Project
-	if err := database.DB.Preload("User").Preload("Deployments").Where("user_id = ?", userID).Find(&projects).Error; err != nil {
+	if err := database.DB.Preload("User").Preload("Deployments", deploymentsScope(r)).Where("user_id = ?", userID).Find(&projects).Error; err != nil {
 		http.Error(w, "Failed to fetch projects", http.StatusInternalServerError)
 		return
 	}
@@ -54,10 +79,14 @@ func GetDeploymentsOfAProject(w http.ResponseWriter, r *http.Request) {
 	projectID := chi.URLParam(r, "id")
 
 	var project models.Project
-	if err := database.DB.Preload("Deployments").First(&project, projectID).Error; err != nil {
+	if err := database.DB.Preload("Deployments", deploymentsScope(r)).First(&project, projectID).Error; err != nil {
 		http.Error(w, "Project not found", http.StatusNotFound)
 		return
 	}
+	if !orgs.CanViewProject(r, project) {
+		middleware.Forbidden(w)
+		return
+	}
 
 	json.NewEncoder(w).Encode(project.Deployments)
 }
@@ -65,12 +94,16 @@ func GetDeploymentsOfAProject(w http.ResponseWriter, r *http.Request) {
 func DeleteProject(w http.ResponseWriter, r *http.Request) {
 	projectID := chi.URLParam(r, "id")
 
-	// Check if project exists
+	// Check if project exists and the caller can manage it
 	var project models.Project
 	if err := database.DB.First(&project, projectID).Error; err != nil {
 		http.Error(w, "Project not found", http.StatusNotFound)
 		return
 	}
+	if !orgs.CanManageProject(r, project) {
+		middleware.Forbidden(w)
+		return
+	}
 
 	// Check if the project has deployments
 	var deploymentCount int64
@@ -84,11 +117,54 @@ func DeleteProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Uninstalled deployments are kept for audit and billing and still reference the project
+	var uninstalledCount int64
+	if err := database.DB.Unscoped().Model(&models.Deployment{}).Where("project_id = ? AND deleted_at IS NOT NULL", projectID).Count(&uninstalledCount).Error; err != nil {
+		http.Error(w, "Failed to check deployments", http.StatusInternalServerError)
+		return
+	}
+
+	if uninstalledCount > 0 {
+		http.Error(w, "Cannot delete project with uninstalled deployments kept for audit and billing", http.StatusConflict)
+		return
+	}
+
 	// Delete project if no deployments exist
 	if err := database.DB.Delete(&project).Error; err != nil {
 		http.Error(w, "Failed to delete project", http.StatusInternalServerError)
 		return
 	}
+	audit.Changes(r, project, nil)
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// ListOrganizationProjects API to list the projects of an organization
+func ListOrganizationProjects(w http.ResponseWriter, r *http.Request) {
+	organization, ok := orgs.MemberOrganization(w, r, orgs.RoleViewer)
+	if !ok {
+		return
+	}
+
+	var projects []models.Project
+	if err := database.DB.Preload("User").Preload("Deployments", deploymentsScope(r)).
+		Where("organization_id = ?", organization.ID).
+		Find(&projects).Error; err != nil {
+		http.Error(w, "Failed to fetch projects", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(projects)
+}
+
+// deploymentsScope preloads the active deployments of a project, or all of them
+// including uninstalled ones with ?include=uninstalled
+func deploymentsScope(r *http.Request) func(db *gorm.DB) *gorm.DB {
+	include := deployments.IncludeUninstalled(r)
+	return func(db *gorm.DB) *gorm.DB {
+		if include {
+			return db.Unscoped()
+		}
+		return db
+	}
+}
@@ -0,0 +1,343 @@
+package quotas
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/billing"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Scopes quotas are set for
+const (
+	ScopeUser         = "user"
+	ScopeProject      = "project"
+	ScopeOrganization = "organization"
+)
+
+// Scopes lists the valid quota scopes
+var Scopes = []string{ScopeUser, ScopeProject, ScopeOrganization}
+
+// Limited resources
+const (
+	ActiveDeployments = "active_deployments" // Deployments that are not uninstalled
+	K8sDeployments    = "k8s_deployments"    // Active Kubernetes-based deployments
+	CPU               = "cpu"                // Total vCPUs of active VM deployments
+	MemoryGB          = "memory_gb"          // Total memory of active VM deployments
+	MonthlySpend      = "monthly_spend"      // Charges of the current calendar month
+)
+
+// Resources lists the limited resources in the order they are reported
+var Resources = []string{ActiveDeployments, K8sDeployments, CPU, MemoryGB, MonthlySpend}
+
+// builtinLimits apply when neither an override nor QUOTA_<SCOPE>_MAX_<RESOURCE> is set,
+// every other limit is unlimited
+var builtinLimits = map[string]map[string]float64{
+	ScopeUser: {ActiveDeployments: 10, K8sDeployments: 3},
+}
+
+// IsValidScope reports whether scope is one of Scopes
+func IsValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Resource is the consumption of a resource against its limit
+type Resource struct {
+	Name  string   `json:"name"`
+	Used  float64  `json:"used"`
+	Limit *float64 `json:"limit"` // Nil if unlimited
+}
+
+// Usage is the consumption of a user, project or organization
+type Usage struct {
+	Scope      string     `json:"scope"`
+	ScopeID    uint       `json:"scope_id"`
+	Overridden bool       `json:"overridden"` // Whether an admin set limits for this scope
+	Resources  []Resource `json:"resources"`
+}
+
+// Violation is a limit a deployment would exceed
+type Violation struct {
+	Scope     string  `json:"scope"`
+	ScopeID   uint    `json:"scope_id"`
+	Resource  string  `json:"resource"`
+	Used      float64 `json:"used"`
+	Requested float64 `json:"requested"`
+	Limit     float64 `json:"limit"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s %d: %s %g + %g exceeds the limit of %g", v.Scope, v.ScopeID, v.Resource, v.Used, v.Requested, v.Limit)
+}
+
+// Limits returns the limits of a scope: the override set by an admin, falling
+// back to the defaults. Unlimited resources are nil.
+func Limits(db *gorm.DB, scope string, scopeID uint) (map[string]*float64, bool, error) {
+	limits := make(map[string]*float64, len(Resources))
+	for _, resource := range Resources {
+		limits[resource] = defaultLimit(scope, resource)
+	}
+
+	quota, err := findQuota(db, scope, scopeID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return limits, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	overrides := map[string]*float64{
+		ActiveDeployments: intLimit(quota.MaxActiveDeployments),
+		K8sDeployments:    intLimit(quota.MaxK8sDeployments),
+		CPU:               quota.MaxCPU,
+		MemoryGB:          quota.MaxMemoryGB,
+		MonthlySpend:      quota.MaxMonthlySpend,
+	}
+	for resource, limit := range overrides {
+		if limit == nil {
+			continue
+		}
+		if *limit < 0 {
+			limits[resource] = nil
+		} else {
+			limits[resource] = limit
+		}
+	}
+	return limits, true, nil
+}
+
+// GetUsage returns the consumption of a scope against its limits
+func GetUsage(db *gorm.DB, scope string, scopeID uint) (Usage, error) {
+	limits, overridden, err := Limits(db, scope, scopeID)
+	if err != nil {
+		return Usage{}, err
+	}
+	used, err := measure(db, scope, scopeID)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	usage := Usage{Scope: scope, ScopeID: scopeID, Overridden: overridden}
+	for _, resource := range Resources {
+		usage.Resources = append(usage.Resources, Resource{Name: resource, Used: used[resource], Limit: limits[resource]})
+	}
+	return usage, nil
+}
+
+// Check returns the limits of the user, the project and its organization that a
+// new deployment with the given spec would exceed. It locks their rows first, so
+// concurrent deployments into the same scopes are checked one after another; tx
+// must be the transaction creating the deployment.
+func Check(tx *gorm.DB, project models.Project, userID uint, spec models.DeploymentSpec) ([]Violation, error) {
+	scopes := []lockedScope{
+		{ScopeUser, userID, &models.User{}},
+		{ScopeProject, project.ID, &models.Project{}},
+	}
+	if project.OrganizationID != nil {
+		scopes = append(scopes, lockedScope{ScopeOrganization, *project.OrganizationID, &models.Organization{}})
+	}
+
+	requested := map[string]float64{ActiveDeployments: 1}
+	switch spec.Type {
+	case "k8s":
+		requested[K8sDeployments] = 1
+	case "vm":
+		requested[CPU] = ParseCPU(spec.CPU)
+		requested[MemoryGB] = ParseMemoryGB(spec.Memory)
+	}
+
+	var violations []Violation
+	for _, scope := range scopes {
+		if err := lockScope(tx, scope); err != nil {
+			return nil, err
+		}
+
+		usage, err := GetUsage(tx, scope.name, scope.id)
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range usage.Resources {
+			if resource.Limit == nil {
+				continue
+			}
+			// The spend of a month is only known afterwards, so deployments are
+			// refused once it reached the limit
+			exceeded := resource.Used >= *resource.Limit
+			if resource.Name != MonthlySpend {
+				exceeded = requested[resource.Name] > 0 && resource.Used+requested[resource.Name] > *resource.Limit
+			}
+			if exceeded {
+				violations = append(violations, Violation{
+					Scope:     scope.name,
+					ScopeID:   scope.id,
+					Resource:  resource.Name,
+					Used:      resource.Used,
+					Requested: requested[resource.Name],
+					Limit:     *resource.Limit,
+				})
+			}
+		}
+	}
+	return violations, nil
+}
+
+// lockedScope is a scope checked by Check together with the row it locks
+type lockedScope struct {
+	name string
+	id   uint
+	row  interface{}
+}
+
+// Database access of Check, replaced in tests
+var (
+	findQuota = func(db *gorm.DB, scope string, scopeID uint) (models.Quota, error) {
+		var quota models.Quota
+		err := db.Where("scope = ? AND scope_id = ?", scope, scopeID).First(&quota).Error
+		return quota, err
+	}
+	lockScope = func(tx *gorm.DB, scope lockedScope) error {
+		return tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+			Select("id").First(scope.row, scope.id).Error
+	}
+	measure = consumption
+)
+
+// ParseCPU returns the number of vCPUs of a VM spec, e.g. 2 for "2 vCPUs"
+func ParseCPU(value string) float64 {
+	number, _ := splitQuantity(value)
+	return number
+}
+
+// ParseMemoryGB returns the memory of a VM spec in GB, e.g. 4 for "4GB RAM" or
+// 0.5 for "512Mi". Values without a unit are taken as GB.
+func ParseMemoryGB(value string) float64 {
+	number, unit := splitQuantity(value)
+	switch {
+	case strings.HasPrefix(unit, "t"):
+		return number * 1024
+	case strings.HasPrefix(unit, "m"):
+		return number / 1024
+	case strings.HasPrefix(unit, "k"):
+		return number / (1024 * 1024)
+	default:
+		return number
+	}
+}
+
+// splitQuantity splits "4GB RAM" into 4 and "gb ram"
+func splitQuantity(value string) (float64, string) {
+	value = strings.TrimSpace(value)
+	end := strings.IndexFunc(value, func(r rune) bool {
+		return !unicode.IsDigit(r) && r != '.'
+	})
+	if end < 0 {
+		end = len(value)
+	}
+	number, err := strconv.ParseFloat(value[:end], 64)
+	if err != nil {
+		return 0, ""
+	}
+	return number, strings.ToLower(strings.TrimSpace(value[end:]))
+}
+
+// consumption sums up the resources used by the active deployments of a scope
+// and the charges of the current month, including uninstalled deployments
+func consumption(db *gorm.DB, scope string, scopeID uint) (map[string]float64, error) {
+	var filter string
+	switch scope {
+	case ScopeUser:
+		filter = "deployments.consumer_id = ?"
+	case ScopeProject:
+		filter = "deployments.project_id = ?"
+	case ScopeOrganization:
+		filter = "deployments.project_id IN (SELECT id FROM projects WHERE organization_id = ?)"
+	default:
+		return nil, fmt.Errorf("unknown quota scope: %s", scope)
+	}
+
+	var deployments []models.Deployment
+	if err := db.Preload("Application").Preload("Version").
+		Where(filter, scopeID).
+		Find(&deployments).Error; err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]float64, len(Resources))
+	for _, deployment := range deployments {
+		used[ActiveDeployments]++
+		// Deployments from before versions were introduced use the spec of the application
+		spec := deployment.Application.Deployment
+		if deployment.ApplicationVersionID != nil {
+			spec = deployment.Version.Deployment
+		}
+		switch deployment.DeploymentType {
+		case "k8s":
+			used[K8sDeployments]++
+		case "vm":
+			used[CPU] += ParseCPU(spec.CPU)
+			used[MemoryGB] += ParseMemoryGB(spec.Memory)
+		}
+	}
+
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	var deploymentIDs []uint
+	if err := db.Model(&models.BillingRecord{}).
+		Joins("JOIN deployments ON CAST(deployments.id AS TEXT) = billing_records.deployment_id").
+		Where(filter, scopeID).
+		Where("(billing_records.end_time IS NULL OR billing_records.end_time > ?)", monthStart).
+		Pluck("deployments.id", &deploymentIDs).Error; err != nil {
+		return nil, err
+	}
+	var events []models.UsageEvent
+	if err := db.Where("deployment_id IN ?", deploymentIDs).Order("occurred_at, id").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	usage := make(map[uint][]models.UsageEvent, len(deploymentIDs))
+	for _, event := range events {
+		usage[event.DeploymentID] = append(usage[event.DeploymentID], event)
+	}
+	for _, events := range usage {
+		used[MonthlySpend] += billing.Rate(events, monthStart, now)
+	}
+	return used, nil
+}
+
+// defaultLimit reads QUOTA_<SCOPE>_MAX_<RESOURCE>, e.g. QUOTA_USER_MAX_ACTIVE_DEPLOYMENTS;
+// negative values are unlimited
+func defaultLimit(scope, resource string) *float64 {
+	name := fmt.Sprintf("QUOTA_%s_MAX_%s", strings.ToUpper(scope), strings.ToUpper(resource))
+	if value := os.Getenv(name); value != "" {
+		if limit, err := strconv.ParseFloat(value, 64); err == nil {
+			if limit < 0 {
+				return nil
+			}
+			return &limit
+		}
+		log.Printf("⚠️ Invalid %s=%q, using the built-in default", name, value)
+	}
+	if limit, ok := builtinLimits[scope][resource]; ok {
+		return &limit
+	}
+	return nil
+}
+
+func intLimit(limit *int) *float64 {
+	if limit == nil {
+		return nil
+	}
+	value := float64(*limit)
+	return &value
+}
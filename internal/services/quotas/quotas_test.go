@@ -0,0 +1,173 @@
+package quotas
+
+import (
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm"
+	"reflect"
+	"testing"
+)
+
+// IDs of the scopes of the deployments checked in the tests
+const (
+	userID         = 7
+	projectID      = 3
+	organizationID = 5
+)
+
+// fakeScopes replaces the stored quotas and the consumption of the scopes, keyed
+// by scope name, for the duration of a test and returns the scopes locked
+func fakeScopes(t *testing.T, quotas []models.Quota, used map[string]map[string]float64) *[]string {
+	t.Helper()
+	var locked []string
+	oldFind, oldLock, oldMeasure := findQuota, lockScope, measure
+	t.Cleanup(func() { findQuota, lockScope, measure = oldFind, oldLock, oldMeasure })
+
+	findQuota = func(_ *gorm.DB, scope string, scopeID uint) (models.Quota, error) {
+		for _, quota := range quotas {
+			if quota.Scope == scope && quota.ScopeID == scopeID {
+				return quota, nil
+			}
+		}
+		return models.Quota{}, gorm.ErrRecordNotFound
+	}
+	lockScope = func(_ *gorm.DB, scope lockedScope) error {
+		locked = append(locked, scope.name)
+		return nil
+	}
+	measure = func(_ *gorm.DB, scope string, _ uint) (map[string]float64, error) {
+		return used[scope], nil
+	}
+	return &locked
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func TestCheck(t *testing.T) {
+	k8s := models.DeploymentSpec{Type: "k8s"}
+	vm := models.DeploymentSpec{Type: "vm", CPU: "2 vCPUs", Memory: "4GB RAM"}
+
+	tests := []struct {
+		name   string
+		env    map[string]string
+		quotas []models.Quota
+		used   map[string]map[string]float64
+		spec   models.DeploymentSpec
+		want   []Violation
+	}{
+		{"within the built-in limits", nil, nil,
+			map[string]map[string]float64{ScopeUser: {ActiveDeployments: 9, K8sDeployments: 2}}, k8s, nil},
+		{"built-in limit reached", nil, nil,
+			map[string]map[string]float64{ScopeUser: {ActiveDeployments: 4, K8sDeployments: 3}}, k8s,
+			[]Violation{{ScopeUser, userID, K8sDeployments, 3, 1, 3}}},
+		{"limits of other deployment types don't apply", nil, nil,
+			map[string]map[string]float64{ScopeUser: {ActiveDeployments: 4, K8sDeployments: 3}}, vm, nil},
+
+		{"environment over built-in", map[string]string{"QUOTA_USER_MAX_K8S_DEPLOYMENTS": "5"}, nil,
+			map[string]map[string]float64{ScopeUser: {ActiveDeployments: 4, K8sDeployments: 4}}, k8s, nil},
+		{"environment limit reached", map[string]string{"QUOTA_USER_MAX_ACTIVE_DEPLOYMENTS": "4"}, nil,
+			map[string]map[string]float64{ScopeUser: {ActiveDeployments: 4}}, k8s,
+			[]Violation{{ScopeUser, userID, ActiveDeployments, 4, 1, 4}}},
+		{"negative environment limit is unlimited", map[string]string{"QUOTA_USER_MAX_ACTIVE_DEPLOYMENTS": "-1"}, nil,
+			map[string]map[string]float64{ScopeUser: {ActiveDeployments: 500}}, vm, nil},
+		{"invalid environment limit falls back to built-in", map[string]string{"QUOTA_USER_MAX_ACTIVE_DEPLOYMENTS": "lots"}, nil,
+			map[string]map[string]float64{ScopeUser: {ActiveDeployments: 10}}, vm,
+			[]Violation{{ScopeUser, userID, ActiveDeployments, 10, 1, 10}}},
+
+		{"override over environment", map[string]string{"QUOTA_USER_MAX_K8S_DEPLOYMENTS": "10"},
+			[]models.Quota{{Scope: ScopeUser, ScopeID: userID, MaxK8sDeployments: intPtr(1)}},
+			map[string]map[string]float64{ScopeUser: {ActiveDeployments: 1, K8sDeployments: 1}}, k8s,
+			[]Violation{{ScopeUser, userID, K8sDeployments, 1, 1, 1}}},
+		{"override raises the built-in limit", nil,
+			[]models.Quota{{Scope: ScopeUser, ScopeID: userID, MaxK8sDeployments: intPtr(20)}},
+			map[string]map[string]float64{ScopeUser: {ActiveDeployments: 5, K8sDeployments: 5}}, k8s, nil},
+		{"negative override is unlimited", map[string]string{"QUOTA_USER_MAX_K8S_DEPLOYMENTS": "1"},
+			[]models.Quota{{Scope: ScopeUser, ScopeID: userID, MaxK8sDeployments: intPtr(-1)}},
+			map[string]map[string]float64{ScopeUser: {ActiveDeployments: 5, K8sDeployments: 5}}, k8s, nil},
+		{"resources the override leaves unset keep their default", map[string]string{"QUOTA_USER_MAX_ACTIVE_DEPLOYMENTS": "2"},
+			[]models.Quota{{Scope: ScopeUser, ScopeID: userID, MaxK8sDeployments: intPtr(20)}},
+			map[string]map[string]float64{ScopeUser: {ActiveDeployments: 2, K8sDeployments: 2}}, k8s,
+			[]Violation{{ScopeUser, userID, ActiveDeployments, 2, 1, 2}}},
+		{"override of another scope doesn't apply", nil,
+			[]models.Quota{{Scope: ScopeProject, ScopeID: userID, MaxK8sDeployments: intPtr(20)}},
+			map[string]map[string]float64{ScopeUser: {ActiveDeployments: 3, K8sDeployments: 3}}, k8s,
+			[]Violation{{ScopeUser, userID, K8sDeployments, 3, 1, 3}}},
+
+		{"VM resources", map[string]string{"QUOTA_PROJECT_MAX_CPU": "4", "QUOTA_PROJECT_MAX_MEMORY_GB": "8"}, nil,
+			map[string]map[string]float64{ScopeProject: {ActiveDeployments: 2, CPU: 3, MemoryGB: 4}}, vm,
+			[]Violation{{ScopeProject, projectID, CPU, 3, 2, 4}}},
+		{"VM resources up to the limit", map[string]string{"QUOTA_PROJECT_MAX_CPU": "4", "QUOTA_PROJECT_MAX_MEMORY_GB": "8"}, nil,
+			map[string]map[string]float64{ScopeProject: {ActiveDeployments: 2, CPU: 2, MemoryGB: 4}}, vm, nil},
+		{"organization limit", nil,
+			[]models.Quota{{Scope: ScopeOrganization, ScopeID: organizationID, MaxActiveDeployments: intPtr(10)}},
+			map[string]map[string]float64{ScopeUser: {ActiveDeployments: 1}, ScopeOrganization: {ActiveDeployments: 10}}, vm,
+			[]Violation{{ScopeOrganization, organizationID, ActiveDeployments, 10, 1, 10}}},
+		{"every scope over its limit", map[string]string{"QUOTA_PROJECT_MAX_ACTIVE_DEPLOYMENTS": "1"}, nil,
+			map[string]map[string]float64{ScopeUser: {ActiveDeployments: 10}, ScopeProject: {ActiveDeployments: 1}}, vm,
+			[]Violation{{ScopeUser, userID, ActiveDeployments, 10, 1, 10}, {ScopeProject, projectID, ActiveDeployments, 1, 1, 1}}},
+
+		{"monthly spend below the limit", nil,
+			[]models.Quota{{Scope: ScopeUser, ScopeID: userID, MaxMonthlySpend: floatPtr(100)}},
+			map[string]map[string]float64{ScopeUser: {ActiveDeployments: 1, MonthlySpend: 99.99}}, k8s, nil},
+		{"monthly spend reached", nil,
+			[]models.Quota{{Scope: ScopeUser, ScopeID: userID, MaxMonthlySpend: floatPtr(100)}},
+			map[string]map[string]float64{ScopeUser: {ActiveDeployments: 1, MonthlySpend: 100}}, k8s,
+			[]Violation{{ScopeUser, userID, MonthlySpend, 100, 0, 100}}},
+		{"monthly spend from the environment", map[string]string{"QUOTA_ORGANIZATION_MAX_MONTHLY_SPEND": "50"}, nil,
+			map[string]map[string]float64{ScopeOrganization: {MonthlySpend: 75.5}}, vm,
+			[]Violation{{ScopeOrganization, organizationID, MonthlySpend, 75.5, 0, 50}}},
+		{"no monthly spend limit by default", nil, nil,
+			map[string]map[string]float64{ScopeUser: {MonthlySpend: 1e6}, ScopeProject: {MonthlySpend: 1e6}, ScopeOrganization: {MonthlySpend: 1e6}}, k8s, nil},
+	}
+	organization := uint(organizationID)
+	project := models.Project{ID: projectID, OrganizationID: &organization}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			locked := fakeScopes(t, tt.quotas, tt.used)
+
+			got, err := Check(nil, project, userID, tt.spec)
+			if err != nil {
+				t.Fatalf("Check: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check = %v, want %v", got, tt.want)
+			}
+			if want := []string{ScopeUser, ScopeProject, ScopeOrganization}; !reflect.DeepEqual(*locked, want) {
+				t.Errorf("locked %v, want %v in this order", *locked, want)
+			}
+		})
+	}
+}
+
+func TestCheckWithoutOrganization(t *testing.T) {
+	locked := fakeScopes(t, []models.Quota{{Scope: ScopeOrganization, ScopeID: organizationID, MaxActiveDeployments: intPtr(0)}}, nil)
+
+	got, err := Check(nil, models.Project{ID: projectID}, userID, models.DeploymentSpec{Type: "k8s"})
+	if err != nil || len(got) > 0 {
+		t.Errorf("Check = %v, %v, want no violations", got, err)
+	}
+	if want := []string{ScopeUser, ScopeProject}; !reflect.DeepEqual(*locked, want) {
+		t.Errorf("locked %v, want %v", *locked, want)
+	}
+}
+
+func TestParseQuantities(t *testing.T) {
+	for value, want := range map[string]float64{"2 vCPUs": 2, "0.5": 0.5, "": 0, "many": 0} {
+		if got := ParseCPU(value); got != want {
+			t.Errorf("ParseCPU(%q) = %v, want %v", value, got, want)
+		}
+	}
+	for value, want := range map[string]float64{"4GB RAM": 4, "512Mi": 0.5, "2 TB": 2048, "1048576k": 1, "8": 8} {
+		if got := ParseMemoryGB(value); got != want {
+			t.Errorf("ParseMemoryGB(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
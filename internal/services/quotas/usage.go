@@ -0,0 +1,60 @@
+package quotas
+
+import (
+	"encoding/json"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/orgs"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+)
+
+// GetUserUsage API to show the consumption of a user against their quota
+func GetUserUsage(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if !middleware.Owns(r, uint(userID)) {
+		middleware.Forbidden(w)
+		return
+	}
+	writeUsage(w, ScopeUser, uint(userID))
+}
+
+// GetProjectUsage API to show the consumption of a project against its quota
+func GetProjectUsage(w http.ResponseWriter, r *http.Request) {
+	var project models.Project
+	if err := database.DB.First(&project, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if !orgs.CanViewProject(r, project) {
+		middleware.Forbidden(w)
+		return
+	}
+	writeUsage(w, ScopeProject, project.ID)
+}
+
+// GetOrganizationUsage API to show the consumption of an organization against its quota
+func GetOrganizationUsage(w http.ResponseWriter, r *http.Request) {
+	organization, ok := orgs.MemberOrganization(w, r, orgs.RoleViewer)
+	if !ok {
+		return
+	}
+	writeUsage(w, ScopeOrganization, organization.ID)
+}
+
+func writeUsage(w http.ResponseWriter, scope string, scopeID uint) {
+	usage, err := GetUsage(database.DB, scope, scopeID)
+	if err != nil {
+		http.Error(w, "Failed to compute quota usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
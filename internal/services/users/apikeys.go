@@ -0,0 +1,190 @@
+package users
+
+import (
+	"encoding/json"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/audit"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/apikey"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/jwt"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// CreateAPIKey API to create an API key for the caller. The key is only returned
+// in this response; afterwards only its prefix is shown.
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string     `json:"name"`
+		ExpiresAt *time.Time `json:"expires_at"` // Never expires if omitted
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	key, stored, err := newAPIKey(database.DB, middleware.UserID(r), req.Name, req.ExpiresAt)
+	if err != nil {
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+	audit.Resource(r, "api_key", stored.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"api_key": key,
+		"key":     stored,
+	})
+}
+
+// IssueAPIKey API to create an API key for another user (only for admins), e.g.
+// for users that existed before authentication and so never got one. The key is
+// only returned in this response, for the admin to hand over.
+func IssueAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string     `json:"name"`
+		ExpiresAt *time.Time `json:"expires_at"` // Never expires if omitted
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	key, stored, err := newAPIKey(database.DB, user.ID, req.Name, req.ExpiresAt)
+	if err != nil {
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+	audit.Resource(r, "api_key", stored.ID)
+	log.Printf("🔑 API key %d issued to user %d by admin %d", stored.ID, user.ID, middleware.UserID(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"api_key": key,
+		"key":     stored,
+	})
+}
+
+// ListAPIKeys API to list the API keys of the caller, including revoked and expired ones
+func ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	var keys []models.APIKey
+	if err := database.DB.Where("user_id = ?", middleware.UserID(r)).Order("id").Find(&keys).Error; err != nil {
+		http.Error(w, "Failed to fetch API keys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RevokeAPIKey API to revoke an API key of the caller; it is rejected from then on
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	var key models.APIKey
+	if err := database.DB.Where("user_id = ?", middleware.UserID(r)).First(&key, chi.URLParam(r, "keyID")).Error; err != nil {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	if key.RevokedAt == nil {
+		now := time.Now()
+		if err := database.DB.Model(&key).Update("revoked_at", now).Error; err != nil {
+			http.Error(w, "Failed to revoke API key", http.StatusInternalServerError)
+			return
+		}
+		key.RevokedAt = &now
+		log.Printf("🔑 API key %d of user %d revoked", key.ID, key.UserID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(key)
+}
+
+// IssueToken API to exchange an API key for a short-lived bearer token, signed
+// with AUTH_JWT_SECRET and valid for AUTH_TOKEN_TTL
+func IssueToken(w http.ResponseWriter, r *http.Request) {
+	secret := middleware.JWTSecret()
+	if secret == nil {
+		http.Error(w, "Bearer tokens are not enabled, set AUTH_JWT_SECRET", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Tokens cannot be renewed with a token, which would make them live forever
+	identity, _ := middleware.CurrentIdentity(r.Context())
+	if identity.Method != middleware.MethodAPIKey {
+		http.Error(w, "Bearer tokens can only be issued for an API key", http.StatusForbidden)
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(tokenTTL())
+	token, err := jwt.Sign(jwt.Claims{
+		Subject:   strconv.FormatUint(uint64(identity.UserID), 10),
+		Issuer:    "marketplace-prototype",
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	}, secret)
+	if err != nil {
+		http.Error(w, "Failed to issue token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_at":   expiresAt.UTC(),
+	})
+}
+
+// newAPIKey creates an API key for a user and returns it with its stored record. db may be a transaction.
+func newAPIKey(db *gorm.DB, userID uint, name string, expiresAt *time.Time) (string, models.APIKey, error) {
+	key, prefix, err := apikey.Generate()
+	if err != nil {
+		return "", models.APIKey{}, err
+	}
+
+	stored := models.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   apikey.Hash(key),
+		ExpiresAt: expiresAt,
+	}
+	if err := db.Create(&stored).Error; err != nil {
+		return "", models.APIKey{}, err
+	}
+	return key, stored, nil
+}
+
+// tokenTTL is how long issued bearer tokens are valid (AUTH_TOKEN_TTL)
+func tokenTTL() time.Duration {
+	if value := os.Getenv("AUTH_TOKEN_TTL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("⚠️ Invalid AUTH_TOKEN_TTL=%q, using 1h", value)
+	}
+	return time.Hour
+}
@@ -0,0 +1,88 @@
+package users
+
+import (
+	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/apikey"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm"
+	"log"
+	"os"
+)
+
+// minBootstrapKeyLength is the length of a generated API key, bootstrap keys
+// must be as hard to guess
+const minBootstrapKeyLength = len(apikey.Prefix) + 43
+
+// BootstrapAdmin creates the first admin on startup, as nobody can sign up as
+// one: when no admin exists, the user ADMIN_NAME (default "admin") is created or
+// promoted and can authenticate with the API key in ADMIN_API_KEY. Once an admin
+// exists it does nothing, so the variables can be left set.
+func BootstrapAdmin() error {
+	key := os.Getenv("ADMIN_API_KEY")
+	name := os.Getenv("ADMIN_NAME")
+	if name == "" {
+		name = "admin"
+	}
+	if key != "" {
+		if err := checkBootstrapKey(key); err != nil {
+			return err
+		}
+	}
+
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		// Instances starting together must not both create an admin
+		if err := tx.Exec("LOCK TABLE users IN SHARE ROW EXCLUSIVE MODE").Error; err != nil {
+			return err
+		}
+		var admins int64
+		if err := tx.Model(&models.User{}).Where("role = ?", middleware.RoleAdmin).Count(&admins).Error; err != nil {
+			return err
+		}
+		if admins > 0 {
+			return nil
+		}
+		if key == "" {
+			log.Println("⚠️ No admin exists, set ADMIN_API_KEY (and ADMIN_NAME) to create one")
+			return nil
+		}
+
+		user := models.User{Name: name}
+		if err := tx.Where(models.User{Name: name}).FirstOrCreate(&user).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&user).Update("role", middleware.RoleAdmin).Error; err != nil {
+			return err
+		}
+
+		var existing int64
+		if err := tx.Model(&models.APIKey{}).Where("key_hash = ?", apikey.Hash(key)).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing == 0 {
+			if err := tx.Create(&models.APIKey{
+				UserID:  user.ID,
+				Name:    "bootstrap",
+				Prefix:  key[:len(apikey.Prefix)+8],
+				KeyHash: apikey.Hash(key),
+			}).Error; err != nil {
+				return err
+			}
+		}
+		log.Printf("👑 User %d (%s) is the first admin, authenticated by ADMIN_API_KEY", user.ID, user.Name)
+		return nil
+	})
+}
+
+// checkBootstrapKey rejects ADMIN_API_KEY values that aren't API keys or are
+// too short to be random
+func checkBootstrapKey(key string) error {
+	if !apikey.IsAPIKey(key) {
+		return fmt.Errorf("ADMIN_API_KEY must start with %s", apikey.Prefix)
+	}
+	if len(key) < minBootstrapKeyLength {
+		return fmt.Errorf("ADMIN_API_KEY must be at least %d characters long", minBootstrapKeyLength)
+	}
+	return nil
+}
@@ -3,34 +3,67 @@ package users
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/audit"
 	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
 	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/gorm"
 	"net/http"
+	"strings"
 )
 
-// CreateUser API
+// CreateUser API, the only one that doesn't require authentication
 func CreateUser(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Name string `json:"name"`
+		Role string `json:"role"` // Only "consumer" (default), the other roles are granted by an admin
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
-	user := models.User{Name: req.Name}
-	if err := database.DB.Create(&user).Error; err != nil {
+	if req.Role == "" {
+		req.Role = middleware.RoleConsumer
+	}
+	if !middleware.IsValidRole(req.Role) {
+		http.Error(w, fmt.Sprintf("Invalid role. Valid roles are: %s", strings.Join(middleware.Roles, ", ")), http.StatusBadRequest)
+		return
+	}
+	// Anyone can sign up, so publishing to the catalog and administering are
+	// granted by an admin; the first admin is created on startup (ADMIN_API_KEY)
+	if req.Role != middleware.RoleConsumer {
+		http.Error(w, "Users sign up as consumers, publishers and admins are promoted by an admin", http.StatusForbidden)
+		return
+	}
+
+	// Together with a first API key, which is only returned in this response
+	user := models.User{Name: req.Name, Role: req.Role}
+	var key string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		var err error
+		key, _, err = newAPIKey(tx, user.ID, "default", nil)
+		return err
+	})
+	if err != nil {
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
+	audit.Resource(r, "user", user.ID)
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message": fmt.Sprintf("User: %s created successfully", user.Name),
+		"id":      user.ID,
+		"role":    user.Role,
+		"api_key": key,
 	})
 }
 
-// ListUsers API
+// ListUsers API (only for admins)
 func ListUsers(w http.ResponseWriter, r *http.Request) {
 	var users []models.User
 	database.DB.Find(&users)
@@ -0,0 +1,43 @@
+package users
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateUserOnlySignsUpConsumers(t *testing.T) {
+	for _, role := range []string{"admin", "publisher"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/users/new", strings.NewReader(`{"name": "mallory", "role": "`+role+`"}`))
+		rec := httptest.NewRecorder()
+		CreateUser(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("signing up as %s answered %d, want %d", role, rec.Code, http.StatusForbidden)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/users/new", strings.NewReader(`{"name": "mallory", "role": "root"}`))
+	rec := httptest.NewRecorder()
+	CreateUser(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("signing up with an unknown role answered %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestCheckBootstrapKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"mpk_" + strings.Repeat("a", 43), true},
+		{"mpk_" + strings.Repeat("a", 42), false},
+		{"admin", false},
+		{strings.Repeat("a", 64), false},
+	}
+	for _, tt := range tests {
+		if err := checkBootstrapKey(tt.key); (err == nil) != tt.valid {
+			t.Errorf("checkBootstrapKey(%q) = %v, want valid %v", tt.key, err, tt.valid)
+		}
+	}
+}
@@ -1,27 +1,141 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"github.com/Vinayakatk/marketplace-prototype/internal/apis"
+	"github.com/Vinayakatk/marketplace-prototype/internal/middleware"
 	"github.com/Vinayakatk/marketplace-prototype/internal/queue"
 	"github.com/Vinayakatk/marketplace-prototype/internal/services/billing"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/reconciler"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/deployments/trials"
+	"github.com/Vinayakatk/marketplace-prototype/internal/services/users"
 	"github.com/Vinayakatk/marketplace-prototype/pkg/database"
 	"github.com/go-chi/chi/v5"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
+	// Cancelled on SIGINT/SIGTERM to start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	database.ConnectDatabase()
+	if err := users.BootstrapAdmin(); err != nil {
+		log.Fatal("❌ Failed to create the first admin:", err)
+	}
+	queue.Connect()
 
-	// Start Redis Queue Consumers in Background
-	go queue.StartInstallerConsumer()
-	go queue.StartUninstallerConsumer()
+	// Start Queue Consumers in Background
+	var workers sync.WaitGroup
+	workers.Add(3)
+	go func() {
+		defer workers.Done()
+		queue.StartInstallerConsumer(ctx)
+	}()
+	go func() {
+		defer workers.Done()
+		queue.StartUninstallerConsumer(ctx)
+	}()
+	go func() {
+		defer workers.Done()
+		queue.StartUpgraderConsumer(ctx)
+	}()
 
 	// Start billing background job which will update billing data on hourly basis
-	go billing.StartBillingUpdater()
+	var background sync.WaitGroup
+	background.Add(5)
+	go func() {
+		defer background.Done()
+		billing.StartBillingUpdater(ctx)
+	}()
+
+	// Publish the jobs written to the outbox together with the deployment changes
+	go func() {
+		defer background.Done()
+		queue.StartOutboxRelay(ctx)
+	}()
+
+	// Delete the idempotency keys whose responses expired
+	go func() {
+		defer background.Done()
+		middleware.StartIdempotencyPurge(ctx)
+	}()
+
+	// Fix deployments left "pending" or "installing" by a crashed instance, on startup and periodically
+	go func() {
+		defer background.Done()
+		reconciler.Start(ctx)
+	}()
+
+	// Warn consumers of free trials about to end, then convert or uninstall the expired ones
+	go func() {
+		defer background.Done()
+		trials.Start(ctx)
+	}()
 
 	r := chi.NewRouter()
 	apis.RegisterRoutes(r)
 
-	log.Fatal(http.ListenAndServe(":3000", r))
+	server := &http.Server{Addr: ":3000", Handler: r}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Println("🛑 Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout())
+	defer cancel()
+
+	// Stop accepting requests and let the in-flight ones finish
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("❌ HTTP server shutdown:", err)
+	}
+
+	// Let started provision/clean jobs finish; jobs still running at the deadline stay
+	// unacknowledged and are picked up by another instance
+	if !wait(shutdownCtx, &workers) {
+		log.Println("⚠️ Shutdown deadline reached with jobs still running, leaving them unacknowledged")
+	}
+	wait(shutdownCtx, &background)
+
+	billing.FlushBillingRecords()
+	log.Println("👋 Shutdown complete")
+}
+
+// shutdownTimeout is how long in-flight work may take after a shutdown signal (SHUTDOWN_TIMEOUT)
+func shutdownTimeout() time.Duration {
+	if value := os.Getenv("SHUTDOWN_TIMEOUT"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("⚠️ Invalid SHUTDOWN_TIMEOUT=%q, using 2m", value)
+	}
+	return 2 * time.Minute
+}
+
+// wait blocks until wg is done or ctx expires and reports whether wg finished
+func wait(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
@@ -0,0 +1,38 @@
+// Package apikey generates API keys and the hashes they are stored and looked up by.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// Prefix marks API keys, so they can be told apart from bearer tokens and found by secret scanners
+const Prefix = "mpk_"
+
+// displayLength is how many characters of a key are kept to identify it in listings
+const displayLength = len(Prefix) + 8
+
+// Generate returns a new random API key and its display prefix
+func Generate() (key string, display string, err error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	key = Prefix + base64.RawURLEncoding.EncodeToString(random)
+	return key, key[:displayLength], nil
+}
+
+// IsAPIKey reports whether a credential looks like an API key
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, Prefix)
+}
+
+// Hash returns the hash API keys are stored by. Keys are random and long enough
+// that a plain SHA-256 cannot be brute-forced, unlike passwords.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
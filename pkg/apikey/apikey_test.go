@@ -0,0 +1,55 @@
+package apikey
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	key, display, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if !IsAPIKey(key) || len(key) != len(Prefix)+43 {
+		t.Errorf("Generate = %q, want %s followed by 43 characters", key, Prefix)
+	}
+	if !strings.HasPrefix(key, display) || len(display) != displayLength {
+		t.Errorf("display prefix %q of %q, want its first %d characters", display, key, displayLength)
+	}
+
+	other, _, _ := Generate()
+	if other == key {
+		t.Error("Generate returned the same key twice")
+	}
+}
+
+func TestHash(t *testing.T) {
+	key, _, _ := Generate()
+	other, _, _ := Generate()
+
+	if Hash(key) != Hash(key) {
+		t.Error("Hash is not deterministic")
+	}
+	if Hash(key) == Hash(other) {
+		t.Error("two keys have the same hash")
+	}
+	if hash := Hash(key); len(hash) != 64 || strings.Contains(hash, key) {
+		t.Errorf("Hash = %q, want a hex SHA-256 not holding the key", hash)
+	}
+	if got, want := Hash("mpk_test"), "51e3eebc9f06a034099800f29de7f36f1dad48f38cba7b203881f5606a55f744"; got != want {
+		t.Errorf("Hash(mpk_test) = %s, want its SHA-256 %s", got, want)
+	}
+}
+
+func TestIsAPIKey(t *testing.T) {
+	for credential, want := range map[string]bool{
+		"mpk_abc":        true,
+		"eyJhbGciOiJIUz": false,
+		"MPK_abc":        false,
+		"":               false,
+	} {
+		if got := IsAPIKey(credential); got != want {
+			t.Errorf("IsAPIKey(%q) = %v, want %v", credential, got, want)
+		}
+	}
+}
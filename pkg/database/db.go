@@ -1,12 +1,14 @@
 package database
 
 import (
+	"errors"
 	"fmt"
 	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"log"
 	"os"
+	"strconv"
 )
 
 var DB *gorm.DB
@@ -18,12 +20,193 @@ func ConnectDatabase() {
 		log.Fatal("❌ Failed to connect to the database:", err)
 	}
 
+	if err := keyIdempotencyKeysByUser(db); err != nil {
+		log.Fatal("❌ Failed to migrate idempotency keys:", err)
+	}
+
 	// Auto Migrate Tables
-	err = db.AutoMigrate(&models.User{}, &models.Application{}, &models.Deployment{}, &models.BillingRecord{}, models.Project{})
+	err = db.AutoMigrate(&models.User{}, &models.APIKey{}, &models.Organization{}, &models.Membership{}, &models.Invite{}, &models.Application{}, &models.ApplicationVersion{}, &models.VersionReview{}, &models.PricingPlan{}, &models.Deployment{}, &models.BillingRecord{}, models.Project{}, &models.QueueJob{}, &models.DeploymentRevision{}, &models.DeploymentSecret{}, &models.DeploymentEvent{}, &models.OutboxMessage{}, &models.IdempotencyKey{}, &models.Quota{}, &models.AuditEntry{}, &models.Notification{}, &models.UsageEvent{})
 	if err != nil {
 		log.Fatal("❌ Migration failed:", err)
 	}
 
+	if err := createSearchIndex(db); err != nil {
+		log.Fatal("❌ Failed to create the catalog search index:", err)
+	}
+
+	if err := protectAuditLog(db); err != nil {
+		log.Fatal("❌ Failed to protect the audit log:", err)
+	}
+
+	if err := protectUsageEvents(db); err != nil {
+		log.Fatal("❌ Failed to protect the usage events:", err)
+	}
+
+	if err := backfillApplicationVersions(db); err != nil {
+		log.Fatal("❌ Failed to backfill application versions:", err)
+	}
+
+	if err := backfillUsageEvents(db); err != nil {
+		log.Fatal("❌ Failed to backfill usage events:", err)
+	}
+
 	DB = db
 	fmt.Println("✅ Database connected & migrated successfully!")
 }
+
+// keyIdempotencyKeysByUser scopes the idempotency keys stored while keys were
+// global to their user. Those keys belong to no user, they are not replayed
+// anymore and are purged once they expire.
+func keyIdempotencyKeysByUser(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&models.IdempotencyKey{}) || db.Migrator().HasColumn(&models.IdempotencyKey{}, "UserID") {
+		return nil
+	}
+	return db.Exec(`ALTER TABLE idempotency_keys
+	ADD COLUMN user_id bigint NOT NULL DEFAULT 0,
+	DROP CONSTRAINT idempotency_keys_pkey,
+	ADD PRIMARY KEY (user_id, key)`).Error
+}
+
+// createSearchIndex indexes the text the catalog is searched in, the expression
+// has to match the one of catalog.SearchVector
+func createSearchIndex(db *gorm.DB) error {
+	return db.Exec(`CREATE INDEX IF NOT EXISTS idx_applications_search ON applications
+	USING GIN (to_tsvector('english', coalesce(name, '') || ' ' || coalesce(description, '')))`).Error
+}
+
+// protectAuditLog makes the audit log append-only: a trigger rejects every update
+// and delete of its entries, whoever issues them
+func protectAuditLog(db *gorm.DB) error {
+	return db.Exec(`
+CREATE OR REPLACE FUNCTION reject_audit_entry_change() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit entries are append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_entries_append_only ON audit_entries;
+CREATE TRIGGER audit_entries_append_only
+	BEFORE UPDATE OR DELETE ON audit_entries
+	FOR EACH ROW EXECUTE FUNCTION reject_audit_entry_change();
+`).Error
+}
+
+// protectUsageEvents makes the usage events append-only like the audit log,
+// billing mistakes are corrected by appending events
+func protectUsageEvents(db *gorm.DB) error {
+	return db.Exec(`
+CREATE OR REPLACE FUNCTION reject_usage_event_change() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'usage events are append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS usage_events_append_only ON usage_events;
+CREATE TRIGGER usage_events_append_only
+	BEFORE UPDATE OR DELETE ON usage_events
+	FOR EACH ROW EXECUTE FUNCTION reject_usage_event_change();
+`).Error
+}
+
+// backfillApplicationVersions gives applications created before versioning a
+// published "1.0.0" version with their current spec and pins their deployments to it
+func backfillApplicationVersions(db *gorm.DB) error {
+	var apps []models.Application
+	if err := db.Where("NOT EXISTS (SELECT 1 FROM application_versions v WHERE v.application_id = applications.id)").
+		Find(&apps).Error; err != nil {
+		return err
+	}
+
+	for _, app := range apps {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			version := models.ApplicationVersion{
+				ApplicationID: app.ID,
+				Version:       "1.0.0",
+				Deployment:    app.Deployment,
+				InputsSchema:  app.Inputs,
+				Status:        "published",
+			}
+			if err := tx.Create(&version).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Model(&models.Deployment{}).
+				Where("application_id = ? AND application_version_id IS NULL", app.ID).
+				Update("application_version_id", version.ID).Error
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("📦 Created version 1.0.0 of application %s", app.Name)
+	}
+	return nil
+}
+
+// backfillUsageEvents records the usage of deployments billed before usage
+// events were introduced: "started" on the terms of their billing record and
+// "stopped" once it ended
+func backfillUsageEvents(db *gorm.DB) error {
+	var records []models.BillingRecord
+	if err := db.Preload("Plan").
+		Where("NOT EXISTS (SELECT 1 FROM usage_events e WHERE CAST(e.deployment_id AS TEXT) = billing_records.deployment_id)").
+		Find(&records).Error; err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		deploymentID, err := strconv.ParseUint(record.DeploymentID, 10, 64)
+		if err != nil {
+			log.Printf("⚠️ Skipping billing record %s of invalid deployment %q", record.ID, record.DeploymentID)
+			if err := closeBillingRecord(db, record); err != nil {
+				return err
+			}
+			continue
+		}
+		var deployment models.Deployment
+		err = db.Unscoped().Select("id", "application_version_id").First(&deployment, deploymentID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("⚠️ Skipping billing record %s of deleted deployment %d", record.ID, deploymentID)
+			if err := closeBillingRecord(db, record); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		events := []models.UsageEvent{{
+			DeploymentID:         deployment.ID,
+			Kind:                 "started",
+			OccurredAt:           record.StartTime,
+			HourlyRate:           record.HourlyRate,
+			Plan:                 record.Plan,
+			TrialEndsAt:          record.TrialEndsAt,
+			ApplicationVersionID: deployment.ApplicationVersionID,
+			Actor:                "migration",
+			Message:              "Recorded from billing record " + record.ID,
+		}}
+		if record.EndTime != nil {
+			events = append(events, models.UsageEvent{
+				DeploymentID: deployment.ID,
+				Kind:         "stopped",
+				OccurredAt:   *record.EndTime,
+				Actor:        "migration",
+				Message:      "Recorded from billing record " + record.ID,
+			})
+		}
+		if err := db.Create(&events).Error; err != nil {
+			return err
+		}
+		log.Printf("🧾 Recorded the usage of deployment %d", deployment.ID)
+	}
+	return nil
+}
+
+// closeBillingRecord ends a running billing record that has no usage to rate,
+// when it was last billed, so billing runs stop refreshing it
+func closeBillingRecord(db *gorm.DB, record models.BillingRecord) error {
+	if record.EndTime != nil {
+		return nil
+	}
+	return db.Model(&models.BillingRecord{}).Where("id = ?", record.ID).Update("end_time", record.UpdatedAt).Error
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"github.com/Vinayakatk/marketplace-prototype/pkg/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dryRun returns a database that builds statements without running them, and
+// the updates it was asked to run
+func dryRun(t *testing.T) (*gorm.DB, *[]*gorm.Statement) {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var updates []*gorm.Statement
+	if err := db.Callback().Update().After("gorm:update").Register("test:record", func(tx *gorm.DB) {
+		updates = append(updates, tx.Statement)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return db, &updates
+}
+
+func TestCloseBillingRecord(t *testing.T) {
+	lastBilled := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("running record of a deleted deployment", func(t *testing.T) {
+		db, updates := dryRun(t)
+		if err := closeBillingRecord(db, models.BillingRecord{ID: "42-bill", DeploymentID: "42", UpdatedAt: lastBilled}); err != nil {
+			t.Fatal(err)
+		}
+		if len(*updates) != 1 {
+			t.Fatalf("ran %d updates, want 1", len(*updates))
+		}
+		stmt := (*updates)[0]
+		if sql := stmt.SQL.String(); !strings.Contains(sql, `SET "end_time"=$1`) || !strings.Contains(sql, "WHERE id = $") {
+			t.Errorf("closed the record with %s, want its end_time set by ID", sql)
+		}
+		// Ended when it was last billed, so its amount stays what was rated until then
+		if n := len(stmt.Vars); n < 2 || stmt.Vars[0] != lastBilled || stmt.Vars[n-1] != "42-bill" {
+			t.Errorf("closed the record with %v, want end_time %s on record 42-bill", stmt.Vars, lastBilled)
+		}
+	})
+
+	t.Run("record that already ended", func(t *testing.T) {
+		db, updates := dryRun(t)
+		ended := lastBilled.Add(-time.Hour)
+		if err := closeBillingRecord(db, models.BillingRecord{ID: "42-bill", EndTime: &ended, UpdatedAt: lastBilled}); err != nil {
+			t.Fatal(err)
+		}
+		if len(*updates) != 0 {
+			t.Errorf("ran %d updates on an ended record, want none", len(*updates))
+		}
+	})
+}
@@ -0,0 +1,38 @@
+// Package env reads settings from environment variables, falling back to a
+// default when they are unset or invalid.
+package env
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Duration returns the positive duration in key, e.g. "5m", or fallback
+func Duration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("⚠️ Invalid %s=%q, using %s", key, value, fallback)
+		return fallback
+	}
+	return d
+}
+
+// Int returns the positive integer in key or fallback
+func Int(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		log.Printf("⚠️ Invalid %s=%q, using %d", key, value, fallback)
+		return fallback
+	}
+	return n
+}
@@ -0,0 +1,180 @@
+// Package envelope encrypts small payloads with envelope encryption: every
+// payload gets its own random data key, which is itself encrypted ("wrapped")
+// with a master key. Master keys are configured locally in SECRETS_MASTER_KEYS
+// and can be rotated by rewrapping data keys, without touching the payloads.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// keySize is the size of master and data keys (AES-256)
+const keySize = 32
+
+var (
+	ErrNotConfigured = errors.New("secrets are not configured, set SECRETS_MASTER_KEYS")
+	ErrUnknownKey    = errors.New("master key is not configured")
+)
+
+// Envelope is an encrypted payload together with its wrapped data key
+type Envelope struct {
+	KeyID      string // Master key that wrapped the data key
+	WrappedKey []byte // Data key encrypted with the master key, nonce first
+	Ciphertext []byte // Payload encrypted with the data key, nonce first
+}
+
+// Keyring holds the master keys by ID. New data keys are wrapped with the active one.
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+var (
+	defaultOnce    sync.Once
+	defaultKeyring *Keyring
+	defaultErr     error
+)
+
+// Default returns the keyring configured in SECRETS_MASTER_KEYS, a comma
+// separated list of <key id>:<base64 encoded 32 byte key>. The first key is the
+// active one; the others are only used to decrypt data keys wrapped before a rotation.
+func Default() (*Keyring, error) {
+	defaultOnce.Do(func() {
+		defaultKeyring, defaultErr = ParseKeyring(os.Getenv("SECRETS_MASTER_KEYS"))
+	})
+	return defaultKeyring, defaultErr
+}
+
+// ParseKeyring reads a keyring in the format of SECRETS_MASTER_KEYS
+func ParseKeyring(spec string) (*Keyring, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, ErrNotConfigured
+	}
+
+	k := &Keyring{keys: make(map[string][]byte)}
+	for _, entry := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid master key entry %q, expected <key id>:<base64 key>", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key %s: %w", id, err)
+		}
+		if len(key) != keySize {
+			return nil, fmt.Errorf("invalid master key %s: must be %d bytes, got %d", id, keySize, len(key))
+		}
+		if _, exists := k.keys[id]; exists {
+			return nil, fmt.Errorf("duplicate master key %s", id)
+		}
+		if k.active == "" {
+			k.active = id
+		}
+		k.keys[id] = key
+	}
+	return k, nil
+}
+
+// ActiveKeyID returns the ID of the master key new data keys are wrapped with
+func (k *Keyring) ActiveKeyID() string {
+	return k.active
+}
+
+// Encrypt encrypts plaintext with a new data key wrapped by the active master key
+func (k *Keyring) Encrypt(plaintext []byte) (Envelope, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return Envelope{}, err
+	}
+
+	ciphertext, err := seal(dataKey, plaintext, nil)
+	if err != nil {
+		return Envelope{}, err
+	}
+	wrapped, err := seal(k.keys[k.active], dataKey, []byte(k.active))
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{KeyID: k.active, WrappedKey: wrapped, Ciphertext: ciphertext}, nil
+}
+
+// Decrypt returns the plaintext of an envelope wrapped by any configured master key
+func (k *Keyring) Decrypt(e Envelope) ([]byte, error) {
+	dataKey, err := k.unwrap(e)
+	if err != nil {
+		return nil, err
+	}
+	return open(dataKey, e.Ciphertext, nil)
+}
+
+// Rewrap wraps the data key of an envelope with the active master key. The
+// ciphertext is unchanged. Envelopes already wrapped by the active key are returned as is.
+func (k *Keyring) Rewrap(e Envelope) (Envelope, error) {
+	if e.KeyID == k.active {
+		return e, nil
+	}
+
+	dataKey, err := k.unwrap(e)
+	if err != nil {
+		return e, err
+	}
+	wrapped, err := seal(k.keys[k.active], dataKey, []byte(k.active))
+	if err != nil {
+		return e, err
+	}
+	return Envelope{KeyID: k.active, WrappedKey: wrapped, Ciphertext: e.Ciphertext}, nil
+}
+
+func (k *Keyring) unwrap(e Envelope) ([]byte, error) {
+	masterKey, ok := k.keys[e.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, e.KeyID)
+	}
+	dataKey, err := open(masterKey, e.WrappedKey, []byte(e.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// seal encrypts plaintext with AES-GCM, prefixing the result with the nonce
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,158 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// newKey returns a random master key in the format of SECRETS_MASTER_KEYS
+func newKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func keyring(t *testing.T, spec string) *Keyring {
+	t.Helper()
+	k, err := ParseKeyring(spec)
+	if err != nil {
+		t.Fatalf("ParseKeyring: %v", err)
+	}
+	return k
+}
+
+func TestParseKeyring(t *testing.T) {
+	k1, k2 := newKey(t), newKey(t)
+
+	k := keyring(t, " k2:"+k2+", k1:"+k1)
+	if k.ActiveKeyID() != "k2" || len(k.keys) != 2 {
+		t.Errorf("ParseKeyring = active %s with %d keys, want k2 first of 2", k.ActiveKeyID(), len(k.keys))
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		err  string // Part of the error
+	}{
+		{"not configured", "  ", ErrNotConfigured.Error()},
+		{"missing key ID", ":" + k1, "invalid master key entry"},
+		{"missing separator", k1, "invalid master key entry"},
+		{"not base64", "k1:not base64!", "invalid master key k1"},
+		{"short key", "k1:" + base64.StdEncoding.EncodeToString([]byte("short")), "must be 32 bytes, got 5"},
+		{"duplicate key ID", "k1:" + k1 + ",k1:" + k2, "duplicate master key k1"},
+	}
+	for _, tt := range tests {
+		if _, err := ParseKeyring(tt.spec); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: ParseKeyring = %v, want an error containing %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	k := keyring(t, "k1:"+newKey(t))
+	plaintext := []byte(`{"adminPassword":"hunter2hunter2"}`)
+
+	e, err := k.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if e.KeyID != "k1" {
+		t.Errorf("envelope wrapped by %s, want the active key k1", e.KeyID)
+	}
+	if bytes.Contains(e.Ciphertext, []byte("hunter2")) {
+		t.Error("ciphertext holds the plaintext")
+	}
+	got, err := k.Decrypt(e)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("Decrypt = %s, want %s", got, plaintext)
+	}
+
+	// Every payload gets its own data key and nonces
+	other, _ := k.Encrypt(plaintext)
+	if bytes.Equal(other.Ciphertext, e.Ciphertext) || bytes.Equal(other.WrappedKey, e.WrappedKey) {
+		t.Error("two encryptions of the same payload are identical")
+	}
+}
+
+func TestDecryptDetectsTampering(t *testing.T) {
+	k := keyring(t, "k1:"+newKey(t)+",k2:"+newKey(t))
+	e, err := k.Encrypt([]byte("secret payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := k.Encrypt([]byte("other payload"))
+
+	flip := func(b []byte, i int) []byte {
+		c := append([]byte{}, b...)
+		c[i] ^= 0x01
+		return c
+	}
+	tests := []struct {
+		name     string
+		envelope Envelope
+	}{
+		{"ciphertext", Envelope{KeyID: e.KeyID, WrappedKey: e.WrappedKey, Ciphertext: flip(e.Ciphertext, len(e.Ciphertext)-1)}},
+		{"nonce of the ciphertext", Envelope{KeyID: e.KeyID, WrappedKey: e.WrappedKey, Ciphertext: flip(e.Ciphertext, 0)}},
+		{"wrapped key", Envelope{KeyID: e.KeyID, WrappedKey: flip(e.WrappedKey, len(e.WrappedKey)-1), Ciphertext: e.Ciphertext}},
+		{"data key of another envelope", Envelope{KeyID: e.KeyID, WrappedKey: other.WrappedKey, Ciphertext: e.Ciphertext}},
+		{"key ID relabelled", Envelope{KeyID: "k2", WrappedKey: e.WrappedKey, Ciphertext: e.Ciphertext}},
+		{"truncated ciphertext", Envelope{KeyID: e.KeyID, WrappedKey: e.WrappedKey, Ciphertext: e.Ciphertext[:4]}},
+	}
+	for _, tt := range tests {
+		if got, err := k.Decrypt(tt.envelope); err == nil {
+			t.Errorf("%s tampered: Decrypt = %q, want an error", tt.name, got)
+		}
+	}
+
+	if _, err := k.Decrypt(Envelope{KeyID: "k9", WrappedKey: e.WrappedKey, Ciphertext: e.Ciphertext}); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt with an unknown key = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	k1, k2 := newKey(t), newKey(t)
+	old := keyring(t, "k1:"+k1)
+	e, err := old.Encrypt([]byte("secret payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// k2 is prepended, so it is active and k1 only decrypts
+	rotating := keyring(t, "k2:"+k2+",k1:"+k1)
+	if got, err := rotating.Decrypt(e); err != nil || string(got) != "secret payload" {
+		t.Fatalf("Decrypt of an envelope of the previous key = %q, %v", got, err)
+	}
+	rewrapped, err := rotating.Rewrap(e)
+	if err != nil {
+		t.Fatalf("Rewrap: %v", err)
+	}
+	if rewrapped.KeyID != "k2" || !bytes.Equal(rewrapped.Ciphertext, e.Ciphertext) {
+		t.Errorf("Rewrap = key %s, want k2 and the ciphertext unchanged", rewrapped.KeyID)
+	}
+
+	// Once rewrapped, the old key can be removed
+	rotated := keyring(t, "k2:"+k2)
+	if got, err := rotated.Decrypt(rewrapped); err != nil || string(got) != "secret payload" {
+		t.Errorf("Decrypt after the rotation = %q, %v", got, err)
+	}
+	if _, err := rotated.Decrypt(e); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt of an envelope that was not rewrapped = %v, want %v", err, ErrUnknownKey)
+	}
+
+	if again, err := rotating.Rewrap(rewrapped); err != nil || !bytes.Equal(again.WrappedKey, rewrapped.WrappedKey) {
+		t.Errorf("Rewrap of an envelope of the active key = %v, want it as is", err)
+	}
+	if _, err := old.Rewrap(Envelope{KeyID: "k9", WrappedKey: e.WrappedKey}); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Rewrap with an unknown key = %v, want %v", err, ErrUnknownKey)
+	}
+}
@@ -0,0 +1,400 @@
+// Package jsonschema implements the subset of JSON Schema publishers use to
+// declare the inputs of an application: types, required properties, defaults,
+// enums, bounds, patterns and a "secret" flag for sensitive inputs.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Types supported in schemas
+const (
+	TypeObject  = "object"
+	TypeArray   = "array"
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+)
+
+// Schema describes a value. The root schema of application inputs is an object.
+type Schema struct {
+	Type        string             `json:"type,omitempty"`
+	Title       string             `json:"title,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Enum        []interface{}      `json:"enum,omitempty"`
+	Default     interface{}        `json:"default,omitempty"`
+	Minimum     *float64           `json:"minimum,omitempty"`
+	Maximum     *float64           `json:"maximum,omitempty"`
+	MinLength   *int               `json:"minLength,omitempty"`
+	MaxLength   *int               `json:"maxLength,omitempty"`
+	Pattern     string             `json:"pattern,omitempty"`
+	Secret      bool               `json:"secret,omitempty"` // Sensitive input, e.g. a password or license key
+
+	pattern *regexp.Regexp
+}
+
+// FieldError is a validation error of one field, identified by its dotted path
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// Parse reads a schema declared as JSON. A nil or empty map yields a nil
+// schema, which accepts any values. Maps that are not schemas (no "type" nor
+// "properties") are inputs declared before schemas were supported: every key
+// becomes an optional property with its value as default.
+func Parse(raw map[string]interface{}) (*Schema, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	_, hasType := raw["type"]
+	_, hasProperties := raw["properties"]
+	if !hasType && !hasProperties {
+		return fromDefaults(raw), nil
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid inputs schema: %w", err)
+	}
+	if s.Type == "" {
+		s.Type = TypeObject
+	}
+	if s.Type != TypeObject {
+		return nil, fmt.Errorf("invalid inputs schema: the root must be an object, not %s", s.Type)
+	}
+	if err := s.compile(""); err != nil {
+		return nil, fmt.Errorf("invalid inputs schema: %w", err)
+	}
+	return &s, nil
+}
+
+func fromDefaults(defaults map[string]interface{}) *Schema {
+	s := &Schema{Type: TypeObject, Properties: make(map[string]*Schema, len(defaults))}
+	for key, value := range defaults {
+		s.Properties[key] = &Schema{Type: typeOf(value), Default: value}
+	}
+	return s
+}
+
+// compile checks the schema and its children and compiles their patterns
+func (s *Schema) compile(path string) error {
+	switch s.Type {
+	case TypeObject, TypeArray, TypeString, TypeInteger, TypeNumber, TypeBoolean:
+	case "":
+		return fmt.Errorf("%s: missing type", describe(path))
+	default:
+		return fmt.Errorf("%s: unsupported type %q", describe(path), s.Type)
+	}
+
+	for _, name := range s.Required {
+		if _, ok := s.Properties[name]; !ok {
+			return fmt.Errorf("%s: required property %q is not declared", describe(path), name)
+		}
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", describe(path), err)
+		}
+		s.pattern = re
+	}
+	for _, value := range s.Enum {
+		if errs := s.validateType(path, value); len(errs) > 0 {
+			return fmt.Errorf("%s: enum value %v is not a %s", describe(path), value, s.Type)
+		}
+	}
+
+	for name, property := range s.Properties {
+		if property == nil {
+			return fmt.Errorf("%s: empty schema", describe(join(path, name)))
+		}
+		if err := property.compile(join(path, name)); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.compile(path + "[]"); err != nil {
+			return err
+		}
+	}
+
+	if s.Default != nil {
+		if errs := s.validate(path, toJSON(s.Default)); len(errs) > 0 {
+			return fmt.Errorf("%s: invalid default: %s", describe(path), errs[0].Message)
+		}
+	}
+	return nil
+}
+
+// Apply fills in the defaults of missing properties and validates the result.
+// values is not modified. A nil schema accepts any values as they are.
+func (s *Schema) Apply(values map[string]interface{}) (map[string]interface{}, []FieldError) {
+	if s == nil {
+		return values, nil
+	}
+
+	merged, _ := s.withDefaults(toJSON(values)).(map[string]interface{})
+	if merged == nil {
+		merged = make(map[string]interface{})
+	}
+	return merged, s.validate("", merged)
+}
+
+// SecretFields returns the dotted paths of the properties flagged secret
+func (s *Schema) SecretFields() []string {
+	if s == nil {
+		return nil
+	}
+	var fields []string
+	s.collectSecrets("", &fields)
+	sort.Strings(fields)
+	return fields
+}
+
+// RedactDefaults returns a copy of a schema declared as JSON with the defaults
+// of the properties flagged secret replaced by mask, so they can be shown to consumers
+func RedactDefaults(raw map[string]interface{}, mask string) map[string]interface{} {
+	if raw == nil {
+		return nil
+	}
+	redacted := make(map[string]interface{}, len(raw))
+	for key, value := range raw {
+		redacted[key] = value
+	}
+	if secret, _ := raw["secret"].(bool); secret {
+		if _, ok := raw["default"]; ok {
+			redacted["default"] = mask
+		}
+	}
+	if properties, ok := raw["properties"].(map[string]interface{}); ok {
+		copied := make(map[string]interface{}, len(properties))
+		for name, property := range properties {
+			if nested, ok := property.(map[string]interface{}); ok {
+				property = RedactDefaults(nested, mask)
+			}
+			copied[name] = property
+		}
+		redacted["properties"] = copied
+	}
+	return redacted
+}
+
+func (s *Schema) collectSecrets(path string, fields *[]string) {
+	if s.Secret && path != "" {
+		*fields = append(*fields, path)
+		return
+	}
+	for name, property := range s.Properties {
+		property.collectSecrets(join(path, name), fields)
+	}
+}
+
+func (s *Schema) withDefaults(value interface{}) interface{} {
+	if value == nil && s.Default != nil {
+		value = toJSON(s.Default)
+	}
+	if s.Type != TypeObject {
+		return value
+	}
+
+	object, ok := value.(map[string]interface{})
+	if value == nil {
+		object, ok = make(map[string]interface{}), true
+	}
+	if !ok {
+		return value // Reported by validate
+	}
+
+	merged := make(map[string]interface{}, len(object))
+	for key, v := range object {
+		merged[key] = v
+	}
+	for name, property := range s.Properties {
+		if v := property.withDefaults(merged[name]); v != nil {
+			merged[name] = v
+		}
+	}
+	if len(merged) == 0 && value == nil {
+		return nil
+	}
+	return merged
+}
+
+func (s *Schema) validate(path string, value interface{}) []FieldError {
+	if errs := s.validateType(path, value); len(errs) > 0 {
+		return errs
+	}
+
+	var errs []FieldError
+	if len(s.Enum) > 0 && !contains(s.Enum, value) {
+		errs = append(errs, fieldError(path, "must be one of %s", formatEnum(s.Enum)))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				errs = append(errs, fieldError(join(path, name), "is required"))
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				// Unknown properties are passed to the chart as they are
+				continue
+			}
+			errs = append(errs, property.validate(join(path, name), v[name])...)
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				errs = append(errs, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+		}
+	case string:
+		length := len([]rune(v))
+		if s.MinLength != nil && length < *s.MinLength {
+			errs = append(errs, fieldError(path, "must be at least %d characters long", *s.MinLength))
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			errs = append(errs, fieldError(path, "must be at most %d characters long", *s.MaxLength))
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			errs = append(errs, fieldError(path, "must match %s", s.Pattern))
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			errs = append(errs, fieldError(path, "must be at least %v", *s.Minimum))
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			errs = append(errs, fieldError(path, "must be at most %v", *s.Maximum))
+		}
+	}
+	return errs
+}
+
+func (s *Schema) validateType(path string, value interface{}) []FieldError {
+	valid := false
+	switch s.Type {
+	case TypeObject:
+		_, valid = value.(map[string]interface{})
+	case TypeArray:
+		_, valid = value.([]interface{})
+	case TypeString:
+		_, valid = value.(string)
+	case TypeBoolean:
+		_, valid = value.(bool)
+	case TypeNumber:
+		_, valid = value.(float64)
+	case TypeInteger:
+		n, ok := value.(float64)
+		valid = ok && n == math.Trunc(n)
+	}
+	if !valid {
+		return []FieldError{fieldError(path, "must be %s %s", article(s.Type), s.Type)}
+	}
+	return nil
+}
+
+// toJSON normalizes a value to what encoding/json decodes into interface{}
+// (float64 numbers, []interface{} arrays), so defaults and values compare alike
+func toJSON(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return value
+	}
+	return normalized
+}
+
+func typeOf(value interface{}) string {
+	switch v := toJSON(value).(type) {
+	case map[string]interface{}:
+		return TypeObject
+	case []interface{}:
+		return TypeArray
+	case string:
+		return TypeString
+	case bool:
+		return TypeBoolean
+	case float64:
+		if v == math.Trunc(v) {
+			return TypeInteger
+		}
+		return TypeNumber
+	}
+	return TypeString
+}
+
+func contains(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if reflect.DeepEqual(toJSON(allowed), value) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatEnum(enum []interface{}) string {
+	values := make([]string, 0, len(enum))
+	for _, value := range enum {
+		data, _ := json.Marshal(value)
+		values = append(values, string(data))
+	}
+	return strings.Join(values, ", ")
+}
+
+func fieldError(path, format string, args ...interface{}) FieldError {
+	return FieldError{Field: describe(path), Message: fmt.Sprintf(format, args...)}
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func describe(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return path
+}
+
+func article(word string) string {
+	if strings.ContainsRune("aeiou", rune(word[0])) {
+		return "an"
+	}
+	return "a"
+}
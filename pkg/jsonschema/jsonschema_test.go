@@ -0,0 +1,232 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// inputsSchema is the inputs schema of a typical application
+const inputsSchema = `{
+	"type": "object",
+	"required": ["adminUser"],
+	"properties": {
+		"adminUser": {"type": "string", "minLength": 3, "maxLength": 16, "pattern": "^[a-z]+$"},
+		"adminPassword": {"type": "string", "minLength": 8, "secret": true},
+		"replicaCount": {"type": "integer", "minimum": 1, "maximum": 5, "default": 1},
+		"ratio": {"type": "number", "minimum": 0, "maximum": 1},
+		"tier": {"type": "string", "enum": ["small", "large"], "default": "small"},
+		"debug": {"type": "boolean"},
+		"hosts": {"type": "array", "items": {"type": "string", "minLength": 1}},
+		"auth": {
+			"type": "object",
+			"properties": {
+				"enabled": {"type": "boolean", "default": true},
+				"token": {"type": "string", "secret": true, "default": "changeme"}
+			}
+		}
+	}
+}`
+
+// parse parses a schema declared as JSON
+func parse(t *testing.T, raw string) *Schema {
+	t.Helper()
+	var declared map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &declared); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Parse(declared)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return s
+}
+
+// values decodes values as they arrive in requests
+func values(t *testing.T, raw string) map[string]interface{} {
+	t.Helper()
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestParseRejectsInvalidSchemas(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		err    string // Part of the error
+	}{
+		{"root that is no object", `{"type": "string"}`, "the root must be an object"},
+		{"property without type", `{"properties": {"a": {"minimum": 1}}}`, "a: missing type"},
+		{"unsupported type", `{"properties": {"a": {"type": "date"}}}`, `a: unsupported type "date"`},
+		{"undeclared required property", `{"type": "object", "required": ["a"]}`, `required property "a" is not declared`},
+		{"invalid pattern", `{"properties": {"a": {"type": "string", "pattern": "("}}}`, "a: invalid pattern"},
+		{"enum of another type", `{"properties": {"a": {"type": "integer", "enum": [1, "two"]}}}`, "a: enum value two is not a integer"},
+		{"invalid default", `{"properties": {"a": {"type": "integer", "maximum": 3, "default": 5}}}`, "a: invalid default: must be at most 3"},
+		{"invalid nested default", `{"properties": {"a": {"type": "object", "properties": {"b": {"type": "boolean", "default": "yes"}}}}}`, "a.b: invalid default: must be a boolean"},
+		{"empty property", `{"properties": {"a": null}}`, "a: empty schema"},
+		{"properties that are no object", `{"type": "object", "properties": []}`, "invalid inputs schema"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var declared map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.schema), &declared); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Parse(declared); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Parse = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseLegacyInputs(t *testing.T) {
+	if s, err := Parse(nil); s != nil || err != nil {
+		t.Errorf("Parse(nil) = %v, %v, want a nil schema", s, err)
+	}
+	var accepted *Schema
+	if got, errs := accepted.Apply(map[string]interface{}{"anything": 1}); len(errs) > 0 || got["anything"] != 1 {
+		t.Errorf("nil schema Apply = %v, %v, want the values as they are", got, errs)
+	}
+
+	// Inputs declared before schemas: every key is optional, with its value as default
+	s, err := Parse(map[string]interface{}{"replicaCount": 2, "image": map[string]interface{}{"tag": "1.25"}})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if s.Properties["replicaCount"].Type != TypeInteger || s.Properties["image"].Type != TypeObject {
+		t.Errorf("legacy inputs parsed as %+v, want typed properties", s.Properties)
+	}
+	got, errs := s.Apply(values(t, `{"image": {"tag": "1.26"}}`))
+	if len(errs) > 0 {
+		t.Fatalf("Apply: %v", errs)
+	}
+	if want := values(t, `{"replicaCount": 2, "image": {"tag": "1.26"}}`); !reflect.DeepEqual(got, want) {
+		t.Errorf("Apply = %v, want %v", got, want)
+	}
+	if _, errs := s.Apply(values(t, `{"replicaCount": "two"}`)); len(errs) != 1 || errs[0].Field != "replicaCount" {
+		t.Errorf("Apply of a value of another type = %v, want an error on replicaCount", errs)
+	}
+}
+
+func TestApplyValidates(t *testing.T) {
+	s := parse(t, inputsSchema)
+
+	tests := []struct {
+		name   string
+		values string
+		errors []FieldError // nil if valid
+	}{
+		{"minimal", `{"adminUser": "root"}`, nil},
+		{"everything set", `{"adminUser": "root", "adminPassword": "s3cretpass", "replicaCount": 5, "ratio": 0.5,
+			"tier": "large", "debug": true, "hosts": ["a.example.com"], "auth": {"enabled": false, "token": "t"}}`, nil},
+		{"unknown properties are passed on", `{"adminUser": "root", "ingress": {"enabled": true}}`, nil},
+		{"integer written as a float", `{"adminUser": "root", "replicaCount": 2.0}`, nil},
+		{"missing required", `{}`, []FieldError{{"adminUser", "is required"}}},
+		{"null required", `{"adminUser": null}`, []FieldError{{"adminUser", "must be a string"}}},
+		{"too short", `{"adminUser": "ro"}`, []FieldError{{"adminUser", "must be at least 3 characters long"}}},
+		{"too long", `{"adminUser": "abcdefghijklmnopq"}`, []FieldError{{"adminUser", "must be at most 16 characters long"}}},
+		{"length in characters", `{"adminUser": "root", "adminPassword": "pässwörd"}`, nil},
+		{"pattern", `{"adminUser": "Root"}`, []FieldError{{"adminUser", "must match ^[a-z]+$"}}},
+		{"not an integer", `{"adminUser": "root", "replicaCount": 1.5}`, []FieldError{{"replicaCount", "must be an integer"}}},
+		{"below minimum", `{"adminUser": "root", "replicaCount": 0}`, []FieldError{{"replicaCount", "must be at least 1"}}},
+		{"above maximum", `{"adminUser": "root", "ratio": 1.5}`, []FieldError{{"ratio", "must be at most 1"}}},
+		{"not in enum", `{"adminUser": "root", "tier": "medium"}`, []FieldError{{"tier", `must be one of "small", "large"`}}},
+		{"wrong type", `{"adminUser": "root", "debug": "yes"}`, []FieldError{{"debug", "must be a boolean"}}},
+		{"invalid array item", `{"adminUser": "root", "hosts": ["a", ""]}`, []FieldError{{"hosts[1]", "must be at least 1 characters long"}}},
+		{"nested", `{"adminUser": "root", "auth": {"enabled": "no"}}`, []FieldError{{"auth.enabled", "must be a boolean"}}},
+		{"object that is no object", `{"adminUser": "root", "auth": true}`, []FieldError{{"auth", "must be an object"}}},
+		{"several errors in field order", `{"tier": "huge", "replicaCount": 9}`, []FieldError{
+			{"adminUser", "is required"},
+			{"replicaCount", "must be at most 5"},
+			{"tier", `must be one of "small", "large"`},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := s.Apply(values(t, tt.values))
+			if !reflect.DeepEqual(errs, tt.errors) {
+				t.Errorf("Apply errors = %v, want %v", errs, tt.errors)
+			}
+		})
+	}
+}
+
+func TestApplyFillsDefaults(t *testing.T) {
+	s := parse(t, inputsSchema)
+
+	given := values(t, `{"adminUser": "root", "tier": "large", "auth": {"enabled": false}}`)
+	got, errs := s.Apply(given)
+	if len(errs) > 0 {
+		t.Fatalf("Apply: %v", errs)
+	}
+	want := values(t, `{"adminUser": "root", "replicaCount": 1, "tier": "large", "auth": {"enabled": false, "token": "changeme"}}`)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Apply = %v, want %v", got, want)
+	}
+	if _, ok := given["replicaCount"]; ok {
+		t.Error("Apply modified the given values")
+	}
+	if _, ok := given["auth"].(map[string]interface{})["token"]; ok {
+		t.Error("Apply modified the given nested values")
+	}
+
+	// Objects missing altogether get the defaults of their properties
+	got, _ = s.Apply(values(t, `{"adminUser": "root"}`))
+	if auth, _ := got["auth"].(map[string]interface{}); auth["enabled"] != true || auth["token"] != "changeme" {
+		t.Errorf("Apply without auth = %v, want its defaults", got["auth"])
+	}
+	// Null properties are missing ones
+	if got, errs := s.Apply(values(t, `{"adminUser": "root", "replicaCount": null}`)); len(errs) != 0 || got["replicaCount"] != float64(1) {
+		t.Errorf("Apply with a null property = %v, %v, want its default", got["replicaCount"], errs)
+	}
+}
+
+func TestSecretFields(t *testing.T) {
+	s := parse(t, inputsSchema)
+	if got, want := s.SecretFields(), []string{"adminPassword", "auth.token"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SecretFields = %v, want %v", got, want)
+	}
+
+	// A secret object is secret as a whole
+	s = parse(t, `{"properties": {"tls": {"type": "object", "secret": true, "properties": {"key": {"type": "string", "secret": true}}}}}`)
+	if got, want := s.SecretFields(), []string{"tls"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SecretFields of a secret object = %v, want %v", got, want)
+	}
+
+	var none *Schema
+	if got := none.SecretFields(); got != nil {
+		t.Errorf("SecretFields of a nil schema = %v, want none", got)
+	}
+}
+
+func TestRedactDefaults(t *testing.T) {
+	declared := values(t, inputsSchema)
+	redacted := RedactDefaults(declared, "***")
+
+	auth := redacted["properties"].(map[string]interface{})["auth"].(map[string]interface{})
+	token := auth["properties"].(map[string]interface{})["token"].(map[string]interface{})
+	if token["default"] != "***" {
+		t.Errorf("secret default = %v, want it masked", token["default"])
+	}
+	replicas := redacted["properties"].(map[string]interface{})["replicaCount"].(map[string]interface{})
+	if replicas["default"] != float64(1) {
+		t.Errorf("plain default = %v, want it kept", replicas["default"])
+	}
+	// Secret properties without a default don't get one
+	password := redacted["properties"].(map[string]interface{})["adminPassword"].(map[string]interface{})
+	if _, ok := password["default"]; ok {
+		t.Errorf("secret without default got %v", password["default"])
+	}
+
+	original := declared["properties"].(map[string]interface{})["auth"].(map[string]interface{})["properties"].(map[string]interface{})["token"].(map[string]interface{})
+	if original["default"] != "changeme" {
+		t.Error("RedactDefaults modified the declared schema")
+	}
+	if RedactDefaults(nil, "***") != nil {
+		t.Error("RedactDefaults(nil) is not nil")
+	}
+}
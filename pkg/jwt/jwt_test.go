@@ -0,0 +1,71 @@
+package jwt
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var secret = []byte("test-secret")
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	claims := Claims{Subject: "7", Issuer: "marketplace-prototype", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()}
+
+	token, err := Sign(claims, secret)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	got, err := Verify(token, secret, now)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if got != claims {
+		t.Errorf("Verify = %+v, want %+v", got, claims)
+	}
+}
+
+// forge builds a token from raw header and claims, signed with key
+func forge(t *testing.T, header, claims string, key []byte) string {
+	t.Helper()
+	unsigned := encoding.EncodeToString([]byte(header)) + "." + encoding.EncodeToString([]byte(claims))
+	return unsigned + "." + encoding.EncodeToString(signature(unsigned, key))
+}
+
+func TestVerifyRejects(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	exp := now.Add(time.Hour).Unix()
+	valid, err := Sign(Claims{Subject: "7", ExpiresAt: exp}, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, ".")
+	admin, _ := json.Marshal(Claims{Subject: "1", ExpiresAt: exp})
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"other secret", forge(t, `{"alg":"HS256"}`, `{"sub":"7","exp":1700003600}`, []byte("other")), ErrInvalidSignature},
+		{"tampered claims", parts[0] + "." + encoding.EncodeToString(admin) + "." + parts[2], ErrInvalidSignature},
+		{"alg none", encoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + ".", ErrUnsupportedAlg},
+		{"alg HS512", forge(t, `{"alg":"HS512"}`, `{"sub":"7","exp":1700003600}`, secret), ErrUnsupportedAlg},
+		{"alg RS256 signed with the secret", forge(t, `{"alg":"RS256"}`, `{"sub":"7","exp":1700003600}`, secret), ErrUnsupportedAlg},
+		{"expired", forge(t, `{"alg":"HS256"}`, `{"sub":"7","exp":1700000000}`, secret), ErrExpired},
+		{"not valid yet", forge(t, `{"alg":"HS256"}`, `{"sub":"7","exp":1700003600,"nbf":1700000060}`, secret), ErrNotYetValid},
+		{"missing exp", forge(t, `{"alg":"HS256"}`, `{"sub":"7"}`, secret), ErrMalformed},
+		{"two segments", parts[0] + "." + parts[1], ErrMalformed},
+		{"header that is no JSON", forge(t, `alg`, `{"sub":"7","exp":1700003600}`, secret), ErrMalformed},
+		{"signature that is no base64", parts[0] + "." + parts[1] + ".!!!", ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Verify(tt.token, secret, now); !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
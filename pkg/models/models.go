@@ -1,17 +1,72 @@
 package models
 
-import "time"
+import (
+	"gorm.io/gorm"
+	"time"
+)
 
 type User struct {
 	ID   uint   `gorm:"primaryKey"`
 	Name string `gorm:"unique"`
+	Role string `gorm:"type:varchar(20);default:'consumer'"` // Possible values: "admin", "publisher", "consumer"
 }
 
-// Project represents a group of deployments under a user
+// APIKey authenticates a user. Only the hash of the key is stored; the key itself
+// is shown once, when it is created.
+type APIKey struct {
+	ID         uint `gorm:"primaryKey"`
+	UserID     uint `gorm:"index"`
+	Name       string
+	Prefix     string     // First characters of the key, to tell keys apart
+	KeyHash    string     `gorm:"uniqueIndex" json:"-"`
+	ExpiresAt  *time.Time // Never expires if nil
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+// Organization groups users that share projects
+type Organization struct {
+	ID        uint   `gorm:"primaryKey"`
+	Name      string `gorm:"unique"`
+	CreatedAt time.Time
+
+	Members []Membership `gorm:"foreignKey:OrganizationID"`
+}
+
+// Membership gives a user a role in an organization
+type Membership struct {
+	ID             uint   `gorm:"primaryKey"`
+	OrganizationID uint   `gorm:"uniqueIndex:idx_memberships_user"`
+	UserID         uint   `gorm:"uniqueIndex:idx_memberships_user;index"`
+	Role           string `gorm:"type:varchar(20)"` // Possible values: "owner", "maintainer", "viewer"
+	CreatedAt      time.Time
+
+	User User `gorm:"foreignKey:UserID"`
+}
+
+// Invite offers a user a membership, which they accept or decline
+type Invite struct {
+	ID             uint   `gorm:"primaryKey"`
+	OrganizationID uint   `gorm:"index"`
+	UserID         uint   `gorm:"index"` // Invited user
+	Role           string `gorm:"type:varchar(20)"`
+	InvitedBy      uint
+	Status         string `gorm:"type:varchar(20);default:'pending'"` // Possible values: "pending", "accepted", "declined", "revoked"
+	ExpiresAt      time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+
+	Organization Organization `gorm:"foreignKey:OrganizationID"`
+}
+
+// Project represents a group of deployments under a user, or under an
+// organization whose members share it
 type Project struct {
-	ID     uint   `gorm:"primaryKey"`
-	Name   string `gorm:"unique"`
-	UserID uint   // Owner of the project
+	ID             uint   `gorm:"primaryKey"`
+	Name           string `gorm:"unique"`
+	UserID         uint   // Owner of the project
+	OrganizationID *uint  `gorm:"index;default:null"` // Organization sharing the project, if any
 
 	User        User         `gorm:"foreignKey:UserID"`
 	Deployments []Deployment `gorm:"foreignKey:ProjectID"`
@@ -23,10 +78,108 @@ type Application struct {
 	Description string
 	PublisherID uint
 	HourlyRate  float64        // 💰 Cost per hour
-	Deployment  DeploymentSpec `gorm:"embedded"` // Embedded struct for deployment details
+	Deployment  DeploymentSpec `gorm:"embedded"` // Deployment details of the latest published version
 	Publisher   User           `gorm:"foreignKey:PublisherID"`
 
-	Inputs map[string]interface{} `gorm:"type:jsonb"` // Store input fields as JSON
+	TrialDays int // Length of the free trial consumers can start, none if 0
+
+	Category string   `gorm:"type:varchar(50);default:'other';index"` // One of catalog.Categories
+	Tags     []string `gorm:"type:jsonb;serializer:json"`             // Free-form, lowercase tags
+
+	Inputs map[string]interface{} `gorm:"type:jsonb;serializer:json"` // Input fields of the latest published version
+
+	Versions []ApplicationVersion `gorm:"foreignKey:ApplicationID"`
+}
+
+// ApplicationVersion is a release of an application. Its deployment spec never
+// changes once created, so deployments pinned to it keep getting what they installed.
+type ApplicationVersion struct {
+	ID            uint                   `gorm:"primaryKey"`
+	ApplicationID uint                   `gorm:"uniqueIndex:idx_application_versions_version"`
+	Version       string                 `gorm:"uniqueIndex:idx_application_versions_version"` // Semantic version, e.g. "1.2.0"
+	ChartVersion  string                 // Helm chart version (only for Kubernetes-based apps), latest if empty
+	Deployment    DeploymentSpec         `gorm:"embedded"`
+	InputsSchema  map[string]interface{} `gorm:"type:jsonb;serializer:json"` // Input fields accepted by this version
+	ReleaseNotes  string
+	Status        string     `gorm:"type:varchar(20);default:'draft'"` // Possible values: "draft", "in_review", "rejected", "published", "deprecated"
+	SubmittedAt   *time.Time `gorm:"default:null"`                     // Last time the version was submitted for review
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+
+	// Outcome of the last verification of the Helm chart (only for Kubernetes-based apps)
+	ChartDigest  string             // SHA-256 of the verified chart archive
+	AppVersion   string             // Version of the packaged software, from the chart
+	Verification *ChartVerification `gorm:"type:jsonb;serializer:json"`
+
+	Plans []PricingPlan `gorm:"foreignKey:ApplicationVersionID"` // Consumers pick one on install; billed at the hourly rate of the application if none
+}
+
+// PricingPlan is a way to pay for the deployments of an application version.
+// Plans only change while their version is a draft, so the prices consumers are
+// billed are the ones reviewers approved.
+type PricingPlan struct {
+	ID                   uint `gorm:"primaryKey"`
+	ApplicationVersionID uint `gorm:"index"`
+	Name                 string
+	Type                 string      `gorm:"type:varchar(20)"` // One of billing.PlanTypes: "free", "monthly", "hourly", "tiered"
+	SetupFee             float64     // Charged once on install, on top of the plan type
+	MonthlyPrice         float64     // "monthly": charged when each month of use starts
+	HourlyRate           float64     // "hourly"
+	MinimumCharge        float64     // "hourly": least amount billed for a deployment
+	Tiers                []PriceTier `gorm:"type:jsonb;serializer:json"` // "tiered"
+	CreatedAt            time.Time
+}
+
+// PriceTier is the hourly rate of the hours of use past the previous tier, up to
+// UpToHours in total
+type PriceTier struct {
+	UpToHours  *float64 // nil for the last tier, covering all further hours
+	HourlyRate float64
+}
+
+// ChartVerification is the report of the checks of the Helm chart of an
+// application version, run when it is submitted for review
+type ChartVerification struct {
+	Passed       bool
+	ChartVersion string // Verified chart version, the latest one if the version doesn't pin it
+	Checks       []VerificationCheck
+	VerifiedAt   time.Time
+}
+
+// VerificationCheck is one step of a chart verification
+type VerificationCheck struct {
+	Name    string // Possible values: "index", "chart", "digest", "lint", "template"
+	Passed  bool
+	Skipped bool
+	Message string // What was checked, or why it failed
+	Output  string // Output of helm, trimmed
+}
+
+// VersionReview records a step of the review of an application version: its
+// submission by the publisher and the decision of an admin
+type VersionReview struct {
+	ID                   uint   `gorm:"primaryKey"`
+	ApplicationVersionID uint   `gorm:"index"`
+	UserID               uint   // Publisher or admin taking the step
+	Action               string `gorm:"type:varchar(20)"` // Possible values: "submitted", "withdrawn", "approved", "rejected"
+	Comment              string
+	CreatedAt            time.Time
+
+	User User `gorm:"foreignKey:UserID"`
+}
+
+// Quota overrides the default limits of a user, project or organization. Nil
+// limits use the default, negative ones are unlimited.
+type Quota struct {
+	ID                   uint   `gorm:"primaryKey"`
+	Scope                string `gorm:"type:varchar(20);uniqueIndex:idx_quotas_scope"` // "user", "project" or "organization"
+	ScopeID              uint   `gorm:"uniqueIndex:idx_quotas_scope"`
+	MaxActiveDeployments *int
+	MaxK8sDeployments    *int
+	MaxCPU               *float64 // Total vCPUs of VM deployments
+	MaxMemoryGB          *float64 // Total memory of VM deployments
+	MaxMonthlySpend      *float64 // Charges of the current calendar month
+	UpdatedAt            time.Time
 }
 
 // DeploymentSpec stores deployment-related data
@@ -40,11 +193,13 @@ type DeploymentSpec struct {
 }
 
 type Deployment struct {
-	ID             uint `gorm:"primaryKey"`
-	ConsumerID     uint
-	ApplicationID  uint
-	ProjectID      uint   // The project under which this deployment is managed
-	DeploymentType string `gorm:"type:varchar(10)"` // "k8s" or "vm"
+	ID                   uint `gorm:"primaryKey"`
+	ConsumerID           uint
+	ApplicationID        uint
+	ApplicationVersionID *uint  `gorm:"default:null"` // Version the deployment is pinned to
+	PricingPlanID        *uint  `gorm:"default:null"` // Plan picked on install, or on the version of the last upgrade
+	ProjectID            uint   // The project under which this deployment is managed
+	DeploymentType       string `gorm:"type:varchar(10)"` // "k8s" or "vm"
 
 	// Kubernetes-specific
 	ClusterName string `gorm:"default:null"` // KIND cluster name (if K8s-based)
@@ -53,23 +208,188 @@ type Deployment struct {
 	VMName string `gorm:"default:null"` // VM instance name (if VM-based)
 	VMIP   string `gorm:"default:null"` // IP of the created VM
 
+	// Chart values set by the consumer, changed through upgrades
+	Values map[string]interface{} `gorm:"type:jsonb;serializer:json"`
+
+	// Free trial, nothing is charged until TrialEndsAt
+	TrialEndsAt       *time.Time `gorm:"default:null;index"`
+	ConvertAfterTrial bool       // Keep the deployment on its paid plan once the trial ends, uninstalled otherwise
+	TrialNotifiedAt   *time.Time `gorm:"default:null"`     // When the consumer was told the trial is about to end
+	TrialOutcome      string     `gorm:"type:varchar(20)"` // Possible values: "" (running), "converted", "expired"
+
 	// Deployment status
-	Status string `gorm:"type:varchar(20);default:'pending'"` // Possible values: "pending", "installing", "installed", "failed"
+	Status string `gorm:"type:varchar(20);default:'pending'"` // One of lifecycle.Statuses, only changed through lifecycle.Transition
+
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	UninstalledAt *time.Time     `gorm:"default:null"` // Set once the resources were cleaned up
+	DeletedAt     gorm.DeletedAt `gorm:"index"`        // Uninstalled deployments are soft-deleted and kept for audit and billing
+
+	Consumer    User               `gorm:"foreignKey:ConsumerID"`
+	Application Application        `gorm:"foreignKey:ApplicationID"`
+	Version     ApplicationVersion `gorm:"foreignKey:ApplicationVersionID"`
+	Project     Project            `gorm:"foreignKey:ProjectID"`
+}
 
-	Consumer    User        `gorm:"foreignKey:ConsumerID"`
-	Application Application `gorm:"foreignKey:ApplicationID"`
-	Project     Project     `gorm:"foreignKey:ProjectID"`
+// SealedSecret is a set of secret values, envelope-encrypted. The zero value
+// holds no secret values.
+type SealedSecret struct {
+	Fields     []string `gorm:"type:jsonb;serializer:json"` // Dotted paths of the secret values, e.g. "auth.password"
+	KeyID      string   // Master key that wrapped the data key
+	WrappedKey []byte   // Data key encrypted with the master key
+	Ciphertext []byte   // Secret values as JSON, encrypted with the data key
 }
 
+// DeploymentSecret holds the secret values of the deployed revision of a
+// deployment, stored apart from its plain values
+type DeploymentSecret struct {
+	ID           uint         `gorm:"primaryKey"`
+	DeploymentID uint         `gorm:"uniqueIndex"`
+	Secret       SealedSecret `gorm:"embedded"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// DeploymentRevision records a version and values a deployment was installed,
+// upgraded or rolled back to
+type DeploymentRevision struct {
+	ID                   uint   `gorm:"primaryKey"`
+	DeploymentID         uint   `gorm:"uniqueIndex:idx_deployment_revisions_revision"`
+	Revision             int    `gorm:"uniqueIndex:idx_deployment_revisions_revision"` // 1 for the install, incremented on every upgrade and rollback
+	Action               string `gorm:"type:varchar(10)"`                              // Possible values: "install", "upgrade", "rollback"
+	ApplicationVersionID uint
+	Values               map[string]interface{} `gorm:"type:jsonb;serializer:json"`
+	RollbackTo           int                    `gorm:"default:null"`                       // Revision a rollback returns to
+	HelmRevision         int                    `gorm:"default:null"`                       // Revision of the Helm release once deployed
+	PricingPlanID        *uint                  `gorm:"default:null"`                       // Plan of the version it is billed on, nil for the hourly rate of the application
+	Status               string                 `gorm:"type:varchar(20);default:'pending'"` // Possible values: "pending", "deployed", "superseded", "failed"
+	Error                string                 `gorm:"default:null"`
+	Secret               SealedSecret           `gorm:"embedded;embeddedPrefix:secret_" json:"-"` // Secret values the revision deploys
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+
+	Version ApplicationVersion `gorm:"foreignKey:ApplicationVersionID"`
+}
+
+// DeploymentEvent records a status transition of a deployment
+type DeploymentEvent struct {
+	ID           uint   `gorm:"primaryKey"`
+	DeploymentID uint   `gorm:"index"`
+	FromStatus   string `gorm:"type:varchar(20)"`
+	ToStatus     string `gorm:"type:varchar(20)"`
+	Actor        string // Who caused the transition, e.g. "installer", "reconciler" or "api"
+	Message      string
+	Error        string `gorm:"default:null"` // Error that made the deployment fail
+	CreatedAt    time.Time
+}
+
+// BillingRecord is the current charges of a deployment, derived from its usage
+// events by billing.Refresh and never changed otherwise
 type BillingRecord struct {
 	ID            string     `gorm:"primaryKey"`
 	ConsumerID    string     `gorm:"index"`
 	DeploymentID  string     `gorm:"index"`
 	ApplicationID uint       `gorm:"index"`
-	HourlyRate    float64    // 💰 Cost per hour
-	Amount        float64    // 🔄 Total amount (updated hourly)
+	HourlyRate    float64    // 💰 Cost per hour, without a plan or on an hourly one
+	PricingPlanID *uint      `gorm:"default:null"` // Plan the deployment is billed on, if any
+	TrialEndsAt   *time.Time `gorm:"default:null"` // Charges accrue from the end of the free trial, if any
+	Amount        float64    // 🔄 Total amount, rated from the usage events (refreshed every five minutes)
 	StartTime     time.Time  // 📅 Start timestamp
 	EndTime       *time.Time `gorm:"default:null"` // 📅 End timestamp (null if running)
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
+
+	Plan *PricingPlan `gorm:"foreignKey:PricingPlanID"`
+}
+
+// UsageEvent is a fact about the billable usage of a deployment. Charges over any
+// time window are rated from the events, which are only ever inserted; mistakes
+// are corrected by appending events.
+type UsageEvent struct {
+	ID           uint      `gorm:"primaryKey"`
+	DeploymentID uint      `gorm:"index:idx_usage_events_deployment"`
+	Kind         string    `gorm:"type:varchar(20)"`                  // One of billing.EventKinds
+	OccurredAt   time.Time `gorm:"index:idx_usage_events_deployment"` // When it took effect, earlier than CreatedAt for corrections
+
+	// Pricing terms from the event on, set on "started" and "price_changed" events.
+	// The plan is copied as the catalog may delete it.
+	HourlyRate  float64
+	Plan        *PricingPlan `gorm:"type:jsonb;serializer:json"`
+	TrialEndsAt *time.Time   `gorm:"default:null"` // End of the free trial, set on "started" events
+
+	ApplicationVersionID *uint  `gorm:"default:null"` // Version running from the event on, set on "started" and "upgraded" events
+	Actor                string // Who recorded it, e.g. "installer", "api" or "admin"
+	Message              string
+	CreatedAt            time.Time
+}
+
+// Notification tells a user about something that happened to their resources
+type Notification struct {
+	ID           uint   `gorm:"primaryKey"`
+	UserID       uint   `gorm:"index"`
+	DeploymentID *uint  `gorm:"default:null"`
+	Kind         string `gorm:"type:varchar(30)"` // Possible values: "trial_ending", "trial_converted", "trial_expired"
+	Message      string
+	ReadAt       *time.Time `gorm:"default:null"`
+	CreatedAt    time.Time
+}
+
+// AuditEntry records a mutating API call or an action of a background worker.
+// Entries are only ever inserted, the database rejects updates and deletes.
+type AuditEntry struct {
+	ID           uint   `gorm:"primaryKey"`
+	Actor        string `gorm:"index"`              // "user" for API calls, otherwise the worker, e.g. "installer" or "billing"
+	UserID       *uint  `gorm:"index;default:null"` // Authenticated caller of an API call
+	Action       string `gorm:"index"`              // e.g. "DELETE /api/deployments/{id}" or "install"
+	ResourceType string `gorm:"index:idx_audit_entries_resource"`
+	ResourceID   string `gorm:"index:idx_audit_entries_resource"`
+	RequestID    string `gorm:"index;default:null"`
+	StatusCode   int    `gorm:"default:null"` // Response status of an API call
+	Outcome      string // Possible values: "success", "failure"
+	Error        string `gorm:"default:null"`
+
+	// Changed fields of the resource with their "before" and "after" values
+	Changes map[string]interface{} `gorm:"type:jsonb;serializer:json"`
+
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// OutboxMessage is a queue job written in the same transaction as the change
+// that caused it and published by the outbox relay once committed
+type OutboxMessage struct {
+	ID        uint `gorm:"primaryKey"`
+	Queue     string
+	Payload   map[string]string `gorm:"type:jsonb;serializer:json"`
+	Attempts  int               // Failed publish attempts so far
+	LastError string
+	SentAt    *time.Time `gorm:"default:null;index"` // Null until published to the queue
+	CreatedAt time.Time
+}
+
+// IdempotencyKey stores the response of a request sent with an Idempotency-Key
+// header so retries of the same request get the same response
+type IdempotencyKey struct {
+	UserID      uint   `gorm:"primaryKey;autoIncrement:false"` // Keys are picked by clients, so each user has their own
+	Key         string `gorm:"primaryKey"`
+	RequestHash string // SHA-256 of the method, path and body of the original request
+	StatusCode  int    // 0 while the original request is in progress
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time `gorm:"index"`
+}
+
+// QueueJob is a job of the Postgres queue backend
+type QueueJob struct {
+	ID          uint              `gorm:"primaryKey"`
+	Queue       string            `gorm:"index:idx_queue_jobs_queue_status"`
+	Status      string            `gorm:"type:varchar(10);default:'ready';index:idx_queue_jobs_queue_status"` // Possible values: "ready", "running", "dead"
+	Payload     map[string]string `gorm:"type:jsonb;serializer:json"`
+	Attempts    int
+	LastError   string
+	AvailableAt time.Time  // Not handed out before this time (retry backoff)
+	LockedBy    string     `gorm:"default:null"` // Consumer currently processing the job
+	LockedUntil *time.Time `gorm:"default:null"` // Job is reclaimed by another consumer after this time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
 }